@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// checkOptions defines the optional checks run by checkConfig in addition to
+// the validation of the configuration.
+type checkOptions struct {
+	// fetch makes checkConfig fetch and validate the CRL from every
+	// distribution point.
+	fetch bool
+
+	// bigip makes checkConfig connect to every BigIP and look up the
+	// client-ssl profile of every CRL. No change is made on the BigIP.
+	bigip bool
+}
+
+// checkConfig reads and validates the configuration file, runs the optional
+// checks and writes a report to out. It reports whether no problem has been
+// found. Deprecated settings are reported but are not considered as problems.
+func checkConfig(path string, opts checkOptions, out io.Writer) bool {
+	cfg, err := readConfig(path)
+	if err != nil {
+		fmt.Fprintln(out, path+":", err)
+		return false
+	}
+	for _, msg := range cfg.deprecations {
+		fmt.Fprintln(out, path+": warning:", msg)
+	}
+	fmt.Fprintln(out, path+": configuration is valid")
+
+	ok := true
+	if opts.fetch && !checkDistributionPoints(cfg, out) {
+		ok = false
+	}
+	if opts.bigip && !checkBigIPs(cfg, out) {
+		ok = false
+	}
+	return ok
+}
+
+// checkDistributionPoints fetches, parses and, if enabled, validates the CRL
+// from every distribution point of every CRL of the configuration.
+func checkDistributionPoints(cfg *config, out io.Writer) bool {
+	ok := true
+	for _, crlCfg := range cfg.CRL {
+		p := new(pool)
+		if err := p.addWorker(crlCfg); err != nil {
+			fmt.Fprintln(out, err)
+			ok = false
+			continue
+		}
+		w := p.workers[0]
+		for _, url := range w.urls {
+			fetched, err := w.fetchFrom(url, nil)
+			if err != nil {
+				fmt.Fprintf(out, "crl %q: %s: %v\n", w.crlName, url, err)
+				ok = false
+				continue
+			}
+			fmt.Fprintf(out, "crl %q: %s: ok (%s)\n", w.crlName, url, describeCRL(fetched.crl))
+		}
+	}
+	return ok
+}
+
+// describeCRL returns a short human readable description of a CRL.
+func describeCRL(crl *pkix.CertificateList) string {
+	tbs := crl.TBSCertList
+	desc := "issuer " + formatName(tbs.Issuer)
+	if number, err := crlNumber(crl); err == nil && number != nil {
+		desc += ", number " + number.String()
+	}
+	desc += fmt.Sprintf(", %d revoked, next update %s", len(tbs.RevokedCertificates), tbs.NextUpdate.UTC().Format("2006-01-02 15:04:05 MST"))
+	return desc
+}
+
+// checkBigIPs connects to every BigIP of the configuration and makes sure that
+// the profiles of every CRL, as well as the device group to sync to, if any,
+// can be retrieved. Only read requests are sent.
+func checkBigIPs(cfg *config, out io.Writer) bool {
+	ok := true
+	for _, f5Cfg := range cfg.F5 {
+		f5Client, err := initF5Client(f5Cfg)
+		if err != nil {
+			fmt.Fprintf(out, "bigip %s: cannot initialize f5 client: %v\n", f5Cfg.URL, err)
+			ok = false
+			continue
+		}
+		if f5Cfg.HAMode == haModeActiveOnly {
+			if state, err := getFailoverState(f5Client); err != nil {
+				fmt.Fprintf(out, "bigip %s: %v\n", f5Cfg.URL, err)
+				ok = false
+			} else {
+				fmt.Fprintf(out, "bigip %s: failover state: %s\n", f5Cfg.URL, state)
+			}
+		}
+		if group := f5Cfg.SyncDeviceGroup; group != "" {
+			if typ, err := getDeviceGroupType(f5Client, group); err != nil {
+				fmt.Fprintf(out, "bigip %s: device group %q: %v\n", f5Cfg.URL, group, err)
+				ok = false
+			} else {
+				fmt.Fprintf(out, "bigip %s: device group %q: ok (type %s)\n", f5Cfg.URL, group, typ)
+			}
+		}
+		for _, crlCfg := range cfg.CRL {
+			selector, err := newProfileSelector(crlCfg)
+			if err != nil {
+				fmt.Fprintf(out, "crl %q: %v\n", crlCfg.Name, err)
+				ok = false
+				continue
+			}
+			if selector.discover {
+				fmt.Fprintf(out, "bigip %s: crl %q: profiles trusting the crl issuer are discovered at run time, use -dry-run to list them\n", f5Cfg.URL, crlCfg.Name)
+				selector.discover = false
+				if !selector.hasPattern() && len(selector.refs) == 0 {
+					continue
+				}
+			}
+			profiles, err := selector.resolve(f5Client, nil, newLogger(out))
+			if err != nil {
+				fmt.Fprintf(out, "bigip %s: crl %q: %v\n", f5Cfg.URL, crlCfg.Name, err)
+				ok = false
+				continue
+			}
+			for _, ref := range profiles {
+				profile, err := getProfile(f5Client, ref)
+				if err != nil {
+					fmt.Fprintf(out, "bigip %s: %s: %v\n", f5Cfg.URL, ref, err)
+					ok = false
+					continue
+				}
+				fmt.Fprintf(out, "bigip %s: %s: ok (crl file %q)\n", f5Cfg.URL, ref, profile.crlFile)
+			}
+		}
+	}
+	return ok
+}
+
+// formatName returns a short string representation of a distinguished name,
+// e.g. "CN=localhost, O=e-Xpert Solutions SA, C=CH".
+func formatName(rdn pkix.RDNSequence) string {
+	var name pkix.Name
+	name.FillFromRDNSequence(&rdn)
+	attrs := []struct {
+		typ    string
+		values []string
+	}{
+		{"CN", []string{name.CommonName}},
+		{"OU", name.OrganizationalUnit},
+		{"O", name.Organization},
+		{"L", name.Locality},
+		{"ST", name.Province},
+		{"C", name.Country},
+	}
+	var parts []string
+	for _, attr := range attrs {
+		for _, value := range attr.values {
+			if value != "" {
+				parts = append(parts, attr.typ+"="+value)
+			}
+		}
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCheckConfig(t *testing.T) {
+	tsCA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/test.crl" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(crlFile))
+	}))
+	defer tsCA.Close()
+
+	bigIP := newBigIPServer()
+	bigIP.crlFile = "/Common/test.crl"
+	bigIP.deviceGroups = map[string]string{"failover": "sync-failover"}
+	tsBigIP := httptest.NewServer(bigIP)
+	defer tsBigIP.Close()
+
+	validConfig := `[[f5]]
+auth_method = "basic"
+url = "` + tsBigIP.URL + `"
+user = "admin"
+password = "admin"
+
+[[crl]]
+name = "test"
+url = "` + tsCA.URL + `/test.crl"
+profile_name = "clientssl"
+validate = true
+issuer_ca = "misc/x509/test.crt"
+`
+
+	tests := []struct {
+		name       string
+		data       string
+		opts       checkOptions
+		wantOK     bool
+		wantOutput []string
+	}{
+		{
+			name:   "Valid",
+			data:   validConfig,
+			wantOK: true,
+			wantOutput: []string{
+				": configuration is valid\n",
+			},
+		},
+		{
+			name:   "Invalid",
+			data:   "[[crl]]\nname = \"test\"\nrefresh_delay = 6\n",
+			wantOK: false,
+			wantOutput: []string{
+				": invalid configuration:\n",
+				"  f5: no bigip provided",
+				"  line 1: crl[0].profile_name: missing value, either profile_name, profiles, profile_glob, profile_regex or discover_profiles must be provided\n",
+			},
+		},
+		{
+			name:   "Fetch",
+			data:   validConfig + "urls = [\"" + tsCA.URL + "/missing.crl\"]\n",
+			opts:   checkOptions{fetch: true},
+			wantOK: false,
+			wantOutput: []string{
+				"crl \"test\": " + tsCA.URL + "/test.crl: ok (issuer CN=localhost, OU=IT, O=e-Xpert Solutions SA, L=Plan-les-Ouates, ST=Geneva, C=CH, 0 revoked, next update 2023-02-25 12:04:10 UTC)\n",
+				"crl \"test\": " + tsCA.URL + "/missing.crl: cannot fetch crl due to http error: 404 Not Found\n",
+			},
+		},
+		{
+			name:   "BigIP",
+			data:   validConfig + "\n[[crl]]\nname = \"other\"\nurl = \"" + tsCA.URL + "/test.crl\"\nprofile_name = \"missing\"\n",
+			opts:   checkOptions{bigip: true},
+			wantOK: false,
+			wantOutput: []string{
+				"bigip " + tsBigIP.URL + ": client-ssl profile \"/Common/clientssl\": ok (crl file \"/Common/test.crl\")\n",
+				"bigip " + tsBigIP.URL + ": client-ssl profile \"/Common/missing\": ",
+			},
+		},
+		{
+			name:   "Device Group",
+			data:   strings.Replace(validConfig, "user = \"admin\"\n", "user = \"admin\"\nsync_device_group = \"failover\"\n", 1),
+			opts:   checkOptions{bigip: true},
+			wantOK: true,
+			wantOutput: []string{
+				"bigip " + tsBigIP.URL + ": device group \"failover\": ok (type sync-failover)\n",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := createTempConfigFile(test.data)
+			if err != nil {
+				t.Fatal("setup: ", err)
+			}
+			defer os.Remove(f.Name())
+			defer f.Close()
+
+			var out bytes.Buffer
+			if got := checkConfig(f.Name(), test.opts, &out); got != test.wantOK {
+				t.Errorf("checkConfig: got %v; want %v", got, test.wantOK)
+			}
+			for _, want := range test.wantOutput {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("checkConfig: got output\n%s\nwant it to contain %q", out.String(), want)
+				}
+			}
+		})
+	}
+}
@@ -2,17 +2,66 @@ package main
 
 import (
 	"errors"
+	"io/ioutil"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/BurntSushi/toml"
 )
 
+// Bounds and default value of the refresh delay of a CRL.
+const (
+	defaultRefreshDelay = 1 * time.Hour
+	minRefreshDelay     = 1 * time.Minute
+	maxRefreshDelay     = 30 * 24 * time.Hour
+)
+
 type crlConfig struct {
-	URL          string   `toml:"url"`
-	Name         string   `toml:"name"`
-	ProfileName  string   `toml:"profile_name"`
-	RefreshDelay duration `toml:"refresh_delay"`
-	Validate     bool     `toml:"validate"`
+	URL              string   `toml:"url"`
+	URLs             []string `toml:"urls"`
+	Name             string   `toml:"name"`
+	Partition        string   `toml:"partition"`
+	ProfileName      string   `toml:"profile_name"`
+	Profiles         []string `toml:"profiles"`
+	ProfileGlob      string   `toml:"profile_glob"`
+	ProfileRegex     string   `toml:"profile_regex"`
+	DiscoverProfiles bool     `toml:"discover_profiles"`
+	RefreshDelay     duration `toml:"refresh_delay"`
+	Validate         bool     `toml:"validate"`
+	IssuerCA         string   `toml:"issuer_ca"`
+	ForcePush        bool     `toml:"force_push"`
+	KeepLast         int      `toml:"keep_last"`
+	MaxAge           duration `toml:"max_age"`
+
+	Schedule       string   `toml:"schedule"`
+	ScheduleOffset duration `toml:"schedule_offset"`
+	Jitter         duration `toml:"jitter"`
+
+	RetryMaxAttempts    int      `toml:"retry_max_attempts"`
+	RetryInitialBackoff duration `toml:"retry_initial_backoff"`
+	RetryMaxBackoff     duration `toml:"retry_max_backoff"`
+	RetryJitter         duration `toml:"retry_jitter"`
+}
+
+// urls returns the ordered list of distribution points of the CRL, the one
+// defined by the "url" key, if any, coming first.
+func (c crlConfig) urls() []string {
+	var urls []string
+	if c.URL != "" {
+		urls = append(urls, c.URL)
+	}
+	return append(urls, c.URLs...)
+}
+
+// profileNames returns the names of the client-ssl profiles explicitly listed
+// for the CRL, the one defined by the "profile_name" key, if any, coming first.
+func (c crlConfig) profileNames() []string {
+	var names []string
+	if c.ProfileName != "" {
+		names = append(names, c.ProfileName)
+	}
+	return append(names, c.Profiles...)
 }
 
 type f5Config struct {
@@ -21,12 +70,137 @@ type f5Config struct {
 	User              string `toml:"user"`
 	Password          string `toml:"password"`
 	SSLCheck          bool   `toml:"ssl_check"`
-	LoginProviderName string `toml:"login_provided_name"`
+	LoginProviderName string `toml:"login_provider_name"`
+
+	// SyncDeviceGroup is the device group the configuration is synced to
+	// after a CRL has been pushed. SyncTimeout bounds the time spent waiting
+	// for the device group to be in sync.
+	SyncDeviceGroup string   `toml:"sync_device_group"`
+	SyncTimeout     duration `toml:"sync_timeout"`
+
+	// SaveConfig enables saving the running configuration after a CRL has
+	// been pushed.
+	SaveConfig bool `toml:"save_config"`
+
+	// HAMode is set to "active-only" to push the CRLs only when the BigIP is
+	// the active unit of SyncDeviceGroup.
+	HAMode string `toml:"ha_mode"`
+
+	// LegacyLoginProviderName holds the misspelled key supported by former
+	// versions. Use LoginProviderName instead.
+	LegacyLoginProviderName string `toml:"login_provided_name"`
+}
+
+// metricsConfig defines the HTTP listener serving the Prometheus metrics.
+type metricsConfig struct {
+	// Listen is the address the metrics are served on, e.g. ":9100".
+	// Metrics are disabled when empty.
+	Listen string `toml:"listen"`
+}
+
+// healthConfig defines the HTTP listener serving the liveness and readiness
+// probes.
+type healthConfig struct {
+	// Listen is the address /healthz and /readyz are served on. It may be
+	// the same as the one of the metrics. The probes are disabled when
+	// empty.
+	Listen string `toml:"listen"`
+
+	// StaleAfter is the maximum time since the last successful push of a
+	// CRL to a BigIP, twice the refresh delay of the CRL by default.
+	// ExpiryThreshold is the minimum time left before a deployed CRL
+	// expires.
+	StaleAfter      duration `toml:"stale_after"`
+	ExpiryThreshold duration `toml:"expiry_threshold"`
+}
+
+// logConfig defines how events are logged.
+type logConfig struct {
+	// Output is either "stderr", the default, "syslog" or "journald".
+	Output string `toml:"output"`
+
+	// Format is either "text", the default, or "json". It only applies to
+	// the stderr output.
+	Format string `toml:"format"`
+
+	// Level is the minimum level of the logged events, "info" by default.
+	Level string `toml:"level"`
+
+	// Network is the protocol used to reach the syslog server: "udp", the
+	// default, "tcp" or "unix".
+	Network string `toml:"network"`
+
+	// Address is the host:port of the syslog server, or the path of its
+	// socket. With the journald output, it overrides the path of the journal
+	// socket.
+	Address string `toml:"address"`
+
+	// Facility is the syslog facility of the events, "daemon" by default.
+	Facility string `toml:"facility"`
+
+	// Tag identifies the program in syslog and in the journal,
+	// "crl2f5-connector" by default.
+	Tag string `toml:"tag"`
+
+	// SDID is the SD-ID under which the fields of the events are sent as
+	// structured data to syslog, e.g. "crl2f5@12345" where 12345 is the
+	// Private Enterprise Number of the organization. When empty, the fields
+	// are appended to the message instead.
+	SDID string `toml:"sd_id"`
+
+	// Severities maps log levels to syslog severities, overriding the
+	// default mapping of the levels they list.
+	Severities map[string]string `toml:"severities"`
+}
+
+// notifyConfig defines a webhook notified of the failures of the workers.
+type notifyConfig struct {
+	// Name identifies the webhook in logs, its url by default.
+	Name string `toml:"name"`
+
+	// URL and Method define the request sent for each event, a POST by
+	// default.
+	URL    string `toml:"url"`
+	Method string `toml:"method"`
+
+	// Events lists the events the webhook is notified of: fetch_failure,
+	// push_failure, expiring and recovery. All of them by default.
+	Events []string `toml:"events"`
+
+	// Template is the text/template of the JSON body of the requests. A
+	// JSON document describing the event is sent by default.
+	Template string `toml:"template"`
+
+	// Headers are added to the requests.
+	Headers map[string]string `toml:"headers"`
+
+	// Timeout bounds the duration of a request, 10s by default.
+	Timeout duration `toml:"timeout"`
+
+	// RepeatInterval is the minimum time between two notifications of the
+	// same ongoing failure, 1h by default. ExpiryThreshold is the time
+	// before a deployed CRL expires from which it is notified, 24h by
+	// default.
+	RepeatInterval  duration `toml:"repeat_interval"`
+	ExpiryThreshold duration `toml:"expiry_threshold"`
 }
 
 type config struct {
-	F5  []f5Config  `toml:"f5"`
-	CRL []crlConfig `toml:"crl"`
+	F5      []f5Config     `toml:"f5"`
+	CRL     []crlConfig    `toml:"crl"`
+	Log     logConfig      `toml:"log"`
+	Metrics metricsConfig  `toml:"metrics"`
+	Health  healthConfig   `toml:"health"`
+	Notify  []notifyConfig `toml:"notify"`
+
+	// deprecations lists the deprecated settings found while reading the
+	// configuration.
+	deprecations []string
+
+	// keys locates the keys of the configuration file and undecoded lists
+	// the ones that do not match any setting. Both are used by validate.
+	keys      *keyIndex
+	undecoded []string
 }
 
 func readConfig(path string) (*config, error) {
@@ -36,14 +210,57 @@ func readConfig(path string) (*config, error) {
 	}
 	defer file.Close()
 
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, errors.New("cannot read configuration file: " + err.Error())
+	}
+
 	var cfg config
-	if _, err := toml.DecodeReader(file, &cfg); err != nil {
+	md, err := toml.Decode(string(data), &cfg)
+	if err != nil {
 		return nil, errors.New("cannot read configuration file: " + err.Error())
 	}
+	cfg.keys = newKeyIndex(string(data))
+	for _, key := range md.Undecoded() {
+		cfg.undecoded = append(cfg.undecoded, key.String())
+	}
 
+	for i := range cfg.F5 {
+		f5Cfg := &cfg.F5[i]
+		if f5Cfg.LegacyLoginProviderName == "" {
+			continue
+		}
+		key := "f5[" + strconv.Itoa(i) + "].login_provided_name"
+		cfg.deprecate(key, "deprecated misspelled key, use login_provider_name instead")
+		if f5Cfg.LoginProviderName == "" {
+			f5Cfg.LoginProviderName = f5Cfg.LegacyLoginProviderName
+		}
+	}
+	for i := range cfg.CRL {
+		crlCfg := &cfg.CRL[i]
+		key := "crl[" + strconv.Itoa(i) + "].refresh_delay"
+		// Omitted keys cannot be told apart from zero values once decoded
+		// into the config structure, hence the lookup in the key index.
+		if !cfg.keys.isDefined(key) {
+			crlCfg.RefreshDelay.Duration = defaultRefreshDelay
+		}
+		if crlCfg.RefreshDelay.legacyHours {
+			cfg.deprecate(key, "bare number of hours is deprecated, use a duration such as \""+
+				crlCfg.RefreshDelay.String()+"\" instead")
+		}
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
 	return &cfg, nil
 }
 
+// deprecate records the use of a deprecated setting.
+func (c *config) deprecate(key, msg string) {
+	c.deprecations = append(c.deprecations, configError{key: key, line: c.keys.line(key), msg: msg}.Error())
+}
+
 // hasCRLDistributionPoint reports whether the config defines at least one CRL
 // distribution point.
 func (c config) hasCRLDistributionPoint() bool {
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"io/ioutil"
 	"os"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -21,6 +22,7 @@ name = "test"
 profile_name = "clientssl"
 refresh_delay = "6h"
 validate = true
+issuer_ca = "misc/x509/test.crt"
 `
 const invalidConfigFileContent = `{invalid}`
 
@@ -155,6 +157,133 @@ func testReadConfigFailDecode(t *testing.T, invalidFile *os.File) {
 	}
 }
 
+func TestReadConfigDurations(t *testing.T) {
+	const header = `[[f5]]
+auth_method = "basic"
+url = "https://localhost"
+user = "admin"
+
+[[crl]]
+name = "test"
+url = "http://localhost/test.crl"
+profile_name = "clientssl"
+`
+	tests := []struct {
+		crl              string
+		wantRefreshDelay time.Duration
+		wantDeprecations []string
+		wantErr          string
+	}{
+		{
+			crl:              `refresh_delay = "90m"`,
+			wantRefreshDelay: 90 * time.Minute,
+		},
+		{
+			crl:              ``,
+			wantRefreshDelay: defaultRefreshDelay,
+		},
+		{
+			crl:              `refresh_delay = 6`,
+			wantRefreshDelay: 6 * time.Hour,
+			wantDeprecations: []string{
+				"line 10: crl[0].refresh_delay: bare number of hours is deprecated, use a duration such as \"6h0m0s\" instead",
+			},
+		},
+		{
+			crl:              `refresh_delay = "6"`,
+			wantRefreshDelay: 6 * time.Hour,
+			wantDeprecations: []string{
+				"line 10: crl[0].refresh_delay: bare number of hours is deprecated, use a duration such as \"6h0m0s\" instead",
+			},
+		},
+		{
+			crl:     `refresh_delay = "0s"`,
+			wantErr: "invalid configuration:\n  line 10: crl[0].refresh_delay: must be positive, got 0s",
+		},
+		{
+			crl:     `refresh_delay = "-1h"`,
+			wantErr: "invalid configuration:\n  line 10: crl[0].refresh_delay: must be positive, got -1h0m0s",
+		},
+		{
+			crl:     `refresh_delay = "1s"`,
+			wantErr: "invalid configuration:\n  line 10: crl[0].refresh_delay: 1s is too short (minimum is 1m0s)",
+		},
+		{
+			crl:     `refresh_delay = "6h"` + "\n" + `max_age = 24`,
+			wantErr: "invalid configuration:\n  line 11: crl[0].max_age: must have a unit, e.g. \"6h\"",
+		},
+		{
+			crl:     `refresh_delay = "6h"` + "\n" + `jitter = "-5m"`,
+			wantErr: "invalid configuration:\n  line 11: crl[0].jitter: must not be negative, got -5m0s",
+		},
+		{
+			crl:     `refresh_delay = "6h"` + "\n" + `jitter = "6h"`,
+			wantErr: "invalid configuration:\n  line 11: crl[0].jitter: 6h0m0s must be shorter than the refresh delay (6h0m0s)",
+		},
+		{
+			crl:     `refresh_delay = "2500000h"`,
+			wantErr: "invalid configuration:\n  line 10: crl[0].refresh_delay: 2500000h0m0s is too long (maximum is 720h0m0s)",
+		},
+	}
+	for i, test := range tests {
+		f, err := createTempConfigFile(header + test.crl + "\n")
+		if err != nil {
+			t.Fatal("setup: ", err)
+		}
+		cfg, err := readConfig(f.Name())
+		f.Close()
+		os.Remove(f.Name())
+
+		if test.wantErr != "" {
+			if err == nil {
+				t.Errorf("%d. readConfig: expected error %q, got nil", i, test.wantErr)
+			} else if err.Error() != test.wantErr {
+				t.Errorf("%d. readConfig: got error %q; want %q", i, err.Error(), test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d. readConfig: unexpected error %q", i, err.Error())
+			continue
+		}
+		if got := cfg.CRL[0].RefreshDelay.Duration; got != test.wantRefreshDelay {
+			t.Errorf("%d. readConfig: got refresh_delay %v; want %v", i, got, test.wantRefreshDelay)
+		}
+		if !reflect.DeepEqual(cfg.deprecations, test.wantDeprecations) {
+			t.Errorf("%d. readConfig: got deprecations %q; want %q", i, cfg.deprecations, test.wantDeprecations)
+		}
+	}
+}
+
+func TestCRLConfig_URLs(t *testing.T) {
+	tests := []struct {
+		cfg  crlConfig
+		want []string
+	}{
+		{
+			cfg:  crlConfig{},
+			want: nil,
+		},
+		{
+			cfg:  crlConfig{URL: "http://a/test.crl"},
+			want: []string{"http://a/test.crl"},
+		},
+		{
+			cfg:  crlConfig{URLs: []string{"http://b/test.crl", "file:///c/test.crl"}},
+			want: []string{"http://b/test.crl", "file:///c/test.crl"},
+		},
+		{
+			cfg:  crlConfig{URL: "http://a/test.crl", URLs: []string{"http://b/test.crl"}},
+			want: []string{"http://a/test.crl", "http://b/test.crl"},
+		},
+	}
+	for i, test := range tests {
+		if got := test.cfg.urls(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%d. crlConfig.urls: got %v; want %v", i, got, test.want)
+		}
+	}
+}
+
 func TestConfig_HasCRLDitstibutionPoint(t *testing.T) {
 	tests := []struct {
 		cfg  config
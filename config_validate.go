@@ -0,0 +1,631 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// configError describes a problem found in the configuration file.
+type configError struct {
+	// key is the path of the faulty key, e.g. "crl[1].profile_name".
+	key string
+
+	// line is the line of the key in the configuration file, or of its table
+	// when the key is missing. 0 means unknown.
+	line int
+
+	msg string
+}
+
+func (e configError) Error() string {
+	if e.line > 0 {
+		return "line " + strconv.Itoa(e.line) + ": " + e.key + ": " + e.msg
+	}
+	return e.key + ": " + e.msg
+}
+
+// configErrors aggregates all the problems found in the configuration file.
+type configErrors []configError
+
+func (errs configErrors) Error() string {
+	var buf bytes.Buffer
+	buf.WriteString("invalid configuration:")
+	for _, err := range errs {
+		buf.WriteString("\n  ")
+		buf.WriteString(err.Error())
+	}
+	return buf.String()
+}
+
+// keyIndex maps the keys and tables of a TOML document to their line. Array
+// tables are indexed, e.g. "crl[1].name" is the name of the second CRL.
+//
+// The TOML decoder does not report the position of the keys, hence this
+// minimal scanner. It understands what is needed to locate keys in the
+// configuration file, i.e. tables, array tables, comments, multi-line strings
+// and arrays.
+type keyIndex struct {
+	lines map[string]int
+	keys  []string // in order of appearance
+}
+
+var arrayIndexRegexp = regexp.MustCompile(`\[\d+\]`)
+
+func newKeyIndex(data string) *keyIndex {
+	idx := &keyIndex{lines: make(map[string]int)}
+	var (
+		prefix       string
+		counts       = make(map[string]int)
+		depth        int    // nesting level of the current multi-line array
+		endDelimiter string // closing delimiter of the current multi-line string
+	)
+	for i, line := range strings.Split(data, "\n") {
+		lineNo := i + 1
+		if endDelimiter != "" {
+			if strings.Contains(line, endDelimiter) {
+				endDelimiter = ""
+			}
+			continue
+		}
+		if depth > 0 {
+			depth += bracketDepth(line)
+			continue
+		}
+
+		l := strings.TrimSpace(stripComment(line))
+		switch {
+		case l == "":
+		case strings.HasPrefix(l, "[["):
+			name := strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(l, "[["), "]]"))
+			prefix = name + "[" + strconv.Itoa(counts[name]) + "]"
+			counts[name]++
+			idx.add(prefix, lineNo)
+		case strings.HasPrefix(l, "["):
+			prefix = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(l, "["), "]"))
+			idx.add(prefix, lineNo)
+		default:
+			eq := strings.Index(l, "=")
+			if eq < 0 {
+				continue
+			}
+			key := strings.Trim(strings.TrimSpace(l[:eq]), `"'`)
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			idx.add(key, lineNo)
+
+			value := strings.TrimSpace(l[eq+1:])
+			switch {
+			case strings.HasPrefix(value, `"""`) && strings.Count(value, `"""`) == 1:
+				endDelimiter = `"""`
+			case strings.HasPrefix(value, "'''") && strings.Count(value, "'''") == 1:
+				endDelimiter = "'''"
+			case strings.HasPrefix(value, "["):
+				depth = bracketDepth(value)
+			}
+		}
+	}
+	return idx
+}
+
+func (idx *keyIndex) add(key string, line int) {
+	if _, ok := idx.lines[key]; !ok {
+		idx.keys = append(idx.keys, key)
+	}
+	idx.lines[key] = line
+}
+
+// isDefined reports whether the key is defined in the document.
+func (idx *keyIndex) isDefined(key string) bool {
+	_, ok := idx.lines[key]
+	return ok
+}
+
+// line returns the line of the key or, if it is not defined, the line of the
+// closest enclosing table. 0 is returned when none of them is defined.
+func (idx *keyIndex) line(key string) int {
+	for {
+		if line, ok := idx.lines[key]; ok {
+			return line
+		}
+		i := strings.LastIndex(key, ".")
+		if i < 0 {
+			return 0
+		}
+		key = key[:i]
+	}
+}
+
+// stripComment removes the trailing comment, if any, of a TOML line.
+func stripComment(line string) string {
+	var quote rune
+	for i, c := range line {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// bracketDepth returns the number of square brackets opened but not closed on
+// a line, ignoring the ones within strings and comments.
+func bracketDepth(line string) int {
+	var (
+		depth int
+		quote rune
+	)
+	for _, c := range stripComment(line) {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		}
+	}
+	return depth
+}
+
+// tomlKeys returns the keys that can be decoded into a structure of type t.
+func tomlKeys(t reflect.Type) []string {
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue // unexported
+		}
+		key := strings.Split(f.Tag.Get("toml"), ",")[0]
+		if key == "" {
+			key = f.Name
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// knownKeys lists, for each table of the configuration, the supported keys.
+var knownKeys = map[string][]string{
+	"f5":      tomlKeys(reflect.TypeOf(f5Config{})),
+	"crl":     tomlKeys(reflect.TypeOf(crlConfig{})),
+	"log":     tomlKeys(reflect.TypeOf(logConfig{})),
+	"metrics": tomlKeys(reflect.TypeOf(metricsConfig{})),
+	"health":  tomlKeys(reflect.TypeOf(healthConfig{})),
+	"notify":  tomlKeys(reflect.TypeOf(notifyConfig{})),
+}
+
+// suggestKey returns the known key of the table that is the closest to key, or
+// an empty string if none of them is close enough to be a typo.
+func suggestKey(table, key string) string {
+	var (
+		best     string
+		bestDist = 3
+	)
+	for _, known := range knownKeys[table] {
+		if d := levenshtein(key, known); d < bestDist {
+			best, bestDist = known, d
+		}
+	}
+	return best
+}
+
+// levenshtein returns the edit distance between a and b.
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, minInt(cur[j-1]+1, prev[j-1]+cost))
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// crlNameRegexp matches the CRL names that can be used as BigIP object names
+// once suffixed with a timestamp.
+var crlNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// partitionRegexp matches the names of BigIP administrative partitions.
+var partitionRegexp = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.-]*$`)
+
+// configValidator collects the problems found in a configuration.
+type configValidator struct {
+	idx  *keyIndex
+	errs configErrors
+}
+
+func (v *configValidator) add(key string, format string, args ...interface{}) {
+	v.errs = append(v.errs, configError{
+		key:  key,
+		line: v.idx.line(key),
+		msg:  fmt.Sprintf(format, args...),
+	})
+}
+
+// checkListenAddress makes sure that addr, if set, is a host:port address.
+func (v *configValidator) checkListenAddress(key, addr string) {
+	if addr == "" {
+		return
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		v.add(key, "invalid listen address %q, must be host:port such as \":9100\"", addr)
+	}
+}
+
+// checkURL makes sure that rawurl is an absolute URL using one of the given
+// schemes.
+func (v *configValidator) checkURL(key, rawurl string, schemes ...string) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		v.add(key, "malformed url %q: %v", rawurl, err)
+		return
+	}
+	for _, scheme := range schemes {
+		if u.Scheme != scheme {
+			continue
+		}
+		if scheme == "file" {
+			if u.Path == "" {
+				v.add(key, "malformed url %q: missing path", rawurl)
+			}
+		} else if u.Host == "" {
+			v.add(key, "malformed url %q: missing host", rawurl)
+		}
+		return
+	}
+	v.add(key, "malformed url %q: scheme must be one of %s", rawurl, strings.Join(schemes, ", "))
+}
+
+// validate checks the whole configuration and reports all the problems found
+// at once, along with their key path and line. It must be called once the
+// configuration has been read by readConfig.
+func (c *config) validate() error {
+	idx := c.keys
+	if idx == nil {
+		idx = newKeyIndex("")
+	}
+	v := &configValidator{idx: idx}
+
+	c.checkUndecoded(v)
+	if len(c.F5) == 0 {
+		v.add("f5", "no bigip provided, at least one [[f5]] table is required")
+	}
+	for i := range c.F5 {
+		c.F5[i].validate(v, "f5["+strconv.Itoa(i)+"]")
+	}
+	if !c.hasCRLDistributionPoint() {
+		v.add("crl", "no crl distribution point provided, at least one [[crl]] table is required")
+	}
+	names := make(map[string]string)
+	for i := range c.CRL {
+		prefix := "crl[" + strconv.Itoa(i) + "]"
+		c.CRL[i].validate(v, prefix)
+		if name := c.CRL[i].Name; name != "" {
+			if other, ok := names[name]; ok {
+				v.add(prefix+".name", "name %q is already used by %s", name, other)
+			}
+			names[name] = prefix
+		}
+	}
+	c.Log.validate(v)
+	for i := range c.Notify {
+		c.Notify[i].validate(v, "notify["+strconv.Itoa(i)+"]")
+	}
+	v.checkListenAddress("metrics.listen", c.Metrics.Listen)
+	v.checkListenAddress("health.listen", c.Health.Listen)
+	durations := []struct {
+		key string
+		d   duration
+	}{
+		{"health.stale_after", c.Health.StaleAfter},
+		{"health.expiry_threshold", c.Health.ExpiryThreshold},
+	}
+	for _, d := range durations {
+		switch {
+		case d.d.legacyHours:
+			v.add(d.key, "must have a unit, e.g. \"2h\"")
+		case d.d.Duration < 0:
+			v.add(d.key, "must not be negative, got %v", d.d.Duration)
+		}
+	}
+
+	if len(v.errs) == 0 {
+		return nil
+	}
+	sort.SliceStable(v.errs, func(i, j int) bool {
+		return v.errs[i].line < v.errs[j].line
+	})
+	return v.errs
+}
+
+// validate checks the settings of the log table.
+func (c *logConfig) validate(v *configValidator) {
+	switch c.Format {
+	case "", logFormatText, logFormatJSON:
+	default:
+		v.add("log.format", "unsupported log format %q, must be %q or %q", c.Format, logFormatText, logFormatJSON)
+	}
+	if _, ok := parseLogLevel(c.Level); c.Level != "" && !ok {
+		v.add("log.level", "unsupported log level %q, must be one of %s", c.Level, strings.Join(logLevelNames[:], ", "))
+	}
+	switch c.Output {
+	case "", logOutputStderr, logOutputSyslog, logOutputJournald:
+	default:
+		v.add("log.output", "unsupported log output %q, must be one of %s, %s, %s", c.Output, logOutputStderr, logOutputSyslog, logOutputJournald)
+	}
+	switch c.Network {
+	case "", "udp", "tcp", "unix":
+	default:
+		v.add("log.network", "unsupported syslog network %q, must be one of udp, tcp, unix", c.Network)
+	}
+	if c.Network != "unix" && c.Output == logOutputSyslog && c.Address != "" {
+		if _, _, err := net.SplitHostPort(c.Address); err != nil {
+			v.add("log.address", "invalid syslog address %q, must be host:port such as \"localhost:514\"", c.Address)
+		}
+	}
+	if _, ok := syslogFacilities[c.Facility]; c.Facility != "" && !ok {
+		v.add("log.facility", "unsupported syslog facility %q, must be one of kern, user, mail, daemon, auth, syslog, lpr, news, uucp, cron, authpriv, ftp, local0 to local7", c.Facility)
+	}
+	if strings.ContainsAny(c.Tag, " \t\n") || len(c.Tag) > 48 {
+		v.add("log.tag", "invalid tag %q, must be at most 48 characters without spaces", c.Tag)
+	}
+	if c.SDID != "" && !isValidSDID(c.SDID) {
+		v.add("log.sd_id", "invalid SD-ID %q, must be a name followed by @ and a Private Enterprise Number, e.g. \"crl2f5@12345\"", c.SDID)
+	}
+	names := make([]string, 0, len(c.Severities))
+	for name := range c.Severities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		key := "log.severities." + name
+		if _, ok := parseLogLevel(name); !ok {
+			v.add(key, "unsupported log level %q, must be one of %s", name, strings.Join(logLevelNames[:], ", "))
+		}
+		if severity := c.Severities[name]; !isSyslogSeverity(severity) {
+			v.add(key, "unsupported syslog severity %q, must be one of %s", severity, strings.Join(syslogSeverityNames[:], ", "))
+		}
+	}
+}
+
+func (c *notifyConfig) validate(v *configValidator, prefix string) {
+	if c.URL == "" {
+		v.add(prefix+".url", "missing value")
+	} else {
+		v.checkURL(prefix+".url", c.URL, "https", "http")
+	}
+	switch c.Method {
+	case "", "POST", "PUT":
+	default:
+		v.add(prefix+".method", "unsupported method %q, must be \"POST\" or \"PUT\"", c.Method)
+	}
+	for _, e := range c.Events {
+		if !isNotifyEvent(e) {
+			v.add(prefix+".events", "unsupported event %q, must be one of %s", e, strings.Join(notifyEvents, ", "))
+		}
+	}
+	if c.Template != "" {
+		if err := validNotifyTemplate(c.Template); err != nil {
+			v.add(prefix+".template", "invalid template: %v", err)
+		}
+	}
+	durations := []struct {
+		key string
+		d   duration
+	}{
+		{"timeout", c.Timeout},
+		{"repeat_interval", c.RepeatInterval},
+		{"expiry_threshold", c.ExpiryThreshold},
+	}
+	for _, d := range durations {
+		switch {
+		case d.d.legacyHours:
+			v.add(prefix+"."+d.key, "must have a unit, e.g. \"1h\"")
+		case d.d.Duration < 0:
+			v.add(prefix+"."+d.key, "must not be negative, got %v", d.d.Duration)
+		}
+	}
+}
+
+// checkUndecoded reports the keys of the configuration file that do not match
+// any setting, which are most likely typos.
+func (c *config) checkUndecoded(v *configValidator) {
+	undecoded := make(map[string]bool)
+	for _, key := range c.undecoded {
+		undecoded[key] = true
+	}
+	if len(undecoded) == 0 {
+		return
+	}
+	for _, key := range v.idx.keys {
+		generic := arrayIndexRegexp.ReplaceAllString(key, "")
+		if !undecoded[generic] {
+			continue
+		}
+		// Only report the outermost undecoded key.
+		if i := strings.LastIndex(generic, "."); i >= 0 && undecoded[generic[:i]] {
+			continue
+		}
+		var table, name string
+		if i := strings.LastIndex(generic, "."); i >= 0 {
+			table, name = generic[:i], generic[i+1:]
+		} else {
+			name = generic
+		}
+		if suggestion := suggestKey(table, name); suggestion != "" {
+			v.add(key, "unknown key, did you mean %q?", suggestion)
+		} else {
+			v.add(key, "unknown key")
+		}
+	}
+}
+
+func (c *f5Config) validate(v *configValidator, prefix string) {
+	switch c.AuthMethod {
+	case "basic", "token":
+	case "":
+		v.add(prefix+".auth_method", "missing value, must be \"basic\" or \"token\"")
+	default:
+		v.add(prefix+".auth_method", "unsupported auth method %q, must be \"basic\" or \"token\"", c.AuthMethod)
+	}
+	if c.URL == "" {
+		v.add(prefix+".url", "missing value")
+	} else {
+		v.checkURL(prefix+".url", c.URL, "https", "http")
+	}
+	if c.User == "" {
+		v.add(prefix+".user", "missing value")
+	}
+	switch d := c.SyncTimeout; {
+	case d.legacyHours:
+		v.add(prefix+".sync_timeout", "must have a unit, e.g. \"2m\"")
+	case d.Duration < 0:
+		v.add(prefix+".sync_timeout", "must not be negative, got %v", d.Duration)
+	case d.Duration > 0 && c.SyncDeviceGroup == "":
+		v.add(prefix+".sync_timeout", "set but no sync_device_group is provided")
+	}
+	switch {
+	case !isValidHAMode(c.HAMode):
+		v.add(prefix+".ha_mode", "unsupported ha mode %q, must be %q", c.HAMode, haModeActiveOnly)
+	case c.HAMode == haModeActiveOnly && c.SyncDeviceGroup == "":
+		v.add(prefix+".ha_mode", "%q requires sync_device_group to be set", c.HAMode)
+	}
+}
+
+func (c *crlConfig) validate(v *configValidator, prefix string) {
+	switch {
+	case c.Name == "":
+		v.add(prefix+".name", "missing value")
+	case !crlNameRegexp.MatchString(c.Name):
+		v.add(prefix+".name", "invalid name %q, only letters, digits, '_', '-' and '.' are allowed", c.Name)
+	}
+	if c.Partition != "" && !partitionRegexp.MatchString(c.Partition) {
+		v.add(prefix+".partition", "invalid partition %q, must be a partition name such as \"Common\", without slashes", c.Partition)
+	}
+	if len(c.profileNames()) == 0 && c.ProfileGlob == "" && c.ProfileRegex == "" && !c.DiscoverProfiles {
+		v.add(prefix+".profile_name", "missing value, either profile_name, profiles, profile_glob, profile_regex or discover_profiles must be provided")
+	}
+	if c.ProfileName != "" {
+		if _, err := parseProfileRef(c.ProfileName); err != nil {
+			v.add(prefix+".profile_name", "%v", err)
+		}
+	}
+	for _, name := range c.Profiles {
+		ref, err := parseProfileRef(name)
+		switch {
+		case err != nil:
+			v.add(prefix+".profiles", "%v", err)
+		case ref.name == "":
+			v.add(prefix+".profiles", "empty profile name")
+		}
+	}
+	if c.ProfileGlob != "" {
+		if ref, err := parseProfileRef(c.ProfileGlob); err != nil {
+			v.add(prefix+".profile_glob", "%v", err)
+		} else if _, err := path.Match(ref.name, ""); err != nil {
+			v.add(prefix+".profile_glob", "invalid glob %q: %v", ref.name, err)
+		}
+	}
+	if c.ProfileRegex != "" {
+		if ref, err := parseProfileRef(c.ProfileRegex); err != nil {
+			v.add(prefix+".profile_regex", "%v", err)
+		} else if _, err := regexp.Compile(ref.name); err != nil {
+			v.add(prefix+".profile_regex", "invalid regex %q: %v", ref.name, err)
+		}
+	}
+
+	if len(c.urls()) == 0 {
+		v.add(prefix+".url", "missing value, either url or urls must be provided")
+	}
+	if c.URL != "" {
+		v.checkURL(prefix+".url", c.URL, "http", "https", "file")
+	}
+	for _, u := range c.URLs {
+		v.checkURL(prefix+".urls", u, "http", "https", "file")
+	}
+
+	if !isValidSchedule(c.Schedule) {
+		v.add(prefix+".schedule", "unsupported schedule %q, must be %q or %q",
+			c.Schedule, scheduleFixed, scheduleNextUpdate)
+	}
+
+	if c.Validate {
+		if c.IssuerCA == "" {
+			v.add(prefix+".validate", "enabled but no issuer_ca is provided")
+		} else if _, err := loadCertificates(c.IssuerCA); err != nil {
+			v.add(prefix+".issuer_ca", "%v", err)
+		}
+	}
+
+	if c.KeepLast < 0 {
+		v.add(prefix+".keep_last", "must not be negative, got %d", c.KeepLast)
+	}
+	if c.RetryMaxAttempts < 0 {
+		v.add(prefix+".retry_max_attempts", "must not be negative, got %d", c.RetryMaxAttempts)
+	}
+
+	switch d := c.RefreshDelay.Duration; {
+	case d <= 0:
+		v.add(prefix+".refresh_delay", "must be positive, got %v", d)
+	case d < minRefreshDelay:
+		v.add(prefix+".refresh_delay", "%v is too short (minimum is %v)", d, minRefreshDelay)
+	case d > maxRefreshDelay:
+		v.add(prefix+".refresh_delay", "%v is too long (maximum is %v)", d, maxRefreshDelay)
+	}
+	durations := []struct {
+		key string
+		d   duration
+	}{
+		{"max_age", c.MaxAge},
+		{"schedule_offset", c.ScheduleOffset},
+		{"jitter", c.Jitter},
+		{"retry_initial_backoff", c.RetryInitialBackoff},
+		{"retry_max_backoff", c.RetryMaxBackoff},
+		{"retry_jitter", c.RetryJitter},
+	}
+	for _, d := range durations {
+		if d.d.legacyHours {
+			v.add(prefix+"."+d.key, "must have a unit, e.g. \"6h\"")
+		} else if d.d.Duration < 0 {
+			v.add(prefix+"."+d.key, "must not be negative, got %v", d.d.Duration)
+		}
+	}
+	if j := c.Jitter.Duration; j > 0 && c.RefreshDelay.Duration > 0 && j >= c.RefreshDelay.Duration {
+		v.add(prefix+".jitter", "%v must be shorter than the refresh delay (%v)", j, c.RefreshDelay.Duration)
+	}
+}
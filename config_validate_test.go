@@ -0,0 +1,439 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyIndex(t *testing.T) {
+	const data = `# comment = 1
+[[f5]]
+url = "https://bigip#1" # trailing comment
+
+[[crl]]
+name = "a"
+urls = [
+    "https://pki/a.crl?x=1",
+    "file:///a.crl",
+]
+"profile_name" = "clientssl"
+description = """
+key = value
+"""
+
+[[crl]]
+name = "b"
+
+[log]
+level = "debug"
+`
+	idx := newKeyIndex(data)
+	wantKeys := []string{
+		"f5[0]",
+		"f5[0].url",
+		"crl[0]",
+		"crl[0].name",
+		"crl[0].urls",
+		"crl[0].profile_name",
+		"crl[0].description",
+		"crl[1]",
+		"crl[1].name",
+		"log",
+		"log.level",
+	}
+	if !reflect.DeepEqual(idx.keys, wantKeys) {
+		t.Errorf("newKeyIndex: got keys %q; want %q", idx.keys, wantKeys)
+	}
+
+	lines := map[string]int{
+		"f5[0].url":           3,
+		"crl[0].urls":         7,
+		"crl[0].profile_name": 11,
+		"crl[1].name":         17,
+		"crl[1].profile_name": 16, // undefined, line of the table
+		"log.level":           20,
+		"undefined":           0,
+	}
+	for key, want := range lines {
+		if got := idx.line(key); got != want {
+			t.Errorf("keyIndex.line(%q): got %d; want %d", key, got, want)
+		}
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"", "abc", 3},
+		{"name", "name", 0},
+		{"login_provided_name", "login_provider_name", 1},
+		{"profil_name", "profile_name", 1},
+		{"kitten", "sitting", 3},
+	}
+	for i, test := range tests {
+		if got := levenshtein(test.a, test.b); got != test.want {
+			t.Errorf("%d. levenshtein(%q, %q): got %d; want %d", i, test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestReadConfigValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{
+			name: "Valid",
+			data: `[[f5]]
+auth_method = "token"
+url = "https://bigip"
+user = "admin"
+login_provider_name = "tmos"
+
+[[crl]]
+name = "test"
+urls = ["https://pki/test.crl", "file:///mnt/test.crl"]
+profile_name = "clientssl"
+`,
+		},
+		{
+			name:    "Empty",
+			data:    ``,
+			wantErr: "invalid configuration:\n  f5: no bigip provided, at least one [[f5]] table is required\n  crl: no crl distribution point provided, at least one [[crl]] table is required",
+		},
+		{
+			name: "All Problems",
+			data: `[[f5]]
+auth_method = "ntlm"
+url = "bigip"
+user = "admin"
+login_provder_name = "tmos"
+
+[[crl]]
+name = ""
+url = "ftp://pki/test.crl"
+refresh_delay = "1h"
+validate = true
+color = "blue"
+
+[[crl]]
+name = "bad name"
+url = "https://pki/test.crl"
+profile_name = "clientssl"
+schedule = "hourly"
+keep_last = -1
+`,
+			wantErr: "invalid configuration:" +
+				"\n  line 2: f5[0].auth_method: unsupported auth method \"ntlm\", must be \"basic\" or \"token\"" +
+				"\n  line 3: f5[0].url: malformed url \"bigip\": scheme must be one of https, http" +
+				"\n  line 5: f5[0].login_provder_name: unknown key, did you mean \"login_provider_name\"?" +
+				"\n  line 7: crl[0].profile_name: missing value, either profile_name, profiles, profile_glob, profile_regex or discover_profiles must be provided" +
+				"\n  line 8: crl[0].name: missing value" +
+				"\n  line 9: crl[0].url: malformed url \"ftp://pki/test.crl\": scheme must be one of http, https, file" +
+				"\n  line 11: crl[0].validate: enabled but no issuer_ca is provided" +
+				"\n  line 12: crl[0].color: unknown key" +
+				"\n  line 15: crl[1].name: invalid name \"bad name\", only letters, digits, '_', '-' and '.' are allowed" +
+				"\n  line 18: crl[1].schedule: unsupported schedule \"hourly\", must be \"fixed\" or \"next_update\"" +
+				"\n  line 19: crl[1].keep_last: must not be negative, got -1",
+		},
+		{
+			name: "Duplicate Name",
+			data: `[[f5]]
+auth_method = "basic"
+url = "https://bigip"
+user = "admin"
+
+[[crl]]
+name = "test"
+url = "https://pki/a.crl"
+profile_name = "clientssl-a"
+
+[[crl]]
+name = "test"
+url = "https://pki/b.crl"
+profile_name = "clientssl-b"
+`,
+			wantErr: "invalid configuration:\n  line 12: crl[1].name: name \"test\" is already used by crl[0]",
+		},
+		{
+			name: "Profile References",
+			data: `[[f5]]
+auth_method = "basic"
+url = "https://bigip"
+user = "admin"
+
+[[crl]]
+name = "test"
+url = "https://pki/a.crl"
+profile_name = "server-ssl:serverssl"
+profiles = ["client-ssl:/TenantA/clientssl", "sever-ssl:serverssl-b"]
+profile_glob = "server-ssl:["
+partition = "/TenantA"
+`,
+			wantErr: "invalid configuration:" +
+				"\n  line 10: crl[0].profiles: unsupported profile type \"sever-ssl\", must be \"client-ssl\" or \"server-ssl\"" +
+				"\n  line 11: crl[0].profile_glob: invalid glob \"[\": syntax error in pattern" +
+				"\n  line 12: crl[0].partition: invalid partition \"/TenantA\", must be a partition name such as \"Common\", without slashes",
+		},
+		{
+			name: "HA Mode",
+			data: `[[f5]]
+auth_method = "basic"
+url = "https://bigip1"
+user = "admin"
+ha_mode = "active-only"
+
+[[f5]]
+auth_method = "basic"
+url = "https://bigip2"
+user = "admin"
+ha_mode = "active-standby"
+sync_timeout = "30s"
+
+[[crl]]
+name = "test"
+url = "https://pki/a.crl"
+profile_name = "clientssl"
+`,
+			wantErr: "invalid configuration:" +
+				"\n  line 5: f5[0].ha_mode: \"active-only\" requires sync_device_group to be set" +
+				"\n  line 11: f5[1].ha_mode: unsupported ha mode \"active-standby\", must be \"active-only\"" +
+				"\n  line 12: f5[1].sync_timeout: set but no sync_device_group is provided",
+		},
+		{
+			name: "Log",
+			data: `[[f5]]
+auth_method = "basic"
+url = "https://bigip"
+user = "admin"
+
+[[crl]]
+name = "test"
+url = "https://pki/a.crl"
+profile_name = "clientssl"
+
+[log]
+format = "logfmt"
+level = "warning"
+output = "file"
+network = "udp6"
+address = "localhost"
+facility = "local9"
+tag = "crl2f5 connector"
+sd_id = "crl2f5"
+
+[log.severities]
+warn = "warn"
+verbose = "debug"
+`,
+			wantErr: "invalid configuration:" +
+				"\n  line 12: log.format: unsupported log format \"logfmt\", must be \"text\" or \"json\"" +
+				"\n  line 13: log.level: unsupported log level \"warning\", must be one of debug, info, notice, warn, error" +
+				"\n  line 14: log.output: unsupported log output \"file\", must be one of stderr, syslog, journald" +
+				"\n  line 15: log.network: unsupported syslog network \"udp6\", must be one of udp, tcp, unix" +
+				"\n  line 17: log.facility: unsupported syslog facility \"local9\", must be one of kern, user, mail, daemon, auth, syslog, lpr, news, uucp, cron, authpriv, ftp, local0 to local7" +
+				"\n  line 18: log.tag: invalid tag \"crl2f5 connector\", must be at most 48 characters without spaces" +
+				"\n  line 19: log.sd_id: invalid SD-ID \"crl2f5\", must be a name followed by @ and a Private Enterprise Number, e.g. \"crl2f5@12345\"" +
+				"\n  line 22: log.severities.warn: unsupported syslog severity \"warn\", must be one of emerg, alert, crit, err, warning, notice, info, debug" +
+				"\n  line 23: log.severities.verbose: unsupported log level \"verbose\", must be one of debug, info, notice, warn, error",
+		},
+		{
+			name: "Log Syslog Address",
+			data: `[[f5]]
+auth_method = "basic"
+url = "https://bigip"
+user = "admin"
+
+[[crl]]
+name = "test"
+url = "https://pki/a.crl"
+profile_name = "clientssl"
+
+[log]
+output = "syslog"
+network = "tcp"
+address = "localhost"
+`,
+			wantErr: "invalid configuration:" +
+				"\n  line 14: log.address: invalid syslog address \"localhost\", must be host:port such as \"localhost:514\"",
+		},
+		{
+			name: "HTTP Endpoints",
+			data: `[[f5]]
+auth_method = "basic"
+url = "https://bigip"
+user = "admin"
+
+[[crl]]
+name = "test"
+url = "https://pki/a.crl"
+profile_name = "clientssl"
+
+[metrics]
+listen = "9100"
+path = "/metrics"
+
+[health]
+listen = ":9100"
+stale_after = 2
+expiry_threshold = "-1h"
+`,
+			wantErr: "invalid configuration:" +
+				"\n  line 12: metrics.listen: invalid listen address \"9100\", must be host:port such as \":9100\"" +
+				"\n  line 13: metrics.path: unknown key" +
+				"\n  line 17: health.stale_after: must have a unit, e.g. \"2h\"" +
+				"\n  line 18: health.expiry_threshold: must not be negative, got -1h0m0s",
+		},
+		{
+			name: "Notify",
+			data: `[[f5]]
+auth_method = "basic"
+url = "https://bigip"
+user = "admin"
+
+[[crl]]
+name = "test"
+url = "https://pki/a.crl"
+profile_name = "clientssl"
+
+[[notify]]
+url = "hooks.example.com"
+method = "GET"
+events = ["push_failure", "push_success"]
+template = '{"text": "{{.Message}}"}'
+repeat_interval = 1
+timout = "5s"
+
+[[notify]]
+name = "ops"
+template = '{"text": {{.Message}'
+timeout = "-5s"
+`,
+			wantErr: "invalid configuration:" +
+				"\n  line 12: notify[0].url: malformed url \"hooks.example.com\": scheme must be one of https, http" +
+				"\n  line 13: notify[0].method: unsupported method \"GET\", must be \"POST\" or \"PUT\"" +
+				"\n  line 14: notify[0].events: unsupported event \"push_success\", must be one of fetch_failure, push_failure, expiring, recovery" +
+				"\n  line 15: notify[0].template: invalid template: template does not produce valid JSON: invalid character 't' after object key:value pair" +
+				"\n  line 16: notify[0].repeat_interval: must have a unit, e.g. \"1h\"" +
+				"\n  line 17: notify[0].timout: unknown key, did you mean \"timeout\"?" +
+				"\n  line 19: notify[1].url: missing value" +
+				"\n  line 21: notify[1].template: invalid template: template: notify:1: bad character U+007D '}'" +
+				"\n  line 22: notify[1].timeout: must not be negative, got -5s",
+		},
+		{
+			name: "Missing Issuer CA File",
+			data: `[[f5]]
+auth_method = "basic"
+url = "https://bigip"
+user = "admin"
+
+[[crl]]
+name = "test"
+url = "https://pki/a.crl"
+profile_name = "clientssl"
+validate = true
+issuer_ca = "some-path-that-does-not-exist"
+`,
+			wantErr: "invalid configuration:\n  line 11: crl[0].issuer_ca: cannot read certificate file: open some-path-that-does-not-exist: no such file or directory",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := createTempConfigFile(test.data)
+			if err != nil {
+				t.Fatal("setup: ", err)
+			}
+			defer os.Remove(f.Name())
+			defer f.Close()
+
+			_, err = readConfig(f.Name())
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("readConfig: unexpected error %q", err.Error())
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("readConfig: expected error %q, got nil", test.wantErr)
+			}
+			if err.Error() != test.wantErr {
+				t.Errorf("readConfig: got error\n%s\nwant\n%s", err.Error(), test.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadConfigLegacyLoginProviderName(t *testing.T) {
+	f, err := createTempConfigFile(`[[f5]]
+auth_method = "token"
+url = "https://bigip"
+user = "admin"
+login_provided_name = "tmos"
+
+[[crl]]
+name = "test"
+url = "https://pki/test.crl"
+profile_name = "clientssl"
+`)
+	if err != nil {
+		t.Fatal("setup: ", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	cfg, err := readConfig(f.Name())
+	if err != nil {
+		t.Fatalf("readConfig: unexpected error %q", err.Error())
+	}
+	if got, want := cfg.F5[0].LoginProviderName, "tmos"; got != want {
+		t.Errorf("readConfig: got login_provider_name %q; want %q", got, want)
+	}
+	wantDeprecations := []string{
+		"line 5: f5[0].login_provided_name: deprecated misspelled key, use login_provider_name instead",
+	}
+	if !reflect.DeepEqual(cfg.deprecations, wantDeprecations) {
+		t.Errorf("readConfig: got deprecations %q; want %q", cfg.deprecations, wantDeprecations)
+	}
+}
+
+func TestConfigValidateDurationsOrder(t *testing.T) {
+	negative := duration{Duration: -time.Second}
+	cfg := &config{
+		Health: healthConfig{StaleAfter: negative, ExpiryThreshold: negative},
+		Notify: []notifyConfig{{
+			URL:             "https://hooks.example.com",
+			Timeout:         negative,
+			RepeatInterval:  negative,
+			ExpiryThreshold: negative,
+		}},
+	}
+	err := cfg.validate()
+	errs, ok := err.(configErrors)
+	if !ok {
+		t.Fatalf("config.validate: got error %v; want configErrors", err)
+	}
+	var got []string
+	for _, err := range errs {
+		if strings.HasPrefix(err.key, "health.") || strings.HasPrefix(err.key, "notify[0].") {
+			got = append(got, err.key)
+		}
+	}
+	want := []string{
+		"notify[0].timeout",
+		"notify[0].repeat_interval",
+		"notify[0].expiry_threshold",
+		"health.stale_after",
+		"health.expiry_threshold",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("config.validate: got errors for %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/e-XpertSolutions/f5-rest-client/f5"
+)
+
+// sslFile is the subset of the sys file ssl-cert and ssl-crl objects needed to
+// read their content.
+type sslFile struct {
+	Name     string `json:"name"`
+	FullPath string `json:"fullPath"`
+
+	// CachePath is the path of the file in the filestore of the BigIP, e.g.
+	// "/config/filestore/files_d/Common_d/certificate_d/:Common:ca.crt_1".
+	CachePath string `json:"cachePath"`
+}
+
+// utilResult is the response of the util APIs running a command on the BigIP,
+// such as unix-cp.
+type utilResult struct {
+	CommandResult string `json:"commandResult"`
+}
+
+// runUtil runs the util command (e.g. "unix-cp") with the given arguments on
+// the BigIP. The command is run without a shell; its output, if any, is
+// returned as an error.
+func runUtil(f5Client *f5.Client, command, args string) error {
+	cmd := map[string]string{
+		"command":     "run",
+		"utilCmdArgs": args,
+	}
+	resp, err := f5Client.SendRequest("POST", "/mgmt/tm/util/"+command, cmd)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var result utilResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return errors.New("cannot decode response: " + err.Error())
+	}
+	if out := strings.TrimSpace(result.CommandResult); out != "" {
+		return errors.New(out)
+	}
+	return nil
+}
+
+// downloadsDir is the directory served by the file transfer API.
+const downloadsDir = "/var/config/rest/downloads/"
+
+// downloadChunkSize is the size of the chunks requested to the file transfer
+// API.
+const downloadChunkSize = 512 * 1024
+
+// downloadFile downloads the file of /var/config/rest/downloads with the given
+// name through the file transfer API, chunk by chunk.
+func downloadFile(f5Client *f5.Client, name string) ([]byte, error) {
+	var (
+		data []byte
+		size int64 = -1
+	)
+	for size < 0 || int64(len(data)) < size {
+		start := int64(len(data))
+		end := start + downloadChunkSize - 1
+		if size > 0 && end >= size {
+			end = size - 1
+		}
+		req, err := f5Client.MakeRequest("GET", "/mgmt/shared/file-transfer/downloads/"+name, nil)
+		if err != nil {
+			return nil, err
+		}
+		total := size
+		if total < 0 {
+			total = 0
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("Content-Range", strconv.FormatInt(start, 10)+"-"+strconv.FormatInt(end, 10)+"/"+strconv.FormatInt(total, 10))
+		resp, err := f5Client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		chunk, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 400 {
+			return nil, errors.New("http response error: " + resp.Status)
+		}
+		if size < 0 {
+			crange := resp.Header.Get("Content-Range")
+			n, err := strconv.ParseInt(crange[strings.LastIndex(crange, "/")+1:], 10, 64)
+			if err != nil || n < 0 {
+				return nil, errors.New("invalid content range \"" + crange + "\"")
+			}
+			size = n
+		}
+		if len(chunk) == 0 && int64(len(data)) < size {
+			return nil, errors.New("unexpected end of file")
+		}
+		data = append(data, chunk...)
+	}
+	return data, nil
+}
+
+// isSafeFilePath reports whether p is an absolute path made only of letters,
+// digits and "_./:-", so that it can be given as an argument to a util command.
+func isSafeFilePath(p string) bool {
+	if !strings.HasPrefix(p, "/") || strings.Contains(p, "..") {
+		return false
+	}
+	for _, r := range p {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+		case strings.ContainsRune("_./:-", r):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// readSSLFile reads the content of the ssl-cert or ssl-crl file, depending on
+// kind, stored on the BigIP under the given name. The file is looked up through
+// the sys file API, which gives its path in the filestore, copied to
+// /var/config/rest/downloads with util unix-cp and downloaded through the file
+// transfer API, which only serves this directory. The copy is removed
+// afterwards.
+func readSSLFile(f5Client *f5.Client, kind, name string) ([]byte, error) {
+	var file sslFile
+	if err := f5Client.ReadQuery("/mgmt/tm/sys/file/"+kind+"/"+restName(name), &file); err != nil {
+		return nil, errors.New("cannot get " + kind + " file \"" + name + "\": " + err.Error())
+	}
+	if !isSafeFilePath(file.CachePath) {
+		return nil, errors.New("cannot read " + kind + " file \"" + name + "\": unexpected cache path \"" + file.CachePath + "\"")
+	}
+	tmpName := "crl2f5-" + strings.Replace(path.Base(file.CachePath), ":", "_", -1)
+	if err := runUtil(f5Client, "unix-cp", file.CachePath+" "+downloadsDir+tmpName); err != nil {
+		return nil, errors.New("cannot read " + kind + " file \"" + name + "\": cannot copy it to " + downloadsDir + ": " + err.Error())
+	}
+	defer runUtil(f5Client, "unix-rm", downloadsDir+tmpName)
+	data, err := downloadFile(f5Client, tmpName)
+	if err != nil {
+		return nil, errors.New("cannot read " + kind + " file \"" + name + "\": " + err.Error())
+	}
+	return data, nil
+}
+
+// readCertificates reads the certificate file (or bundle) stored on the BigIP
+// under the given name and returns the certificates it contains.
+func readCertificates(f5Client *f5.Client, name string) ([]*x509.Certificate, error) {
+	data, err := readSSLFile(f5Client, "ssl-cert", name)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := parseCertificates(data)
+	if err != nil {
+		return nil, errors.New("invalid ssl-cert file \"" + name + "\": " + err.Error())
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("invalid ssl-cert file \"" + name + "\": no PEM encoded certificate found")
+	}
+	return certs, nil
+}
+
+// isIssuedByCRLIssuer reports whether the certificate is the CA that issued the
+// CRL, or has been issued by it. Distinguished names are compared, as well as
+// key identifiers when both the CRL and the certificate define them.
+func isIssuedByCRLIssuer(cert *x509.Certificate, crl *pkix.CertificateList, akid []byte) bool {
+	sameKeyID := func(keyID []byte) bool {
+		return len(akid) == 0 || len(keyID) == 0 || bytes.Equal(akid, keyID)
+	}
+	issuer := crl.TBSCertList.Issuer
+	if sameName(cert.RawSubject, issuer) && sameKeyID(cert.SubjectKeyId) {
+		return true
+	}
+	return sameName(cert.RawIssuer, issuer) && sameKeyID(cert.AuthorityKeyId)
+}
+
+// caDiscoverer selects the client-ssl profiles trusting the CA that issued a
+// CRL, i.e. the ones whose caFile or clientCertCa certificates have been issued
+// by this CA. The certificate files are read only once per discovery.
+type caDiscoverer struct {
+	f5Client *f5.Client
+	crl      *pkix.CertificateList
+	akid     []byte
+	matches  map[string]bool  // by certificate file name
+	errs     map[string]error // by certificate file name
+}
+
+// newCADiscoverer returns a discoverer of the profiles trusting the issuer of
+// the CRL. The CRL must define an authority key identifier, which the
+// certificates are matched against, so that a CA is not mistaken for another
+// one with the same name.
+func newCADiscoverer(f5Client *f5.Client, crl *pkix.CertificateList) (*caDiscoverer, error) {
+	akid, err := authorityKeyID(crl)
+	if err != nil {
+		return nil, err
+	}
+	if len(akid) == 0 {
+		return nil, errors.New("crl has no authority key identifier")
+	}
+	return &caDiscoverer{
+		f5Client: f5Client,
+		crl:      crl,
+		akid:     akid,
+		matches:  make(map[string]bool),
+		errs:     make(map[string]error),
+	}, nil
+}
+
+// trustsCRLIssuer reports whether the profile trusts the CA that issued the
+// CRL. An error is returned if one of its certificate files cannot be read,
+// unless another one trusts the CA.
+func (d *caDiscoverer) trustsCRLIssuer(profile sslProfile) (bool, error) {
+	var firstErr error
+	for _, name := range profile.caFiles {
+		if isNone(name) {
+			continue
+		}
+		match, ok := d.matches[name]
+		if !ok {
+			if err, failed := d.errs[name]; failed {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			certs, err := readCertificates(d.f5Client, name)
+			if err != nil {
+				d.errs[name] = err
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			for _, cert := range certs {
+				if isIssuedByCRLIssuer(cert, d.crl, d.akid) {
+					match = true
+					break
+				}
+			}
+			d.matches[name] = match
+		}
+		if match {
+			return true, nil
+		}
+	}
+	return false, firstErr
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/e-XpertSolutions/f5-rest-client/f5"
+)
+
+// newIssuedCert returns a certificate whose issuer and authority key identifier
+// are the subject and subject key identifier of parent. It is signed with a
+// random key, hence its signature is invalid.
+func newIssuedCert(cn string, parent *x509.Certificate) *x509.Certificate {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		panic("cannot generate test key: " + err.Error())
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	signer := *parent
+	signer.PublicKey = &key.PublicKey
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, &signer, &key.PublicKey, key)
+	if err != nil {
+		panic("cannot create test certificate: " + err.Error())
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic("cannot parse test certificate: " + err.Error())
+	}
+	return cert
+}
+
+func encodeCertificate(cert *x509.Certificate) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+}
+
+func TestIsIssuedByCRLIssuer(t *testing.T) {
+	crl, err := parseCRL(pemCRL)
+	if err != nil {
+		t.Fatal("setup: ", err)
+	}
+	akid, err := authorityKeyID(crl)
+	if err != nil {
+		t.Fatal("setup: ", err)
+	}
+	issuer := unsafeParseCertificate(issuerCert)
+
+	// Same subject and issuer as the issuer, different key identifiers.
+	impostor := *issuer
+	impostor.SubjectKeyId = []byte{0x01, 0x02, 0x03}
+	impostor.AuthorityKeyId = []byte{0x01, 0x02, 0x03}
+
+	tests := []struct {
+		name string
+		cert *x509.Certificate
+		want bool
+	}{
+		{"Issuer", issuer, true},
+		{"Issued By Issuer", newIssuedCert("client", issuer), true},
+		{"Other CA", newSelfSignedCert("other"), false},
+		{"Issued By Other CA", newIssuedCert("client", newSelfSignedCert("other")), false},
+		{"Key Identifier Mismatch", &impostor, false},
+	}
+	for _, test := range tests {
+		if got := isIssuedByCRLIssuer(test.cert, crl, akid); got != test.want {
+			t.Errorf("%s: isIssuedByCRLIssuer: got %v; want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestProfileSelector_Discover(t *testing.T) {
+	crl, err := parseCRL(pemCRL)
+	if err != nil {
+		t.Fatal("setup: ", err)
+	}
+
+	srv := newBigIPServer()
+	srv.otherProfiles = map[string]string{
+		"app-a": "",
+		"app-b": "",
+		"app-c": "",
+		"app-d": "",
+	}
+	srv.profileCAFiles = map[string]string{
+		"app-a": "/Common/ca.crt",
+		"app-b": "/Common/other.crt",
+		"app-d": "/Common/bundle.crt",
+	}
+	srv.certFiles = map[string]string{
+		"/Common/ca.crt":     string(issuerCert),
+		"/Common/other.crt":  encodeCertificate(newSelfSignedCert("other")),
+		"/Common/bundle.crt": encodeCertificate(newSelfSignedCert("other")) + string(issuerCert),
+	}
+	tsBigIP := httptest.NewServer(srv)
+	defer tsBigIP.Close()
+
+	f5Client, err := f5.NewBasicClient(tsBigIP.URL, "admin", "admin")
+	if err != nil {
+		t.Fatal("cannot instanciate f5 basic client: ", err)
+	}
+
+	got, err := profileSelector{discover: true}.resolve(f5Client, crl, discardLogger{})
+	if err != nil {
+		t.Fatalf("profileSelector.resolve: unexpected error %q", err.Error())
+	}
+	if want := []profileRef{{clientSSL, "/Common/app-a"}, {clientSSL, "/Common/app-d"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("profileSelector.resolve: got %q; want %q", got, want)
+	}
+
+	// The profiles whose certificates cannot be read are skipped, the ones
+	// selected by name are still resolved.
+	srv.Disable = "unix-cp"
+	l := &bufferedLogger{}
+	selector := profileSelector{refs: []profileRef{{clientSSL, "/Common/clientssl"}}, discover: true}
+	got, err = selector.resolve(f5Client, crl, l)
+	if err != nil {
+		t.Fatalf("profileSelector.resolve: unexpected error %q", err.Error())
+	}
+	if want := []profileRef{{clientSSL, "/Common/clientssl"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("profileSelector.resolve: got %q; want %q", got, want)
+	}
+	wantErr := "cannot discover client-ssl profiles: skipping /Common/app-"
+	if !strings.HasPrefix(l.errBuf, wantErr) || !strings.Contains(l.errBuf, ": cannot read ssl-cert file ") {
+		t.Errorf("profileSelector.resolve: got logged errors %q; want %q", l.errBuf, wantErr)
+	}
+}
+
+func TestProfileSelector_DiscoverNoAuthorityKeyID(t *testing.T) {
+	crl, err := parseCRL(newTestPEMCRL(1, time.Now()))
+	if err != nil {
+		t.Fatal("setup: ", err)
+	}
+
+	srv := newBigIPServer()
+	tsBigIP := httptest.NewServer(srv)
+	defer tsBigIP.Close()
+
+	f5Client, err := f5.NewBasicClient(tsBigIP.URL, "admin", "admin")
+	if err != nil {
+		t.Fatal("cannot instanciate f5 basic client: ", err)
+	}
+
+	l := &bufferedLogger{}
+	selector := profileSelector{refs: []profileRef{{clientSSL, "/Common/clientssl"}}, discover: true}
+	got, err := selector.resolve(f5Client, crl, l)
+	if err != nil {
+		t.Fatalf("profileSelector.resolve: unexpected error %q", err.Error())
+	}
+	if want := []profileRef{{clientSSL, "/Common/clientssl"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("profileSelector.resolve: got %q; want %q", got, want)
+	}
+	if want := "cannot discover client-ssl profiles: crl has no authority key identifier"; !strings.Contains(l.errBuf, want) {
+		t.Errorf("profileSelector.resolve: got logged errors %q; want %q", l.errBuf, want)
+	}
+}
+
+func TestIsSafeFilePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/config/filestore/files_d/Common_d/certificate_d/:Common:ca.crt_1", true},
+		{"/config/filestore/files_d/Common_d/certificate_d/:Common:ca-2.crt_1", true},
+		{"", false},
+		{"ca.crt", false},
+		{"/config/filestore/../../etc/shadow", false},
+		{"/config/filestore/$(reboot)", false},
+		{"/config/filestore/`reboot`", false},
+		{"/config/filestore/ca.crt /etc/passwd", false},
+		{"/config/filestore/ca.crt;reboot", false},
+		{"/config/filestore/'ca.crt'", false},
+	}
+	for _, test := range tests {
+		if got := isSafeFilePath(test.path); got != test.want {
+			t.Errorf("isSafeFilePath(%q): got %v; want %v", test.path, got, test.want)
+		}
+	}
+}
+
+func TestReadCertificates(t *testing.T) {
+	issuerCert := encodeCertificate(newSelfSignedCert("issuer"))
+	srv := newBigIPServer()
+	srv.certFiles = map[string]string{
+		"/Common/ca.crt":    issuerCert,
+		"/Common/empty.crt": "cp: cannot open '/config/filestore/files_d/Common_d/certificate_d/:Common:empty.crt_1'\n",
+	}
+	tsBigIP := httptest.NewServer(srv)
+	defer tsBigIP.Close()
+
+	f5Client, err := f5.NewBasicClient(tsBigIP.URL, "admin", "admin")
+	if err != nil {
+		t.Fatal("cannot instanciate f5 basic client: ", err)
+	}
+
+	certs, err := readCertificates(f5Client, "/Common/ca.crt")
+	if err != nil {
+		t.Fatalf("readCertificates: unexpected error %q", err.Error())
+	}
+	if len(certs) != 1 || encodeCertificate(certs[0]) != issuerCert {
+		t.Errorf("readCertificates: got %d certificates; want the issuer one", len(certs))
+	}
+	if len(srv.downloads) != 0 {
+		t.Errorf("readCertificates: got %d files left in the downloads directory; want none", len(srv.downloads))
+	}
+
+	_, err = readCertificates(f5Client, "/Common/empty.crt")
+	if want := "no PEM encoded certificate found"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("readCertificates: got error %v; want %q", err, want)
+	}
+
+	_, err = readCertificates(f5Client, "/Common/missing.crt")
+	if want := "cannot get ssl-cert file \"/Common/missing.crt\""; err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("readCertificates: got error %v; want %q", err, want)
+	}
+}
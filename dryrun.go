@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// plan fetches and validates the CRL as a regular run would, then writes to out
+// the changes that would be made on every BigIP, without making any: only read
+// requests are sent to the BigIPs. It reports whether the plan could be
+// established for every BigIP.
+func (w *worker) plan(bigIPs []*bigIP, out io.Writer, l logger) bool {
+	fetched, err := w.fetch(l)
+	if err != nil {
+		fmt.Fprintf(out, "crl %q: %v\n", w.crlName, err)
+		return false
+	}
+	tbs := fetched.crl.TBSCertList
+	number := "none"
+	if fetched.state.number != nil {
+		number = fetched.state.number.String()
+	}
+	fmt.Fprintf(out, "crl %q: fetched from %s\n", w.crlName, fetched.url)
+	fmt.Fprintf(out, "  issuer: %s\n", formatName(tbs.Issuer))
+	fmt.Fprintf(out, "  number: %s\n", number)
+	fmt.Fprintf(out, "  revoked certificates: %d\n", len(tbs.RevokedCertificates))
+	fmt.Fprintf(out, "  next update: %s\n", tbs.NextUpdate.UTC().Format(time.RFC3339))
+
+	crlPath := w.crlFilePath(w.crlFileName(time.Now()))
+	ok := true
+	units := w.selectActiveUnits(bigIPs, l)
+	for i, b := range bigIPs {
+		fmt.Fprintf(out, "  bigip %s:\n", b.name)
+		if units[i].err != nil {
+			fmt.Fprintf(out, "    %v\n", units[i].err)
+			ok = false
+			continue
+		}
+		if units[i].skip {
+			fmt.Fprintf(out, "    skip: failover state is %s\n", units[i].failoverState)
+			continue
+		}
+		fmt.Fprintf(out, "    upload crl file %q\n", crlPath)
+		profiles, err := w.profiles.resolve(b.client, fetched.crl, l)
+		if err != nil {
+			fmt.Fprintf(out, "    %v\n", err)
+			ok = false
+			continue
+		}
+		for _, ref := range profiles {
+			profile, err := getProfile(b.client, ref)
+			if err != nil {
+				fmt.Fprintf(out, "    cannot get %s: %v\n", ref, err)
+				ok = false
+				continue
+			}
+			fmt.Fprintf(out, "    update %s: crl file %q -> %q\n", ref, profile.crlFile, crlPath)
+		}
+		if b.saver != nil {
+			fmt.Fprintf(out, "    save sys config\n")
+		}
+		if b.syncDeviceGroup != "" {
+			fmt.Fprintf(out, "    sync device group %q\n", b.syncDeviceGroup)
+		}
+	}
+	return ok
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/e-XpertSolutions/f5-rest-client/f5"
+)
+
+func TestWorker_Plan(t *testing.T) {
+	srv := newBigIPServer()
+	srv.crlFile = "/Common/test_1500000000.crl"
+	var writes int
+	tsBigIP := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			writes++
+		}
+		srv.ServeHTTP(w, r)
+	}))
+	defer tsBigIP.Close()
+
+	failingSrv := newBigIPServer()
+	failingSrv.Disable = "client-ssl_get"
+	tsFailingBigIP := httptest.NewServer(failingSrv)
+	defer tsFailingBigIP.Close()
+
+	tsCA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(crlFile))
+	}))
+	defer tsCA.Close()
+
+	var bigIPs []*bigIP
+	for i, url := range []string{tsBigIP.URL, tsFailingBigIP.URL} {
+		f5Client, err := f5.NewBasicClient(url, "admin", "admin")
+		if err != nil {
+			t.Fatal("cannot instanciate f5 basic client: ", err)
+		}
+		bigIPs = append(bigIPs, &bigIP{name: "bigip" + strconv.Itoa(i+1), client: f5Client})
+	}
+
+	w := worker{
+		urls:     []string{tsCA.URL},
+		crlName:  "test",
+		profiles: profileSelector{refs: []profileRef{{clientSSL, "/Common/clientssl"}}},
+	}
+	var out bytes.Buffer
+	if w.plan(bigIPs, &out, &discardLogger{}) {
+		t.Error("worker.plan: got true; want false")
+	}
+	if writes != 0 {
+		t.Errorf("worker.plan: got %d write requests; want none", writes)
+	}
+
+	wantOutput := []string{
+		"crl \"test\": fetched from " + tsCA.URL + "\n",
+		"  issuer: CN=localhost, OU=IT, O=e-Xpert Solutions SA, L=Plan-les-Ouates, ST=Geneva, C=CH\n",
+		"  number: none\n",
+		"  revoked certificates: 0\n",
+		"  next update: 2023-02-25T12:04:10Z\n",
+		"  bigip bigip1:\n    upload crl file \"/Common/test_",
+		"    update client-ssl profile \"/Common/clientssl\": crl file \"/Common/test_1500000000.crl\" -> \"/Common/test_",
+		"  bigip bigip2:\n    upload crl file \"/Common/test_",
+		"    cannot get client-ssl profile \"/Common/clientssl\": ",
+	}
+	for _, want := range wantOutput {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("worker.plan: got output\n%s\nwant it to contain %q", out.String(), want)
+		}
+	}
+}
@@ -1,15 +1,29 @@
 package main
 
 import (
+	"strconv"
 	"time"
 )
 
 type duration struct {
 	time.Duration
+
+	// legacyHours is set when the duration has been given as a bare number
+	// without unit, e.g. 6 or "6", in which case it is interpreted as a number
+	// of hours. This form is deprecated.
+	legacyHours bool
 }
 
 // UnmarshalText unmarshal and parses text into a duration.
+//
+// For backward compatibility, a bare number is interpreted as a number of
+// hours.
 func (d *duration) UnmarshalText(text []byte) error {
+	if hours, err := strconv.ParseFloat(string(text), 64); err == nil {
+		d.Duration = time.Duration(hours * float64(time.Hour))
+		d.legacyHours = true
+		return nil
+	}
 	var err error
 	d.Duration, err = time.ParseDuration(string(text))
 	return err
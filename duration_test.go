@@ -15,3 +15,28 @@ func TestDuration_UnmarshalText(t *testing.T) {
 		t.Errorf("duration.UnmarhsalText: got %q; want %q", got, want)
 	}
 }
+
+func TestDuration_UnmarshalTextLegacyHours(t *testing.T) {
+	tests := map[string]time.Duration{
+		"6":        6 * time.Hour,
+		"1.500000": 90 * time.Minute,
+	}
+	for text, want := range tests {
+		var d duration
+		if err := d.UnmarshalText([]byte(text)); err != nil {
+			t.Errorf("duration.UnmarhsalText(%q): unexpected error %q", text, err.Error())
+			continue
+		}
+		if d.Duration != want {
+			t.Errorf("duration.UnmarhsalText(%q): got %q; want %q", text, d.Duration, want)
+		}
+		if !d.legacyHours {
+			t.Errorf("duration.UnmarhsalText(%q): expected legacy hours to be set", text)
+		}
+	}
+
+	var d duration
+	if err := d.UnmarshalText([]byte("6x")); err == nil {
+		t.Error("duration.UnmarhsalText: expected error, got nil")
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/x509/pkix"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Reasons why a CRL could not be fetched from a distribution point, as reported
+// by the metrics.
+const (
+	fetchReasonNetwork    = "network"
+	fetchReasonHTTPStatus = "http_status"
+	fetchReasonParse      = "parse"
+	fetchReasonExpired    = "expired"
+	fetchReasonValidation = "validation"
+	fetchReasonOther      = "other"
+)
+
+// fetchError is an error that prevented a CRL from being fetched from a
+// distribution point, along with its reason.
+type fetchError struct {
+	reason string
+	msg    string
+}
+
+func newFetchError(reason, msg string) error {
+	return &fetchError{reason: reason, msg: msg}
+}
+
+func (e *fetchError) Error() string {
+	return e.msg
+}
+
+// fetchFailureReason returns the reason of a fetch failure.
+func fetchFailureReason(err error) string {
+	if e, ok := err.(*fetchError); ok {
+		return e.reason
+	}
+	return fetchReasonOther
+}
+
+// fetchedCRL is a CRL downloaded, parsed and validated by a worker.
+type fetchedCRL struct {
+	url   string
+	pem   []byte
+	crl   *pkix.CertificateList
+	state crlState
+}
+
+// fetchFrom downloads the CRL from the given distribution point, parses it and
+// validates it when the worker is configured to do so. The attempt is recorded
+// in the metrics of the worker.
+func (w *worker) fetchFrom(url string, cache *httpCache) (*fetchedCRL, error) {
+	start := time.Now()
+	fetched, err := w.download(url, cache)
+	w.metrics.observeFetch(w.crlName, time.Since(start), fetched, err)
+	return fetched, err
+}
+
+// download downloads, parses and validates the CRL served by the distribution
+// point.
+func (w *worker) download(url string, cache *httpCache) (*fetchedCRL, error) {
+	pemCRL, err := fetchCRL(url, cache)
+	if err != nil {
+		return nil, err
+	}
+	crl, err := parseCRL(pemCRL)
+	if err != nil {
+		return nil, newFetchError(fetchReasonParse, err.Error())
+	}
+	if w.validate {
+		if err := verifyCRL(crl, w.issuers); err != nil {
+			return nil, newFetchError(fetchReasonValidation, "crl validation failed: "+err.Error())
+		}
+	}
+	state, err := newCRLState(pemCRL, crl)
+	if err != nil {
+		return nil, newFetchError(fetchReasonParse, err.Error())
+	}
+	return &fetchedCRL{url: url, pem: pemCRL, crl: crl, state: state}, nil
+}
+
+// fetch downloads the CRL from every distribution point of the worker, in
+// order, and returns the most recent valid one, i.e. the one with the highest
+// CRL number or, failing that, the newest ThisUpdate.
+//
+// Failing distribution points are skipped. If none of them succeeds, an error
+// is returned. errNotModified is returned when no distribution point provides a
+// CRL that is at least as recent as the current one.
+func (w *worker) fetch(l logger) (*fetchedCRL, error) {
+	if len(w.caches) != len(w.urls) {
+		w.caches = make([]httpCache, len(w.urls))
+	}
+
+	var (
+		best        *fetchedCRL
+		notModified bool
+		errs        []string
+		failedURLs  []string
+		lastErr     error
+	)
+	for i, url := range w.urls {
+		var cache *httpCache
+		if !w.forcePush {
+			cache = &w.caches[i]
+		}
+		fetched, err := w.fetchFrom(url, cache)
+		if err == errNotModified {
+			notModified = true
+			continue
+		}
+		if err != nil {
+			lastErr = err
+			errs = append(errs, url+": "+err.Error())
+			failedURLs = append(failedURLs, url)
+			continue
+		}
+		if best == nil || fetched.state.newerThan(best.state) {
+			best = fetched
+		}
+	}
+
+	// With a single distribution point, errors are reported as is. Otherwise
+	// they are only reported all together when every distribution point
+	// failed.
+	if best == nil && !notModified {
+		if len(w.urls) == 1 {
+			return nil, lastErr
+		}
+		return nil, errors.New("cannot fetch crl from any distribution point: " + strings.Join(errs, "; "))
+	}
+	for i, err := range errs {
+		l.With(fieldCRLURL, failedURLs[i]).Error("crl distribution point ", err)
+	}
+	if best == nil {
+		return nil, errNotModified
+	}
+	if w.current != nil && w.current.newerThan(best.state) {
+		l.With(fieldCRLURL, best.url).Notice("crl \"", w.crlName, "\" served by ", best.url, " is older than the current one, ignoring")
+		return nil, errNotModified
+	}
+
+	l.With(fieldCRLURL, best.url).Notice("crl \"", w.crlName, "\" fetched from ", best.url)
+	w.current = &best.state
+	return best, nil
+}
+
+// resetCaches forgets the http validators of all the distribution points so
+// that the CRL is fully downloaded at the next fetch.
+func (w *worker) resetCaches() {
+	for i := range w.caches {
+		w.caches[i] = httpCache{}
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// newTestPEMCRL generates a PEM encoded CRL with the given CRL number and
+// ThisUpdate. The CRL is not properly signed, hence it must not be validated.
+func newTestPEMCRL(number int64, thisUpdate time.Time) []byte {
+	sha256WithRSA := pkix.AlgorithmIdentifier{
+		Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11},
+	}
+	tbs := pkix.TBSCertificateList{
+		Version:    1,
+		Signature:  sha256WithRSA,
+		Issuer:     pkix.Name{CommonName: "test"}.ToRDNSequence(),
+		ThisUpdate: thisUpdate.UTC(),
+		NextUpdate: thisUpdate.UTC().Add(100 * 365 * 24 * time.Hour),
+	}
+	if number > 0 {
+		value, err := asn1.Marshal(big.NewInt(number))
+		if err != nil {
+			panic("cannot marshal crl number: " + err.Error())
+		}
+		tbs.Extensions = append(tbs.Extensions, pkix.Extension{Id: oidCRLNumber, Value: value})
+	}
+	der, err := asn1.Marshal(pkix.CertificateList{
+		TBSCertList:        tbs,
+		SignatureAlgorithm: sha256WithRSA,
+		SignatureValue:     asn1.BitString{Bytes: []byte{0x00}, BitLength: 8},
+	})
+	if err != nil {
+		panic("cannot marshal test crl: " + err.Error())
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+}
+
+func newCRLServer(crl []byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if crl == nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Write(crl)
+	}))
+}
+
+func TestWorker_Fetch(t *testing.T) {
+	t.Run("Highest CRL Number", testWorkerFetchHighestCRLNumber)
+	t.Run("Newest ThisUpdate", testWorkerFetchNewestThisUpdate)
+	t.Run("Failover", testWorkerFetchFailover)
+	t.Run("Failover On Timeout", testWorkerFetchFailoverOnTimeout)
+	t.Run("Slow Body", testWorkerFetchSlowBody)
+	t.Run("All Mirrors Fail", testWorkerFetchAllMirrorsFail)
+	t.Run("Older Than Current", testWorkerFetchOlderThanCurrent)
+	t.Run("File Scheme", testWorkerFetchFileScheme)
+}
+
+func testWorkerFetchHighestCRLNumber(t *testing.T) {
+	now := time.Now()
+	ts1 := newCRLServer(newTestPEMCRL(1, now))
+	defer ts1.Close()
+	ts2 := newCRLServer(newTestPEMCRL(2, now.Add(-time.Hour)))
+	defer ts2.Close()
+
+	w := worker{crlName: "test", urls: []string{ts1.URL, ts2.URL}}
+	l := new(bufferedLogger)
+	fetched, err := w.fetch(l)
+	if err != nil {
+		t.Fatalf("worker.fetch: unexpected error %q", err.Error())
+	}
+	if fetched.url != ts2.URL {
+		t.Errorf("worker.fetch: got crl from %q; want %q", fetched.url, ts2.URL)
+	}
+	wantNotice := "crl \"test\" fetched from " + ts2.URL
+	if got := l.GetLastNotice(); got != wantNotice {
+		t.Errorf("worker.fetch: got notice %q; want %q", got, wantNotice)
+	}
+}
+
+func testWorkerFetchNewestThisUpdate(t *testing.T) {
+	now := time.Now()
+	ts1 := newCRLServer(newTestPEMCRL(0, now.Add(-time.Hour)))
+	defer ts1.Close()
+	ts2 := newCRLServer(newTestPEMCRL(0, now))
+	defer ts2.Close()
+
+	w := worker{crlName: "test", urls: []string{ts1.URL, ts2.URL}}
+	fetched, err := w.fetch(&discardLogger{})
+	if err != nil {
+		t.Fatalf("worker.fetch: unexpected error %q", err.Error())
+	}
+	if fetched.url != ts2.URL {
+		t.Errorf("worker.fetch: got crl from %q; want %q", fetched.url, ts2.URL)
+	}
+}
+
+func testWorkerFetchFailover(t *testing.T) {
+	ts1 := newCRLServer(nil)
+	defer ts1.Close()
+	ts2 := newCRLServer(newTestPEMCRL(1, time.Now()))
+	defer ts2.Close()
+
+	w := worker{crlName: "test", urls: []string{ts1.URL, ts2.URL}}
+	l := new(bufferedLogger)
+	fetched, err := w.fetch(l)
+	if err != nil {
+		t.Fatalf("worker.fetch: unexpected error %q", err.Error())
+	}
+	if fetched.url != ts2.URL {
+		t.Errorf("worker.fetch: got crl from %q; want %q", fetched.url, ts2.URL)
+	}
+	wantErr := "crl distribution point " + ts1.URL + ": cannot fetch crl due to http error: 404 Not Found"
+	if err := l.GetLastError(); err == nil {
+		t.Error("worker.fetch: expected error to be logged, got nil")
+	} else if err.Error() != wantErr {
+		t.Errorf("worker.fetch: got logged error %q; want %q", err.Error(), wantErr)
+	}
+}
+
+func testWorkerFetchFailoverOnTimeout(t *testing.T) {
+	done := make(chan struct{})
+	ts1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer ts1.Close()
+	defer close(done)
+	ts2 := newCRLServer(newTestPEMCRL(1, time.Now()))
+	defer ts2.Close()
+
+	timeout := crlTransport.ResponseHeaderTimeout
+	crlTransport.ResponseHeaderTimeout = 100 * time.Millisecond
+	defer func() { crlTransport.ResponseHeaderTimeout = timeout }()
+
+	w := worker{crlName: "test", urls: []string{ts1.URL, ts2.URL}}
+	fetched, err := w.fetch(&discardLogger{})
+	if err != nil {
+		t.Fatalf("worker.fetch: unexpected error %q", err.Error())
+	}
+	if fetched.url != ts2.URL {
+		t.Errorf("worker.fetch: got crl from %q; want %q", fetched.url, ts2.URL)
+	}
+}
+
+func testWorkerFetchSlowBody(t *testing.T) {
+	pemCRL := newTestPEMCRL(1, time.Now())
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		time.Sleep(300 * time.Millisecond)
+		w.Write(pemCRL)
+	}))
+	defer ts.Close()
+
+	timeout := crlTransport.ResponseHeaderTimeout
+	crlTransport.ResponseHeaderTimeout = 100 * time.Millisecond
+	defer func() { crlTransport.ResponseHeaderTimeout = timeout }()
+
+	w := worker{crlName: "test", urls: []string{ts.URL}}
+	if _, err := w.fetch(&discardLogger{}); err != nil {
+		t.Fatalf("worker.fetch: unexpected error %q", err.Error())
+	}
+}
+
+func testWorkerFetchAllMirrorsFail(t *testing.T) {
+	ts1 := newCRLServer(nil)
+	defer ts1.Close()
+	ts2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	}))
+	defer ts2.Close()
+
+	w := worker{crlName: "test", urls: []string{ts1.URL, ts2.URL}}
+	_, err := w.fetch(&discardLogger{})
+	if err == nil {
+		t.Fatal("worker.fetch: expected error, got nil")
+	}
+	wantErr := "cannot fetch crl from any distribution point: " +
+		ts1.URL + ": cannot fetch crl due to http error: 404 Not Found; " +
+		ts2.URL + ": cannot fetch crl due to http error: 500 Internal Server Error"
+	if err.Error() != wantErr {
+		t.Errorf("worker.fetch: got error %q; want %q", err.Error(), wantErr)
+	}
+}
+
+func testWorkerFetchOlderThanCurrent(t *testing.T) {
+	ts := newCRLServer(newTestPEMCRL(1, time.Now()))
+	defer ts.Close()
+
+	w := worker{crlName: "test", urls: []string{ts.URL}}
+	w.current = &crlState{number: big.NewInt(2)}
+	if _, err := w.fetch(&discardLogger{}); err != errNotModified {
+		t.Errorf("worker.fetch: got error %v; want %v", err, errNotModified)
+	}
+}
+
+func testWorkerFetchFileScheme(t *testing.T) {
+	f, err := ioutil.TempFile(os.TempDir(), "crl2f5-connector-test")
+	if err != nil {
+		t.Fatal("setup: ", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.Write(newTestPEMCRL(1, time.Now())); err != nil {
+		t.Fatal("setup: ", err)
+	}
+
+	ts := newCRLServer(nil)
+	defer ts.Close()
+
+	url := "file://" + f.Name()
+	w := worker{crlName: "test", urls: []string{ts.URL, url}}
+	fetched, err := w.fetch(&discardLogger{})
+	if err != nil {
+		t.Fatalf("worker.fetch: unexpected error %q", err.Error())
+	}
+	if fetched.url != url {
+		t.Errorf("worker.fetch: got crl from %q; want %q", fetched.url, url)
+	}
+	if n := fetched.state.number; n == nil || n.Int64() != 1 {
+		t.Errorf("worker.fetch: got crl number %v; want 1", n)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/e-XpertSolutions/f5-rest-client/f5"
+)
+
+// haModeActiveOnly is the HA mode in which CRLs are only pushed to the active
+// unit of a device group, the other units receiving them through config-sync.
+const haModeActiveOnly = "active-only"
+
+// failoverActive is the failover state of the active unit of a device group.
+const failoverActive = "ACTIVE"
+
+// isValidHAMode reports whether mode is a supported HA mode. The empty mode
+// pushes CRLs to every BigIP regardless of their failover state.
+func isValidHAMode(mode string) bool {
+	return mode == "" || mode == haModeActiveOnly
+}
+
+// getFailoverState returns the failover state of the device, e.g. "ACTIVE" or
+// "STANDBY".
+func getFailoverState(f5Client *f5.Client) (string, error) {
+	state, _, err := getCMStatus(f5Client, "/mgmt/tm/cm/failover-status")
+	if err != nil {
+		return "", errors.New("cannot get failover state: " + err.Error())
+	}
+	return state, nil
+}
+
+// unitSelection tells whether a CRL must be pushed to a BigIP.
+type unitSelection struct {
+	// failoverState is the failover state reported by the BigIP, only known
+	// in active-only mode.
+	failoverState string
+
+	// skip is set for the units that are not active in active-only mode.
+	skip bool
+
+	// err is the error that prevents the CRL from being pushed to the BigIP.
+	err error
+}
+
+// selectActiveUnits returns, for each BigIP, whether the CRL must be pushed to
+// it. Every BigIP in active-only mode is asked for its failover state: the
+// active units of a device group are selected and the others are skipped. If
+// no unit of a device group is active, all of them fail.
+func (w *worker) selectActiveUnits(bigIPs []*bigIP, l logger) []unitSelection {
+	selections := make([]unitSelection, len(bigIPs))
+	groups := make(map[string][]int)
+	var groupNames []string
+	for i, b := range bigIPs {
+		if b.haMode != haModeActiveOnly {
+			continue
+		}
+		state, err := getFailoverState(b.client)
+		if err != nil {
+			l.With(fieldBigIP, b.name).Error("crl \"", w.crlName, "\": ", b.name, ": ", err)
+			selections[i].err = err
+			state = "unknown"
+		}
+		selections[i].failoverState = state
+		if _, ok := groups[b.syncDeviceGroup]; !ok {
+			groupNames = append(groupNames, b.syncDeviceGroup)
+		}
+		groups[b.syncDeviceGroup] = append(groups[b.syncDeviceGroup], i)
+	}
+
+	for _, group := range groupNames {
+		var (
+			hasActive bool
+			states    []string
+		)
+		for _, i := range groups[group] {
+			if selections[i].failoverState == failoverActive {
+				hasActive = true
+			}
+			states = append(states, bigIPs[i].name+" is "+selections[i].failoverState)
+		}
+		if !hasActive {
+			err := errors.New("no active unit in device group \"" + group + "\" (" + strings.Join(states, ", ") + ")")
+			l.Error("crl \"", w.crlName, "\": ", err)
+			for _, i := range groups[group] {
+				selections[i].err = err
+			}
+			continue
+		}
+		for _, i := range groups[group] {
+			if selections[i].err == nil && selections[i].failoverState != failoverActive {
+				selections[i].skip = true
+				l.With(fieldBigIP, bigIPs[i].name).Notice("crl \"", w.crlName, "\": skipping ", bigIPs[i].name, ", failover state is ", selections[i].failoverState)
+			}
+		}
+	}
+	return selections
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/e-XpertSolutions/f5-rest-client/f5"
+)
+
+func TestWorker_SelectActiveUnits(t *testing.T) {
+	tests := []struct {
+		name     string
+		states   []string // failover state of each BigIP, "-" to disable the api
+		haModes  []string
+		wantSkip []bool
+		wantErrs []string
+	}{
+		{
+			name:     "Active Standby",
+			states:   []string{"STANDBY", "ACTIVE"},
+			haModes:  []string{haModeActiveOnly, haModeActiveOnly},
+			wantSkip: []bool{true, false},
+			wantErrs: []string{"", ""},
+		},
+		{
+			name:     "No Active Unit",
+			states:   []string{"STANDBY", "-"},
+			haModes:  []string{haModeActiveOnly, haModeActiveOnly},
+			wantSkip: []bool{false, false},
+			wantErrs: []string{
+				"no active unit in device group \"failover\" (bigip1 is STANDBY, bigip2 is unknown)",
+				"no active unit in device group \"failover\" (bigip1 is STANDBY, bigip2 is unknown)",
+			},
+		},
+		{
+			name:     "Unknown State",
+			states:   []string{"ACTIVE", "-"},
+			haModes:  []string{haModeActiveOnly, haModeActiveOnly},
+			wantSkip: []bool{false, false},
+			wantErrs: []string{"", "cannot get failover state: http response error: 404 Not Found"},
+		},
+		{
+			name:     "Default Mode",
+			states:   []string{"-", "STANDBY"},
+			haModes:  []string{"", ""},
+			wantSkip: []bool{false, false},
+			wantErrs: []string{"", ""},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var bigIPs []*bigIP
+			for i, state := range test.states {
+				srv := newBigIPServer()
+				if state == "-" {
+					srv.Disable = "failover-status"
+				} else {
+					srv.failoverState = state
+				}
+				tsBigIP := httptest.NewServer(srv)
+				defer tsBigIP.Close()
+
+				f5Client, err := f5.NewBasicClient(tsBigIP.URL, "admin", "admin")
+				if err != nil {
+					t.Fatal("cannot instanciate f5 basic client: ", err)
+				}
+				bigIPs = append(bigIPs, &bigIP{
+					name:            "bigip" + strconv.Itoa(i+1),
+					client:          f5Client,
+					syncDeviceGroup: "failover",
+					haMode:          test.haModes[i],
+				})
+			}
+
+			w := worker{crlName: "test"}
+			units := w.selectActiveUnits(bigIPs, &discardLogger{})
+			for i, unit := range units {
+				if unit.skip != test.wantSkip[i] {
+					t.Errorf("worker.selectActiveUnits: bigip%d: got skip %v; want %v", i+1, unit.skip, test.wantSkip[i])
+				}
+				var gotErr string
+				if unit.err != nil {
+					gotErr = unit.err.Error()
+				}
+				if gotErr != test.wantErrs[i] {
+					t.Errorf("worker.selectActiveUnits: bigip%d: got error %q; want %q", i+1, gotErr, test.wantErrs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestWorker_DoActiveOnly(t *testing.T) {
+	tsCA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(crlFile))
+	}))
+	defer tsCA.Close()
+
+	var (
+		servers []*bigIPServer
+		bigIPs  []*bigIP
+	)
+	for _, state := range []string{"ACTIVE", "STANDBY"} {
+		srv := newBigIPServer()
+		srv.failoverState = state
+		tsBigIP := httptest.NewServer(srv)
+		defer tsBigIP.Close()
+
+		f5Client, err := f5.NewBasicClient(tsBigIP.URL, "admin", "admin")
+		if err != nil {
+			t.Fatal("cannot instanciate f5 basic client: ", err)
+		}
+		servers = append(servers, srv)
+		bigIPs = append(bigIPs, &bigIP{name: state, client: f5Client, syncDeviceGroup: "failover", haMode: haModeActiveOnly})
+	}
+
+	w := worker{
+		urls:         []string{tsCA.URL},
+		crlName:      "test",
+		profiles:     profileSelector{refs: []profileRef{{clientSSL, "/Common/clientssl"}}},
+		refreshDelay: 300,
+		stopCh:       make(chan struct{}),
+	}
+	status := w.do(bigIPs, &discardLogger{})
+	for i := range bigIPs {
+		if err := status.err(i); err != nil {
+			t.Errorf("worker.do: %s: unexpected error %q", bigIPs[i].name, err.Error())
+		}
+	}
+	if status.skipped[0] || !status.skipped[1] {
+		t.Errorf("worker.do: got skipped %v; want [false true]", status.skipped)
+	}
+	if got := len(servers[0].crlFiles); got != 1 {
+		t.Errorf("worker.do: got %d crl files uploaded to the active unit; want 1", got)
+	}
+	if got := len(servers[1].crlFiles); got != 0 {
+		t.Errorf("worker.do: got %d crl files uploaded to the standby unit; want none", got)
+	}
+	if got := len(servers[0].syncCommands); got != 1 {
+		t.Errorf("worker.do: got %d config-sync commands on the active unit; want 1", got)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// runResult is the outcome of an operation of a worker.
+type runResult struct {
+	at  time.Time
+	err error
+}
+
+// pushResult reports the pushes of a worker to a BigIP.
+type pushResult struct {
+	// last is the outcome of the last push. skipped is set when the BigIP
+	// has been skipped because it is not the active unit of its device
+	// group.
+	last    runResult
+	skipped bool
+
+	// lastSuccess is the last time the BigIP was known to have the current
+	// CRL, i.e. it has been pushed or it has not changed since.
+	lastSuccess time.Time
+}
+
+func (w *worker) setRunning(running bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.running = running
+}
+
+// recordFetch records the outcome of a fetch.
+func (w *worker) recordFetch(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.lastFetch = runResult{at: time.Now(), err: err}
+}
+
+// recordPush records the outcome of a push to the BigIP.
+func (w *worker) recordPush(b *bigIP, err error, skipped bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.results == nil {
+		w.results = make(map[*bigIP]*pushResult)
+	}
+	r, ok := w.results[b]
+	if !ok {
+		r = new(pushResult)
+		w.results[b] = r
+	}
+	r.last = runResult{at: time.Now(), err: err}
+	r.skipped = skipped
+	if err == nil && !skipped {
+		r.lastSuccess = r.last.at
+	}
+}
+
+// recordUpToDate records that the CRL has not changed since it has been pushed
+// to the BigIPs.
+func (w *worker) recordUpToDate(bigIPs []*bigIP) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := time.Now()
+	for _, b := range bigIPs {
+		if r, ok := w.results[b]; ok && !r.skipped {
+			if _, pushed := w.pushed[b]; pushed {
+				r.lastSuccess = now
+			}
+		}
+	}
+}
+
+// healthOptions defines the thresholds of the readiness probe.
+type healthOptions struct {
+	// staleAfter is the maximum time since the last successful push to a
+	// BigIP. It defaults to twice the refresh delay of the worker.
+	staleAfter time.Duration
+
+	// expiryThreshold is the minimum time left before the CRL deployed on a
+	// BigIP expires.
+	expiryThreshold time.Duration
+}
+
+// healthReport is the body of the responses of the health endpoints.
+type healthReport struct {
+	Status  string         `json:"status"`
+	Workers []workerHealth `json:"workers"`
+}
+
+type workerHealth struct {
+	CRL       string        `json:"crl"`
+	Running   bool          `json:"running"`
+	LastFetch *resultHealth `json:"last_fetch,omitempty"`
+	BigIPs    []bigIPHealth `json:"bigips"`
+
+	// Problems lists the reasons why the worker is not ready.
+	Problems []string `json:"problems,omitempty"`
+}
+
+type resultHealth struct {
+	Time  time.Time `json:"time"`
+	Error string    `json:"error,omitempty"`
+}
+
+type bigIPHealth struct {
+	Name        string        `json:"name"`
+	LastPush    *resultHealth `json:"last_push,omitempty"`
+	Skipped     bool          `json:"skipped,omitempty"`
+	LastSuccess *time.Time    `json:"last_success,omitempty"`
+	NextUpdate  *time.Time    `json:"next_update,omitempty"`
+}
+
+func newResultHealth(r runResult) *resultHealth {
+	if r.at.IsZero() {
+		return nil
+	}
+	h := &resultHealth{Time: r.at}
+	if r.err != nil {
+		h.Error = r.err.Error()
+	}
+	return h
+}
+
+// health reports the state of the worker for each of the BigIPs. The worker is
+// not ready if its routine is not running, or if a BigIP that is not skipped
+// has not received the CRL within the staleness window or holds a CRL that
+// expires within the threshold.
+func (w *worker) health(bigIPs []*bigIP, now time.Time, opts healthOptions) workerHealth {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	staleAfter := opts.staleAfter
+	if staleAfter <= 0 {
+		staleAfter = 2 * w.refreshDelay
+	}
+	h := workerHealth{
+		CRL:       w.crlName,
+		Running:   w.running,
+		LastFetch: newResultHealth(w.lastFetch),
+		BigIPs:    make([]bigIPHealth, 0, len(bigIPs)),
+	}
+	if !w.running {
+		h.Problems = append(h.Problems, "worker routine is not running")
+	}
+	for _, b := range bigIPs {
+		bh := bigIPHealth{Name: b.name}
+		r, ok := w.results[b]
+		if ok {
+			bh.LastPush = newResultHealth(r.last)
+			bh.Skipped = r.skipped
+			if !r.lastSuccess.IsZero() {
+				lastSuccess := r.lastSuccess
+				bh.LastSuccess = &lastSuccess
+			}
+		}
+		if state, ok := w.pushed[b]; ok && !state.nextUpdate.IsZero() {
+			nextUpdate := state.nextUpdate
+			bh.NextUpdate = &nextUpdate
+		}
+		h.BigIPs = append(h.BigIPs, bh)
+
+		if bh.Skipped {
+			continue
+		}
+		switch {
+		case bh.LastSuccess == nil:
+			h.Problems = append(h.Problems, "crl not pushed to "+b.name+" yet")
+		case now.Sub(*bh.LastSuccess) > staleAfter:
+			h.Problems = append(h.Problems, "crl not pushed to "+b.name+" within "+staleAfter.String())
+		}
+		if bh.NextUpdate != nil && !now.Add(opts.expiryThreshold).Before(*bh.NextUpdate) {
+			h.Problems = append(h.Problems, "crl deployed on "+b.name+" expires at "+bh.NextUpdate.Format(time.RFC3339))
+		}
+	}
+	return h
+}
+
+// health reports the state of every worker of the pool.
+func (p *pool) health(now time.Time, opts healthOptions) healthReport {
+	report := healthReport{Workers: make([]workerHealth, 0, len(p.workers))}
+	for _, w := range p.workers {
+		report.Workers = append(report.Workers, w.health(p.bigIPs, now, opts))
+	}
+	return report
+}
+
+// healthHandler serves the liveness and readiness probes of the pool.
+type healthHandler struct {
+	pool *pool
+	opts healthOptions
+}
+
+// serveHealthz reports whether the routines of all the workers are alive.
+func (h healthHandler) serveHealthz(w http.ResponseWriter, r *http.Request) {
+	report := h.pool.health(time.Now(), h.opts)
+	ok := true
+	for _, wh := range report.Workers {
+		ok = ok && wh.Running
+	}
+	writeHealthReport(w, report, ok)
+}
+
+// serveReadyz reports whether all the workers are ready, see worker.health.
+func (h healthHandler) serveReadyz(w http.ResponseWriter, r *http.Request) {
+	report := h.pool.health(time.Now(), h.opts)
+	ok := true
+	for _, wh := range report.Workers {
+		ok = ok && len(wh.Problems) == 0
+	}
+	writeHealthReport(w, report, ok)
+}
+
+func writeHealthReport(w http.ResponseWriter, report healthReport, ok bool) {
+	report.Status = "ok"
+	status := http.StatusOK
+	if !ok {
+		report.Status = "fail"
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/e-XpertSolutions/f5-rest-client/f5"
+)
+
+func TestWorker_Health(t *testing.T) {
+	now := time.Date(2017, 9, 4, 12, 0, 0, 0, time.UTC)
+	b1 := &bigIP{name: "bigip1"}
+	b2 := &bigIP{name: "bigip2"}
+
+	tests := []struct {
+		name    string
+		running bool
+		results map[*bigIP]*pushResult
+		pushed  map[*bigIP]crlState
+		opts    healthOptions
+		want    []string
+	}{
+		{
+			name:    "Ready",
+			running: true,
+			results: map[*bigIP]*pushResult{
+				b1: {last: runResult{at: now.Add(-time.Hour)}, lastSuccess: now.Add(-time.Hour)},
+				b2: {last: runResult{at: now.Add(-time.Hour)}, skipped: true},
+			},
+			pushed: map[*bigIP]crlState{b1: {nextUpdate: now.Add(48 * time.Hour)}},
+			opts:   healthOptions{expiryThreshold: 24 * time.Hour},
+		},
+		{
+			name: "Not Running",
+			results: map[*bigIP]*pushResult{
+				b1: {lastSuccess: now},
+				b2: {lastSuccess: now},
+			},
+			want: []string{"worker routine is not running"},
+		},
+		{
+			name:    "Stale",
+			running: true,
+			results: map[*bigIP]*pushResult{
+				b1: {last: runResult{at: now, err: errors.New("push failed")}, lastSuccess: now.Add(-3 * time.Hour)},
+			},
+			want: []string{"crl not pushed to bigip1 within 2h0m0s", "crl not pushed to bigip2 yet"},
+		},
+		{
+			name:    "Custom Staleness Window",
+			running: true,
+			results: map[*bigIP]*pushResult{
+				b1: {lastSuccess: now.Add(-3 * time.Hour)},
+				b2: {lastSuccess: now.Add(-time.Hour)},
+			},
+			opts: healthOptions{staleAfter: 90 * time.Minute},
+			want: []string{"crl not pushed to bigip1 within 1h30m0s"},
+		},
+		{
+			name:    "Expiring CRL",
+			running: true,
+			results: map[*bigIP]*pushResult{
+				b1: {lastSuccess: now},
+				b2: {lastSuccess: now},
+			},
+			pushed: map[*bigIP]crlState{
+				b1: {nextUpdate: now.Add(12 * time.Hour)},
+				b2: {nextUpdate: now.Add(-time.Hour)},
+			},
+			opts: healthOptions{expiryThreshold: 24 * time.Hour},
+			want: []string{
+				"crl deployed on bigip1 expires at 2017-09-05T00:00:00Z",
+				"crl deployed on bigip2 expires at 2017-09-04T11:00:00Z",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := &worker{
+				crlName:      "test",
+				refreshDelay: time.Hour,
+				running:      test.running,
+				results:      test.results,
+				pushed:       test.pushed,
+			}
+			got := w.health([]*bigIP{b1, b2}, now, test.opts)
+			if !reflect.DeepEqual(got.Problems, test.want) {
+				t.Errorf("worker.health: got problems %q; want %q", got.Problems, test.want)
+			}
+		})
+	}
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	srv := newBigIPServer()
+	tsBigIP := httptest.NewServer(srv)
+	defer tsBigIP.Close()
+
+	f5Client, err := f5.NewBasicClient(tsBigIP.URL, "admin", "admin")
+	if err != nil {
+		t.Fatal("cannot instanciate f5 basic client: ", err)
+	}
+	tsCA := newCRLServer(newTestPEMCRL(1, time.Now()))
+	defer tsCA.Close()
+
+	p := new(pool)
+	if err := p.addWorker(crlConfig{Name: "test", URL: tsCA.URL, ProfileName: "clientssl", RefreshDelay: duration{Duration: time.Hour}}); err != nil {
+		t.Fatal("setup: ", err)
+	}
+	p.bigIPs = []*bigIP{{name: "bigip", client: f5Client}}
+
+	cfg := &config{Health: healthConfig{Listen: ":9100"}}
+	ts := httptest.NewServer(newServeMuxes(cfg, p)[":9100"])
+	defer ts.Close()
+
+	get := func(path string) (int, healthReport) {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s: unexpected error %q", path, err.Error())
+		}
+		defer resp.Body.Close()
+		var report healthReport
+		if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+			t.Fatalf("GET %s: cannot decode body: %v", path, err)
+		}
+		return resp.StatusCode, report
+	}
+
+	// The worker routine has not been started yet.
+	if code, report := get("/healthz"); code != http.StatusServiceUnavailable || report.Status != "fail" {
+		t.Errorf("GET /healthz: got %d %q; want 503 \"fail\"", code, report.Status)
+	}
+
+	p.workers[0].setRunning(true)
+	if code, report := get("/healthz"); code != http.StatusOK || report.Status != "ok" {
+		t.Errorf("GET /healthz: got %d %q; want 200 \"ok\"", code, report.Status)
+	}
+	code, report := get("/readyz")
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("GET /readyz: got %d before the first push; want 503", code)
+	}
+	if want := []string{"crl not pushed to bigip yet"}; len(report.Workers) != 1 || !reflect.DeepEqual(report.Workers[0].Problems, want) {
+		t.Errorf("GET /readyz: got workers %+v; want a single worker with problems %q", report.Workers, want)
+	}
+
+	p.workers[0].do(p.bigIPs, discardLogger{})
+	code, report = get("/readyz")
+	if code != http.StatusOK || report.Status != "ok" {
+		t.Errorf("GET /readyz: got %d %q after a push; want 200 \"ok\"", code, report.Status)
+	}
+	if len(report.Workers) != 1 || len(report.Workers[0].BigIPs) != 1 {
+		t.Fatalf("GET /readyz: got workers %+v; want a single worker and bigip", report.Workers)
+	}
+	wh := report.Workers[0]
+	if wh.CRL != "test" || !wh.Running || wh.LastFetch == nil || wh.LastFetch.Error != "" {
+		t.Errorf("GET /readyz: got worker %+v; want a running worker with a successful fetch", wh)
+	}
+	bh := wh.BigIPs[0]
+	if bh.Name != "bigip" || bh.LastPush == nil || bh.LastPush.Error != "" || bh.LastSuccess == nil || bh.NextUpdate == nil {
+		t.Errorf("GET /readyz: got bigip %+v; want a successful push", bh)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultJournalSocket is the socket on which the systemd journal receives
+// events in its native protocol.
+const defaultJournalSocket = "/run/systemd/journal/socket"
+
+// journaldSink sends events to the systemd journal using its native protocol.
+// The fields of the events become journal fields, such as WORKER or BIGIP,
+// and can be used to filter the journal, e.g. `journalctl WORKER=test`.
+//
+// Events that do not fit in a datagram are not sent. They are written to the
+// fallback writer instead, like the events that cannot be sent.
+type journaldSink struct {
+	mu       sync.Mutex
+	conn     net.Conn
+	opts     syslogOptions
+	fallback io.Writer
+}
+
+// newJournaldSink connects to the journal socket, which may be overridden by
+// the address of the configuration.
+func newJournaldSink(cfg logConfig, fallback io.Writer) (*journaldSink, error) {
+	opts, err := newSyslogOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	address := cfg.Address
+	if address == "" {
+		address = defaultJournalSocket
+	}
+	conn, err := net.Dial("unixgram", address)
+	if err != nil {
+		return nil, errors.New("cannot connect to the journal at " + address + ": " + err.Error())
+	}
+	return &journaldSink{conn: conn, opts: opts, fallback: fallback}, nil
+}
+
+func (s *journaldSink) writeEvent(t time.Time, level logLevel, msg string, fields []logField) {
+	var buf bytes.Buffer
+	writeJournalField(&buf, "MESSAGE", msg)
+	writeJournalField(&buf, "PRIORITY", strconv.Itoa(s.opts.severities[level]))
+	writeJournalField(&buf, "SYSLOG_FACILITY", strconv.Itoa(s.opts.facility))
+	writeJournalField(&buf, "SYSLOG_IDENTIFIER", s.opts.tag)
+	writeJournalField(&buf, "SYSLOG_PID", strconv.Itoa(os.Getpid()))
+	for _, f := range fields {
+		writeJournalField(&buf, journalFieldName(f.key), f.value)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.conn.Write(buf.Bytes()); err != nil {
+		fmt.Fprintf(s.fallback, "cannot send event to the journal: %v\n", err)
+		s.fallback.Write(formatTextEvent(t, level, msg, fields))
+	}
+}
+
+// writeJournalField writes a field in the native protocol of the journal.
+// Values spanning several lines are prefixed by their length, encoded as a
+// little endian 64 bits integer.
+func writeJournalField(buf *bytes.Buffer, name, value string) {
+	if !strings.Contains(value, "\n") {
+		buf.WriteString(name + "=" + value + "\n")
+		return
+	}
+	buf.WriteString(name + "\n")
+	binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value + "\n")
+}
+
+// journalFieldName turns the key of a field into a valid journal field name,
+// made of uppercase letters, digits and underscores.
+func journalFieldName(key string) string {
+	name := []byte(strings.ToUpper(key))
+	for i, c := range name {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			name[i] = '_'
+		}
+	}
+	return strings.TrimLeft(string(name), "_")
+}
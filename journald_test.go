@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// parseJournalFields decodes a datagram sent in the native protocol of the
+// journal.
+func parseJournalFields(t *testing.T, data []byte) map[string]string {
+	fields := make(map[string]string)
+	for len(data) > 0 {
+		eol := bytes.IndexByte(data, '\n')
+		if eol < 0 {
+			t.Fatalf("unterminated journal field %q", data)
+		}
+		line := data[:eol]
+		data = data[eol+1:]
+		if eq := bytes.IndexByte(line, '='); eq >= 0 {
+			fields[string(line[:eq])] = string(line[eq+1:])
+			continue
+		}
+		if len(data) < 8 {
+			t.Fatalf("missing length of journal field %q", line)
+		}
+		n := binary.LittleEndian.Uint64(data)
+		data = data[8:]
+		fields[string(line)] = string(data[:n])
+		data = data[n+1:]
+	}
+	return fields
+}
+
+func TestJournaldLogger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crl2f5")
+	if err != nil {
+		t.Fatal("setup: ", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "journal.sock")
+	conn, err := net.ListenPacket("unixgram", path)
+	if err != nil {
+		t.Fatal("setup: ", err)
+	}
+	defer conn.Close()
+
+	cfg := logConfig{
+		Output:     logOutputJournald,
+		Address:    path,
+		Facility:   "local3",
+		Severities: map[string]string{"error": "crit"},
+	}
+	l, err := newConfiguredLogger(ioutil.Discard, cfg, false)
+	if err != nil {
+		t.Fatalf("newConfiguredLogger: unexpected error %q", err.Error())
+	}
+	l.With(fieldWorker, "test").With(fieldCRLURL, "https://pki/a.crl").Error("cannot fetch crl:\n404 Not Found")
+
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("cannot read journal entry: %v", err)
+	}
+	want := map[string]string{
+		"MESSAGE":           "cannot fetch crl:\n404 Not Found",
+		"PRIORITY":          "2",
+		"SYSLOG_FACILITY":   "19",
+		"SYSLOG_IDENTIFIER": defaultSyslogTag,
+		"SYSLOG_PID":        strconv.Itoa(os.Getpid()),
+		"WORKER":            "test",
+		"CRL_URL":           "https://pki/a.crl",
+	}
+	if got := parseJournalFields(t, buf[:n]); !reflect.DeepEqual(got, want) {
+		t.Errorf("got journal fields %q; want %q", got, want)
+	}
+}
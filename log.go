@@ -1,38 +1,270 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logLevel is the severity of a log event.
+type logLevel int
+
+// Supported log levels, from the least to the most severe.
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelNotice
+	levelWarn
+	levelError
+)
+
+var logLevelNames = [...]string{"debug", "info", "notice", "warn", "error"}
+
+func (lvl logLevel) String() string {
+	return logLevelNames[lvl]
+}
+
+// parseLogLevel returns the level with the given name.
+func parseLogLevel(name string) (logLevel, bool) {
+	for lvl, s := range logLevelNames {
+		if s == name {
+			return logLevel(lvl), true
+		}
+	}
+	return 0, false
+}
+
+// Supported log formats. The text format is the default one.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// Supported log outputs. Events are written to stderr by default.
+const (
+	logOutputStderr   = "stderr"
+	logOutputSyslog   = "syslog"
+	logOutputJournald = "journald"
+)
+
+// Keys of the fields attached to log events.
+const (
+	fieldWorker  = "worker"
+	fieldCRLURL  = "crl_url"
+	fieldBigIP   = "bigip"
+	fieldTxID    = "tx_id"
+	fieldCRLFile = "crl_file"
 )
 
 type logger interface {
-	Error(v ...interface{})
-	Errorf(format string, v ...interface{})
+	Debug(v ...interface{})
+	Info(v ...interface{})
 	Notice(v ...interface{})
 	Noticef(format string, v ...interface{})
+	Warn(v ...interface{})
+	Error(v ...interface{})
+	Errorf(format string, v ...interface{})
+
+	// With returns a logger attaching the given field to every event, in
+	// addition to the fields of the logger. A field with the same key is
+	// replaced.
+	With(key string, value interface{}) logger
+}
+
+// logField is a key/value pair attached to a log event.
+type logField struct {
+	key   string
+	value string
+}
+
+// logSink writes the events of a logger somewhere. It must be safe for
+// concurrent use.
+type logSink interface {
+	writeEvent(t time.Time, level logLevel, msg string, fields []logField)
+}
+
+// logOutput is the destination of the events of a logger and of the loggers
+// derived from it with With.
+type logOutput struct {
+	sink  logSink
+	level logLevel
 }
 
 type defaultLogger struct {
-	l *log.Logger
+	out    *logOutput
+	fields []logField
 }
 
+// newLogger returns a logger writing the events of level info and above to w,
+// in the text format.
 func newLogger(w io.Writer) logger {
-	return &defaultLogger{l: log.New(w, "", log.LstdFlags)}
+	return newFormattedLogger(w, logFormatText, levelInfo)
 }
 
-func (dl defaultLogger) Error(v ...interface{}) {
-	dl.l.Print("[error] ", fmt.Sprint(v...))
+// newConfiguredLogger returns the logger defined by the configuration. Events
+// are written to w unless the configuration sends them to syslog or to the
+// systemd journal, in which case w receives the events that cannot be sent.
+// The verbose mode lowers the level to debug.
+func newConfiguredLogger(w io.Writer, cfg logConfig, verbose bool) (logger, error) {
+	level, ok := parseLogLevel(cfg.Level)
+	if !ok {
+		level = levelInfo
+	}
+	if verbose {
+		level = levelDebug
+	}
+
+	var sink logSink
+	switch cfg.Output {
+	case logOutputSyslog:
+		s, err := newSyslogSink(cfg, w)
+		if err != nil {
+			return nil, err
+		}
+		sink = s
+	case logOutputJournald:
+		s, err := newJournaldSink(cfg, w)
+		if err != nil {
+			return nil, err
+		}
+		sink = s
+	default:
+		format := cfg.Format
+		if format == "" {
+			format = logFormatText
+		}
+		sink = &streamSink{w: w, format: format}
+	}
+	return &defaultLogger{out: &logOutput{sink: sink, level: level}}, nil
 }
 
-func (dl defaultLogger) Errorf(format string, v ...interface{}) {
-	dl.l.Print("[error] ", fmt.Sprintf(format, v...))
+// newFormattedLogger returns a logger writing the events of the given level and
+// above to w, in the given format.
+func newFormattedLogger(w io.Writer, format string, level logLevel) logger {
+	return &defaultLogger{out: &logOutput{sink: &streamSink{w: w, format: format}, level: level}}
+}
+
+func (dl defaultLogger) Debug(v ...interface{}) {
+	dl.log(levelDebug, fmt.Sprint(v...))
+}
+
+func (dl defaultLogger) Info(v ...interface{}) {
+	dl.log(levelInfo, fmt.Sprint(v...))
 }
 
 func (dl defaultLogger) Notice(v ...interface{}) {
-	dl.l.Print("[notice] ", fmt.Sprint(v...))
+	dl.log(levelNotice, fmt.Sprint(v...))
 }
 
 func (dl defaultLogger) Noticef(format string, v ...interface{}) {
-	dl.l.Print("[notice] ", fmt.Sprintf(format, v...))
+	dl.log(levelNotice, fmt.Sprintf(format, v...))
+}
+
+func (dl defaultLogger) Warn(v ...interface{}) {
+	dl.log(levelWarn, fmt.Sprint(v...))
+}
+
+func (dl defaultLogger) Error(v ...interface{}) {
+	dl.log(levelError, fmt.Sprint(v...))
+}
+
+func (dl defaultLogger) Errorf(format string, v ...interface{}) {
+	dl.log(levelError, fmt.Sprintf(format, v...))
+}
+
+func (dl defaultLogger) With(key string, value interface{}) logger {
+	field := logField{key: key, value: fmt.Sprint(value)}
+	fields := make([]logField, len(dl.fields), len(dl.fields)+1)
+	copy(fields, dl.fields)
+	for i := range fields {
+		if fields[i].key == key {
+			fields[i] = field
+			return &defaultLogger{out: dl.out, fields: fields}
+		}
+	}
+	return &defaultLogger{out: dl.out, fields: append(fields, field)}
+}
+
+func (dl defaultLogger) log(level logLevel, msg string) {
+	if level < dl.out.level {
+		return
+	}
+	dl.out.sink.writeEvent(time.Now(), level, msg, dl.fields)
+}
+
+// streamSink writes events to a stream, one per line, in the text or JSON
+// format.
+type streamSink struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format string
+}
+
+func (s *streamSink) writeEvent(t time.Time, level logLevel, msg string, fields []logField) {
+	var line []byte
+	if s.format == logFormatJSON {
+		line = formatJSONEvent(t, level, msg, fields)
+	} else {
+		line = formatTextEvent(t, level, msg, fields)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.w.Write(line)
+}
+
+// formatTextEvent formats an event as a line such as
+// `2017/09/04 12:00:00 [error] message worker=test bigip=https://bigip`. Field
+// values are quoted when needed.
+func formatTextEvent(t time.Time, level logLevel, msg string, fields []logField) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(t.Format("2006/01/02 15:04:05"))
+	buf.WriteString(" [" + level.String() + "] ")
+	buf.WriteString(msg)
+	writeTextFields(&buf, fields)
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+// writeTextFields appends the fields of an event as key=value pairs, quoting
+// the values that contain spaces, quotes or equal signs.
+func writeTextFields(buf *bytes.Buffer, fields []logField) {
+	for _, f := range fields {
+		value := f.value
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = strconv.Quote(value)
+		}
+		buf.WriteString(" " + f.key + "=" + value)
+	}
+}
+
+// formatJSONEvent formats an event as a JSON object on a single line, holding
+// the time, level and message of the event followed by its fields.
+func formatJSONEvent(t time.Time, level logLevel, msg string, fields []logField) []byte {
+	var buf bytes.Buffer
+	writeJSONField(&buf, "time", t.Format(time.RFC3339Nano), true)
+	writeJSONField(&buf, "level", level.String(), false)
+	writeJSONField(&buf, "msg", msg, false)
+	for _, f := range fields {
+		writeJSONField(&buf, f.key, f.value, false)
+	}
+	buf.WriteString("}\n")
+	return buf.Bytes()
+}
+
+func writeJSONField(buf *bytes.Buffer, key, value string, first bool) {
+	if first {
+		buf.WriteByte('{')
+	} else {
+		buf.WriteByte(',')
+	}
+	k, _ := json.Marshal(key)
+	v, _ := json.Marshal(value)
+	buf.Write(k)
+	buf.WriteByte(':')
+	buf.Write(v)
 }
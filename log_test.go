@@ -2,8 +2,11 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func trimDatetime(s string) string {
@@ -53,3 +56,55 @@ func TestDefaultLogger_Noticef(t *testing.T) {
 		t.Errorf("defaultLogger.Noticef(%q, %q): got %q; want %q", "%s", "test", got, want)
 	}
 }
+
+func TestDefaultLogger_Level(t *testing.T) {
+	buf := new(bytes.Buffer)
+	logger := newFormattedLogger(buf, logFormatText, levelNotice)
+	logger.Debug("debug")
+	logger.Info("info")
+	logger.Notice("notice")
+	logger.Warn("warn")
+	logger.Error("error")
+	var got []string
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		got = append(got, trimDatetime(line))
+	}
+	want := []string{"[notice] notice", "[warn] warn", "[error] error"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("defaultLogger: got %q; want %q", got, want)
+	}
+}
+
+func TestDefaultLogger_With(t *testing.T) {
+	buf := new(bytes.Buffer)
+	logger := newLogger(buf).With(fieldWorker, "test").With(fieldTxID, 1)
+	logger.With(fieldCRLFile, "/Common/test 1.crl").With(fieldTxID, 2).Error("test")
+	logger.Notice("test")
+	want := "[error] test worker=test tx_id=2 crl_file=\"/Common/test 1.crl\"\n"
+	if got := trimDatetime(strings.SplitAfter(buf.String(), "\n")[0]); got != want {
+		t.Errorf("defaultLogger.With: got %q; want %q", got, want)
+	}
+	want = "[notice] test worker=test tx_id=1\n"
+	if got := trimDatetime(strings.SplitAfter(buf.String(), "\n")[1]); got != want {
+		t.Errorf("defaultLogger.With: got %q; want %q", got, want)
+	}
+}
+
+func TestDefaultLogger_JSON(t *testing.T) {
+	buf := new(bytes.Buffer)
+	logger := newFormattedLogger(buf, logFormatJSON, levelInfo)
+	logger.With(fieldBigIP, "https://bigip").Warn("crl \"test\": ", "skipping")
+
+	var event map[string]string
+	if err := json.Unmarshal(buf.Bytes(), &event); err != nil {
+		t.Fatalf("defaultLogger: cannot decode event %q: %v", buf.String(), err)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, event["time"]); err != nil {
+		t.Errorf("defaultLogger: invalid time %q: %v", event["time"], err)
+	}
+	delete(event, "time")
+	want := map[string]string{"level": "warn", "msg": "crl \"test\": skipping", "bigip": "https://bigip"}
+	if !reflect.DeepEqual(event, want) {
+		t.Errorf("defaultLogger: got event %q; want %q", event, want)
+	}
+}
@@ -58,19 +58,39 @@ func fatal(v ...interface{}) {
 	exit(1)
 }
 
-// verbose prints to standard output (stdout) only when the verboseMode is
-// enabled. Otherwise it does nothing. The prefix "verbose:" is prepended to the
-// message. Arguments are handled in the manner of fmt.Print.
-func verbose(v ...interface{}) {
+// appLogger is the logger of the program, shared by the workers and by the
+// verbose, info and warning functions. It is set once the configuration has
+// been read.
+var appLogger logger
+
+// appLog returns appLogger or, if it is not set yet, a logger writing to
+// standard error output (stderr) in the text format.
+func appLog() logger {
+	if appLogger != nil {
+		return appLogger
+	}
+	level := levelInfo
 	if *verboseMode {
-		fmt.Fprintln(stdout, "verbose:", fmt.Sprint(v...))
+		level = levelDebug
 	}
+	return newFormattedLogger(stderr, logFormatText, level)
+}
+
+// verbose logs a debug event, only written when the verboseMode is enabled or
+// the log level is "debug". Arguments are handled in the manner of fmt.Print.
+func verbose(v ...interface{}) {
+	appLog().Debug(v...)
 }
 
-// info prints to standard output (stdout). The prefix "info:" is prepended to
-// the message. Arguments are handled in the manner of fmt.Print.
+// info logs an info event. Arguments are handled in the manner of fmt.Print.
 func info(v ...interface{}) {
-	fmt.Fprintln(stdout, "info:", fmt.Sprint(v...))
+	appLog().Info(v...)
+}
+
+// warning logs a warn event. Arguments are handled in the manner of
+// fmt.Print.
+func warning(v ...interface{}) {
+	appLog().Warn(v...)
 }
 
 // initF5Client initializes a new f5.Client with the provided configuration.
@@ -107,6 +127,13 @@ var (
 	configPath   = flag.String("config", "config.toml", "path to configuration file")
 	verboseMode  = flag.Bool("verbose", false, "enable verbose mode")
 	printVersion = flag.Bool("version", false, "print current version and exit")
+
+	checkConfigMode = flag.Bool("check-config", false, "validate the configuration file and exit")
+	checkFetch      = flag.Bool("check-fetch", false, "validate the configuration file like -check-config and also fetch the crl from every distribution point")
+	checkBigIP      = flag.Bool("check-bigip", false, "validate the configuration file like -check-config and also look up the client-ssl profiles on every bigip (read only)")
+
+	dryRunMode = flag.Bool("dry-run", false, "fetch every crl and print the changes that would be made on every bigip, without making any")
+	onceMode   = flag.Bool("once", false, "push every crl to every bigip a single time and exit with status 0 on success, 2 on partial failure and 1 on complete failure")
 )
 
 func main() {
@@ -117,31 +144,90 @@ func main() {
 		version()
 	}
 
+	if *checkConfigMode || *checkFetch || *checkBigIP {
+		opts := checkOptions{fetch: *checkFetch, bigip: *checkBigIP}
+		if !checkConfig(*configPath, opts, stdout) {
+			exit(1)
+		}
+		exit(0)
+		return
+	}
+
 	cfg, err := readConfig(*configPath)
 	if err != nil {
 		fatal(err)
 	}
-	if !cfg.hasCRLDistributionPoint() {
-		fatal("no crl distribution point provided in the configuration file")
+	appLogger, err = newConfiguredLogger(stderr, cfg.Log, *verboseMode)
+	if err != nil {
+		fatal("cannot initialize logger: ", err)
+	}
+	for _, msg := range cfg.deprecations {
+		warning(msg)
 	}
 
-	var f5Clients []*f5.Client
+	var bigIPs []*bigIP
 	for _, f5Cfg := range cfg.F5 {
 		f5Client, err := initF5Client(f5Cfg)
 		if err != nil {
 			fatal("cannot initialize f5 client: ", err)
 		}
-		f5Clients = append(f5Clients, f5Client)
+		b := &bigIP{
+			name:            f5Cfg.URL,
+			client:          f5Client,
+			syncDeviceGroup: f5Cfg.SyncDeviceGroup,
+			syncTimeout:     f5Cfg.SyncTimeout.Duration,
+			haMode:          f5Cfg.HAMode,
+		}
+		if f5Cfg.SaveConfig {
+			b.saver = new(configSaver)
+		}
+		bigIPs = append(bigIPs, b)
 	}
 
 	p := new(pool)
+	if !*dryRunMode {
+		p.notifier, err = newNotifier(cfg.Notify)
+		if err != nil {
+			fatal("cannot initialize notifications: ", err)
+		}
+	}
+	if cfg.Metrics.Listen != "" && !*dryRunMode && !*onceMode {
+		p.metrics = newMetrics()
+	}
 	for _, crlCfg := range cfg.CRL {
-		p.addWorker(crlCfg)
+		if err := p.addWorker(crlCfg); err != nil {
+			fatal("cannot initialize worker: ", err)
+		}
 	}
-	if err := p.startAll(f5Clients, newLogger(os.Stderr)); err != nil {
+
+	if *dryRunMode {
+		status := 0
+		for _, w := range p.workers {
+			if !w.plan(bigIPs, stdout, appLog()) {
+				status = 1
+			}
+		}
+		exit(status)
+		return
+	}
+
+	if *onceMode {
+		statuses := p.runOnce(bigIPs, appLog())
+		exit(summarize(stdout, p.workers, bigIPs, statuses))
+		return
+	}
+
+	if err := p.startAll(bigIPs, appLog()); err != nil {
 		fatal("cannot start workers: ", err)
 	}
 
+	for addr, mux := range newServeMuxes(cfg, p) {
+		if _, err := serveHTTP(addr, mux, appLog()); err != nil {
+			fatal("cannot listen on ", addr, ": ", err)
+		}
+		verbose("serving http endpoints on ", addr)
+	}
+
 	// Catch OS signal to gracefully shutdown the program.
 	sigChan := make(chan os.Signal, 2)
 	signal.Notify(sigChan, os.Interrupt, os.Kill)
@@ -58,6 +58,18 @@ func TestFatal(t *testing.T) {
 	}
 }
 
+func TestWarning(t *testing.T) {
+	stderrBuf := new(bytes.Buffer)
+	stderr = stderrBuf
+
+	warning("test")
+
+	want := "[warn] test\n"
+	if got := trimDatetime(stderrBuf.String()); got != want {
+		t.Errorf("warning(%q): got %q; want %q", "test", got, want)
+	}
+}
+
 func TestVerbose(t *testing.T) {
 	t.Run("Enabled", testVerboseWhenEnabled)
 	t.Run("Disabled", testVerboseWhenDisabled)
@@ -67,13 +79,13 @@ func testVerboseWhenEnabled(t *testing.T) {
 	b := true
 	verboseMode = &b
 
-	stdoutBuf := new(bytes.Buffer)
-	stdout = stdoutBuf
+	stderrBuf := new(bytes.Buffer)
+	stderr = stderrBuf
 
 	verbose("test")
 
-	want := "verbose: test\n"
-	if got := stdoutBuf.String(); got != want {
+	want := "[debug] test\n"
+	if got := trimDatetime(stderrBuf.String()); got != want {
 		t.Errorf("verbose(%q): got %q; want %q", "test", got, want)
 	}
 }
@@ -82,25 +94,25 @@ func testVerboseWhenDisabled(t *testing.T) {
 	var b bool
 	verboseMode = &b
 
-	stdoutBuf := new(bytes.Buffer)
-	stdout = stdoutBuf
+	stderrBuf := new(bytes.Buffer)
+	stderr = stderrBuf
 
 	verbose("test")
 
 	want := ""
-	if got := stdoutBuf.String(); got != want {
+	if got := stderrBuf.String(); got != want {
 		t.Errorf("verbose(%q): got %q; want %q", "test", got, want)
 	}
 }
 
 func TestInfo(t *testing.T) {
-	stdoutBuf := new(bytes.Buffer)
-	stdout = stdoutBuf
+	stderrBuf := new(bytes.Buffer)
+	stderr = stderrBuf
 
 	info("test")
 
-	want := "info: test\n"
-	if got := stdoutBuf.String(); got != want {
+	want := "[info] test\n"
+	if got := trimDatetime(stderrBuf.String()); got != want {
 		t.Errorf("info(%q): got %q; want %q", "test", got, want)
 	}
 }
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricsKey identifies the series of a CRL pushed to a BigIP.
+type metricsKey struct {
+	crl   string
+	bigIP string
+}
+
+// fetchFailureKey identifies the fetch failures of a CRL for a given reason.
+type fetchFailureKey struct {
+	crl    string
+	reason string
+}
+
+// deployedCRL describes the CRL last pushed to a BigIP.
+type deployedCRL struct {
+	nextUpdate time.Time
+	revoked    int
+}
+
+// metrics collects the outcome of the fetches and pushes of the workers and
+// exposes them in the Prometheus text format. A nil *metrics records nothing,
+// so that workers do not have to check whether metrics are enabled.
+type metrics struct {
+	mu sync.Mutex
+
+	// Fetches, by CRL.
+	fetchAttempts map[string]float64
+	fetchFailures map[fetchFailureKey]float64
+	fetchSeconds  map[string]float64
+	fetchBytes    map[string]float64
+
+	// Pushes, by CRL and BigIP.
+	pushAttempts map[metricsKey]float64
+	pushFailures map[metricsKey]float64
+	lastPush     map[metricsKey]time.Time
+	deployed     map[metricsKey]deployedCRL
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		fetchAttempts: make(map[string]float64),
+		fetchFailures: make(map[fetchFailureKey]float64),
+		fetchSeconds:  make(map[string]float64),
+		fetchBytes:    make(map[string]float64),
+		pushAttempts:  make(map[metricsKey]float64),
+		pushFailures:  make(map[metricsKey]float64),
+		lastPush:      make(map[metricsKey]time.Time),
+		deployed:      make(map[metricsKey]deployedCRL),
+	}
+}
+
+// addCRL exposes the fetch counters of the CRL before its first fetch.
+func (m *metrics) addCRL(crl string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fetchAttempts[crl] += 0
+	m.fetchSeconds[crl] += 0
+	m.fetchBytes[crl] += 0
+}
+
+// observeFetch records an attempt to fetch the CRL from a distribution point,
+// which lasted d. fetched is the CRL downloaded on success. A "not modified"
+// response is not a failure.
+func (m *metrics) observeFetch(crl string, d time.Duration, fetched *fetchedCRL, err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fetchAttempts[crl]++
+	m.fetchSeconds[crl] += d.Seconds()
+	switch {
+	case err == errNotModified:
+	case err != nil:
+		m.fetchFailures[fetchFailureKey{crl: crl, reason: fetchFailureReason(err)}]++
+	default:
+		m.fetchBytes[crl] += float64(len(fetched.pem))
+	}
+}
+
+// observePush records an attempt to push a CRL to a BigIP.
+func (m *metrics) observePush(crl, bigIP string, err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := metricsKey{crl: crl, bigIP: bigIP}
+	m.pushAttempts[key]++
+	m.pushFailures[key] += 0
+	if err != nil {
+		m.pushFailures[key]++
+	}
+}
+
+// observeDeployed records the fetched CRL as the one deployed on a BigIP, once
+// it has been pushed and the configuration of the BigIP saved and synced.
+func (m *metrics) observeDeployed(crl, bigIP string, fetched *fetchedCRL) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := metricsKey{crl: crl, bigIP: bigIP}
+	m.lastPush[key] = time.Now()
+	m.deployed[key] = deployedCRL{
+		nextUpdate: fetched.crl.TBSCertList.NextUpdate,
+		revoked:    len(fetched.crl.TBSCertList.RevokedCertificates),
+	}
+}
+
+// writeTo writes the metrics in the Prometheus text format. The time left
+// before the deployed CRLs expire is computed relative to now.
+func (m *metrics) writeTo(w io.Writer, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	crls := make([]string, 0, len(m.fetchAttempts))
+	for crl := range m.fetchAttempts {
+		crls = append(crls, crl)
+	}
+	sort.Strings(crls)
+	failures := make([]fetchFailureKey, 0, len(m.fetchFailures))
+	for key := range m.fetchFailures {
+		failures = append(failures, key)
+	}
+	sort.Slice(failures, func(i, j int) bool {
+		if failures[i].crl != failures[j].crl {
+			return failures[i].crl < failures[j].crl
+		}
+		return failures[i].reason < failures[j].reason
+	})
+	keys := make([]metricsKey, 0, len(m.pushAttempts))
+	for key := range m.pushAttempts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].crl != keys[j].crl {
+			return keys[i].crl < keys[j].crl
+		}
+		return keys[i].bigIP < keys[j].bigIP
+	})
+
+	var buf bytes.Buffer
+	e := expositionWriter{&buf}
+
+	e.header("crl2f5_fetch_attempts_total", "counter", "Number of attempts to fetch the CRL from a distribution point.")
+	for _, crl := range crls {
+		e.sample("crl2f5_fetch_attempts_total", m.fetchAttempts[crl], "crl", crl)
+	}
+	e.header("crl2f5_fetch_failures_total", "counter", "Number of failed attempts to fetch the CRL, by reason.")
+	for _, key := range failures {
+		e.sample("crl2f5_fetch_failures_total", m.fetchFailures[key], "crl", key.crl, "reason", key.reason)
+	}
+	e.header("crl2f5_fetch_duration_seconds", "summary", "Time spent fetching the CRL from a distribution point.")
+	for _, crl := range crls {
+		e.sample("crl2f5_fetch_duration_seconds_sum", m.fetchSeconds[crl], "crl", crl)
+		e.sample("crl2f5_fetch_duration_seconds_count", m.fetchAttempts[crl], "crl", crl)
+	}
+	e.header("crl2f5_fetch_bytes_total", "counter", "Size of the CRLs successfully fetched, PEM encoded.")
+	for _, crl := range crls {
+		e.sample("crl2f5_fetch_bytes_total", m.fetchBytes[crl], "crl", crl)
+	}
+
+	e.header("crl2f5_push_attempts_total", "counter", "Number of attempts to push the CRL to the BigIP.")
+	for _, key := range keys {
+		e.sample("crl2f5_push_attempts_total", m.pushAttempts[key], "crl", key.crl, "bigip", key.bigIP)
+	}
+	e.header("crl2f5_push_failures_total", "counter", "Number of failed attempts to push the CRL to the BigIP.")
+	for _, key := range keys {
+		e.sample("crl2f5_push_failures_total", m.pushFailures[key], "crl", key.crl, "bigip", key.bigIP)
+	}
+	e.header("crl2f5_last_push_success_timestamp_seconds", "gauge", "Time of the last successful push of the CRL to the BigIP.")
+	for _, key := range keys {
+		if t, ok := m.lastPush[key]; ok {
+			e.sample("crl2f5_last_push_success_timestamp_seconds", unixSeconds(t), "crl", key.crl, "bigip", key.bigIP)
+		}
+	}
+	e.header("crl2f5_crl_next_update_timestamp_seconds", "gauge", "NextUpdate of the CRL deployed on the BigIP.")
+	for _, key := range keys {
+		if d, ok := m.deployed[key]; ok && !d.nextUpdate.IsZero() {
+			e.sample("crl2f5_crl_next_update_timestamp_seconds", unixSeconds(d.nextUpdate), "crl", key.crl, "bigip", key.bigIP)
+		}
+	}
+	e.header("crl2f5_crl_expiry_seconds", "gauge", "Time left before the CRL deployed on the BigIP expires, negative once expired.")
+	for _, key := range keys {
+		if d, ok := m.deployed[key]; ok && !d.nextUpdate.IsZero() {
+			e.sample("crl2f5_crl_expiry_seconds", d.nextUpdate.Sub(now).Seconds(), "crl", key.crl, "bigip", key.bigIP)
+		}
+	}
+	e.header("crl2f5_crl_revoked_entries", "gauge", "Number of revoked certificates listed by the CRL deployed on the BigIP.")
+	for _, key := range keys {
+		if d, ok := m.deployed[key]; ok {
+			e.sample("crl2f5_crl_revoked_entries", float64(d.revoked), "crl", key.crl, "bigip", key.bigIP)
+		}
+	}
+
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// ServeHTTP serves the metrics to Prometheus.
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.writeTo(w, time.Now())
+}
+
+// expositionWriter writes metrics in the Prometheus text format.
+type expositionWriter struct {
+	w io.Writer
+}
+
+func (e expositionWriter) header(name, typ, help string) {
+	io.WriteString(e.w, "# HELP "+name+" "+help+"\n# TYPE "+name+" "+typ+"\n")
+}
+
+// sample writes a sample of the metric with the given name, labels being given
+// as name and value pairs.
+func (e expositionWriter) sample(name string, value float64, labels ...string) {
+	var pairs []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+"=\""+labelEscaper.Replace(labels[i+1])+"\"")
+	}
+	io.WriteString(e.w, name+"{"+strings.Join(pairs, ",")+"} "+strconv.FormatFloat(value, 'f', -1, 64)+"\n")
+}
+
+// labelEscaper escapes the characters that cannot appear as is in a label
+// value.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// unixSeconds returns t as a number of seconds since the Unix epoch.
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / 1e9
+}
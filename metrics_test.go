@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/e-XpertSolutions/f5-rest-client/f5"
+)
+
+func TestMetrics_WriteTo(t *testing.T) {
+	thisUpdate := time.Date(2017, 9, 4, 12, 0, 0, 0, time.UTC)
+	pemCRL := newTestPEMCRL(1, thisUpdate)
+	crl, err := parseCRL(pemCRL)
+	if err != nil {
+		t.Fatal("setup: ", err)
+	}
+	fetched := &fetchedCRL{pem: pemCRL, crl: crl}
+
+	m := newMetrics()
+	m.addCRL("test")
+	m.addCRL("other")
+	m.observeFetch("test", 2*time.Second, nil, newFetchError(fetchReasonHTTPStatus, "cannot fetch crl due to http error: 404 Not Found"))
+	m.observeFetch("test", time.Second, nil, errNotModified)
+	m.observeFetch("test", time.Second, fetched, nil)
+	m.observePush("test", "https://bigip", newFetchError(fetchReasonOther, "cannot commit transaction"))
+	m.observePush("test", "https://bigip", nil)
+	m.observeDeployed("test", "https://bigip", fetched)
+
+	var buf bytes.Buffer
+	now := crl.TBSCertList.NextUpdate.Add(-time.Hour)
+	if err := m.writeTo(&buf, now); err != nil {
+		t.Fatalf("metrics.writeTo: unexpected error %q", err.Error())
+	}
+	got := buf.String()
+
+	nextUpdate := strconv.FormatInt(crl.TBSCertList.NextUpdate.Unix(), 10)
+	for _, want := range []string{
+		"# TYPE crl2f5_fetch_attempts_total counter\n" +
+			"crl2f5_fetch_attempts_total{crl=\"other\"} 0\n" +
+			"crl2f5_fetch_attempts_total{crl=\"test\"} 3\n",
+		"crl2f5_fetch_failures_total{crl=\"test\",reason=\"http_status\"} 1\n",
+		"crl2f5_fetch_duration_seconds_sum{crl=\"test\"} 4\n" +
+			"crl2f5_fetch_duration_seconds_count{crl=\"test\"} 3\n",
+		"crl2f5_fetch_bytes_total{crl=\"test\"} " + strconv.Itoa(len(pemCRL)) + "\n",
+		"crl2f5_push_attempts_total{crl=\"test\",bigip=\"https://bigip\"} 2\n",
+		"crl2f5_push_failures_total{crl=\"test\",bigip=\"https://bigip\"} 1\n",
+		"crl2f5_last_push_success_timestamp_seconds{crl=\"test\",bigip=\"https://bigip\"} ",
+		"crl2f5_crl_next_update_timestamp_seconds{crl=\"test\",bigip=\"https://bigip\"} " + nextUpdate + "\n",
+		"crl2f5_crl_expiry_seconds{crl=\"test\",bigip=\"https://bigip\"} 3600\n",
+		"crl2f5_crl_revoked_entries{crl=\"test\",bigip=\"https://bigip\"} 0\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("metrics.writeTo: missing %q in\n%s", want, got)
+		}
+	}
+}
+
+func TestMetrics_Nil(t *testing.T) {
+	var m *metrics
+	m.addCRL("test")
+	m.observeFetch("test", time.Second, nil, errNotModified)
+	m.observePush("test", "https://bigip", errNotModified)
+	m.observeDeployed("test", "https://bigip", nil)
+}
+
+func TestServeMetrics(t *testing.T) {
+	srv := newBigIPServer()
+	tsBigIP := httptest.NewServer(srv)
+	defer tsBigIP.Close()
+
+	f5Client, err := f5.NewBasicClient(tsBigIP.URL, "admin", "admin")
+	if err != nil {
+		t.Fatal("cannot instanciate f5 basic client: ", err)
+	}
+
+	tsDown := newCRLServer(nil)
+	defer tsDown.Close()
+	tsCA := newCRLServer([]byte(crlFile))
+	defer tsCA.Close()
+
+	p := &pool{metrics: newMetrics()}
+	err = p.addWorker(crlConfig{
+		Name:        "test",
+		URLs:        []string{tsDown.URL, tsCA.URL},
+		ProfileName: "clientssl",
+	})
+	if err != nil {
+		t.Fatal("setup: ", err)
+	}
+	p.workers[0].do([]*bigIP{{name: "bigip", client: f5Client}}, discardLogger{})
+
+	ln, err := serveHTTP("127.0.0.1:0", p.metrics, discardLogger{})
+	if err != nil {
+		t.Fatalf("serveHTTP: unexpected error %q", err.Error())
+	}
+	defer ln.Close()
+
+	resp, err := http.Get("http://" + ln.Addr().String() + "/metrics")
+	if err != nil {
+		t.Fatalf("GET /metrics: unexpected error %q", err.Error())
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("GET /metrics: got content type %q; want the prometheus text format", ct)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("GET /metrics: cannot read body: %v", err)
+	}
+	for _, want := range []string{
+		"crl2f5_fetch_attempts_total{crl=\"test\"} 2\n",
+		"crl2f5_fetch_failures_total{crl=\"test\",reason=\"http_status\"} 1\n",
+		"crl2f5_push_attempts_total{crl=\"test\",bigip=\"bigip\"} 1\n",
+		"crl2f5_push_failures_total{crl=\"test\",bigip=\"bigip\"} 0\n",
+		"crl2f5_crl_expiry_seconds{crl=\"test\",bigip=\"bigip\"} ",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("GET /metrics: missing %q in\n%s", want, body)
+		}
+	}
+}
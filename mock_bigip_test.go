@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"path"
+	"sort"
 	"strings"
 
 	"github.com/e-XpertSolutions/f5-rest-client/f5/ltm"
@@ -12,7 +14,7 @@ import (
 // This file implement a mock of a F5 BigIP server that only implements relevant
 // APIs for testing. No tests are implemented here.
 
-const clientSSLProfile = `{"kind":"tm:ltm:profile:client-ssl:client-sslstate","name":"clientssl","fullPath":"clientssl","generation":1708,"selfLink":"https://localhost/mgmt/tm/ltm/profile/client-ssl/clientssl?ver=13.0.0","alertTimeout":"indefinite","allowDynamicRecordSizing":"disabled","allowExpiredCrl":"disabled","allowNonSsl":"disabled","appService":"none","authenticate":"once","authenticateDepth":9,"bypassOnClientCertFail":"disabled","bypassOnHandshakeAlert":"disabled","caFile":"none","cacheSize":262144,"cacheTimeout":3600,"cert":"/Common/default.crt","certReference":{"link":"https://localhost/mgmt/tm/sys/file/ssl-cert/~Common~default.crt?ver=13.0.0"},"certExtensionIncludes":["basic-constraints","subject-alternative-name"],"certLifespan":30,"certLookupByIpaddrPort":"disabled","chain":"none","cipherGroup":"none","ciphers":"DEFAULT","clientCertCa":"none","crlFile":"%s","crlFileReference":{"link":"https://localhost/mgmt/tm/sys/file/ssl-crl/~Common~test4.crl?ver=13.0.0"},"defaultsFrom":"none","description":"none","destinationIpBlacklist":"none","destinationIpWhitelist":"none","forwardProxyBypassDefaultAction":"intercept","genericAlert":"enabled","handshakeTimeout":"10","hostnameBlacklist":"none","hostnameWhitelist":"none","inheritCertkeychain":"false","key":"/Common/default.key","keyReference":{"link":"https://localhost/mgmt/tm/sys/file/ssl-key/~Common~default.key?ver=13.0.0"},"maxActiveHandshakes":"indefinite","maxAggregateRenegotiationPerMinute":"indefinite","maxRenegotiationsPerMinute":5,"maximumRecordSize":16384,"modSslMethods":"disabled","mode":"enabled","notifyCertStatusToVirtualServer":"disabled","ocspStapling":"disabled","tmOptions":["dont-insert-empty-fragments"],"peerCertMode":"ignore","peerNoRenegotiateTimeout":"10","proxyCaCert":"none","proxyCaKey":"none","proxySsl":"disabled","proxySslPassthrough":"disabled","renegotiateMaxRecordDelay":"indefinite","renegotiatePeriod":"indefinite","renegotiateSize":"indefinite","renegotiation":"enabled","retainCertificate":"true","secureRenegotiation":"require","serverName":"none","sessionMirroring":"disabled","sessionTicket":"disabled","sessionTicketTimeout":0,"sniDefault":"false","sniRequire":"false","sourceIpBlacklist":"none","sourceIpWhitelist":"none","sslForwardProxy":"disabled","sslForwardProxyBypass":"disabled","sslSignHash":"any","strictResume":"disabled","uncleanShutdown":"enabled","certKeyChain":[{"name":"default","appService":"none","cert":"/Common/default.crt","certReference":{"link":"https://localhost/mgmt/tm/sys/file/ssl-cert/~Common~default.crt?ver=13.0.0"},"chain":"none","key":"/Common/default.key","keyReference":{"link":"https://localhost/mgmt/tm/sys/file/ssl-key/~Common~default.key?ver=13.0.0"}}]}`
+const clientSSLProfile = `{"kind":"tm:ltm:profile:client-ssl:client-sslstate","name":"clientssl","fullPath":"/Common/clientssl","generation":1708,"selfLink":"https://localhost/mgmt/tm/ltm/profile/client-ssl/clientssl?ver=13.0.0","alertTimeout":"indefinite","allowDynamicRecordSizing":"disabled","allowExpiredCrl":"disabled","allowNonSsl":"disabled","appService":"none","authenticate":"once","authenticateDepth":9,"bypassOnClientCertFail":"disabled","bypassOnHandshakeAlert":"disabled","caFile":"none","cacheSize":262144,"cacheTimeout":3600,"cert":"/Common/default.crt","certReference":{"link":"https://localhost/mgmt/tm/sys/file/ssl-cert/~Common~default.crt?ver=13.0.0"},"certExtensionIncludes":["basic-constraints","subject-alternative-name"],"certLifespan":30,"certLookupByIpaddrPort":"disabled","chain":"none","cipherGroup":"none","ciphers":"DEFAULT","clientCertCa":"none","crlFile":"%s","crlFileReference":{"link":"https://localhost/mgmt/tm/sys/file/ssl-crl/~Common~test4.crl?ver=13.0.0"},"defaultsFrom":"none","description":"none","destinationIpBlacklist":"none","destinationIpWhitelist":"none","forwardProxyBypassDefaultAction":"intercept","genericAlert":"enabled","handshakeTimeout":"10","hostnameBlacklist":"none","hostnameWhitelist":"none","inheritCertkeychain":"false","key":"/Common/default.key","keyReference":{"link":"https://localhost/mgmt/tm/sys/file/ssl-key/~Common~default.key?ver=13.0.0"},"maxActiveHandshakes":"indefinite","maxAggregateRenegotiationPerMinute":"indefinite","maxRenegotiationsPerMinute":5,"maximumRecordSize":16384,"modSslMethods":"disabled","mode":"enabled","notifyCertStatusToVirtualServer":"disabled","ocspStapling":"disabled","tmOptions":["dont-insert-empty-fragments"],"peerCertMode":"ignore","peerNoRenegotiateTimeout":"10","proxyCaCert":"none","proxyCaKey":"none","proxySsl":"disabled","proxySslPassthrough":"disabled","renegotiateMaxRecordDelay":"indefinite","renegotiatePeriod":"indefinite","renegotiateSize":"indefinite","renegotiation":"enabled","retainCertificate":"true","secureRenegotiation":"require","serverName":"none","sessionMirroring":"disabled","sessionTicket":"disabled","sessionTicketTimeout":0,"sniDefault":"false","sniRequire":"false","sourceIpBlacklist":"none","sourceIpWhitelist":"none","sslForwardProxy":"disabled","sslForwardProxyBypass":"disabled","sslSignHash":"any","strictResume":"disabled","uncleanShutdown":"enabled","certKeyChain":[{"name":"default","appService":"none","cert":"/Common/default.crt","certReference":{"link":"https://localhost/mgmt/tm/sys/file/ssl-cert/~Common~default.crt?ver=13.0.0"},"chain":"none","key":"/Common/default.key","keyReference":{"link":"https://localhost/mgmt/tm/sys/file/ssl-key/~Common~default.key?ver=13.0.0"}}]}`
 
 const editProfileResp = `{"kind":"tm:sys:file:ssl-crl:ssl-crlstate","name":"test.crl","fullPath":"test.crl","generation":3488,"selfLink":"https://localhost/mgmt/tm/sys/file/ssl-crl/test.crl?ver=13.0.0","checksum":"SHA1:930:eb57c456aef899566a1a8166b1b8ec0390ae00f6","createTime":"2017-08-25T13:03:04Z","createdBy":"admin","lastUpdateTime":"2017-08-28T15:33:19Z","mode":33188,"revision":7,"size":930,"sourcePath":"file:/var/config/rest/downloads/test.crl","updatedBy":"admin"}`
 
@@ -38,20 +40,86 @@ type bigIPServer struct {
 	crlFile string
 	mux     *http.ServeMux
 
+	// crlFiles lists the full path of the ssl-crl files stored on the server.
+	crlFiles []string
+
+	// crlContents maps the full path of ssl-crl files to their PEM encoded
+	// content.
+	crlContents map[string]string
+
+	// downloads maps the name of the files of /var/config/rest/downloads to
+	// their content.
+	downloads map[string]string
+
+	// failTransactions is the number of upcoming requests for starting a
+	// transaction that must fail.
+	failTransactions int
+
+	// otherProfiles maps the name of the client-ssl profiles defined on the
+	// server, in addition to "clientssl", to their crl file. Profiles outside
+	// of the /Common partition are named by their full path.
+	otherProfiles map[string]string
+
+	// profileCAFiles maps the name of the other client-ssl profiles to their
+	// ca file, if any.
+	profileCAFiles map[string]string
+
+	// serverProfiles maps the name of the server-ssl profiles defined on the
+	// server to their crl file, as otherProfiles.
+	serverProfiles map[string]string
+
+	// certFiles maps the full path of the ssl-cert files stored on the
+	// server to their PEM encoded content.
+	certFiles map[string]string
+
+	// syncCommands lists the arguments of the config-sync commands run on
+	// the server.
+	syncCommands []string
+
+	// syncStatuses lists the upcoming sync statuses of the server. The last
+	// one is kept once the others have been returned. The server is in sync
+	// when empty.
+	syncStatuses []string
+
+	// saves is the number of times the configuration has been saved.
+	saves int
+
+	// failoverState is the failover state of the server, "ACTIVE" when
+	// empty.
+	failoverState string
+
+	// deviceGroups maps the full path of the device groups defined on the
+	// server to their type.
+	deviceGroups map[string]string
+
 	callsToClientSSLGet int
+	callsToClientSSLPut int
 }
 
 func newBigIPServer() *bigIPServer {
 	srv := &bigIPServer{
 		mux: http.NewServeMux(),
 	}
-	srv.mux.HandleFunc("/mgmt/tm/ltm/profile/client-ssl/clientssl", srv.handleProfileClientSSL)
+	srv.mux.HandleFunc("/mgmt/tm/ltm/profile/client-ssl", srv.handleProfileClientSSLList)
+	srv.mux.HandleFunc("/mgmt/tm/ltm/profile/client-ssl/~Common~clientssl", srv.handleProfileClientSSL)
+	srv.mux.HandleFunc("/mgmt/tm/ltm/profile/client-ssl/", srv.handleOtherProfileClientSSL)
+	srv.mux.HandleFunc("/mgmt/tm/ltm/profile/server-ssl", srv.handleProfileServerSSLList)
+	srv.mux.HandleFunc("/mgmt/tm/ltm/profile/server-ssl/", srv.handleProfileServerSSL)
 	srv.mux.HandleFunc("/mgmt/tm/transaction", srv.handleTransaction)
 	srv.mux.HandleFunc("/mgmt/tm/transaction/", srv.handleTransaction)
 	srv.mux.HandleFunc("/mgmt/shared/file-transfer/uploads", srv.handleFileTransferUploads)
 	srv.mux.HandleFunc("/mgmt/shared/file-transfer/uploads/", srv.handleFileTransferUploads)
 	srv.mux.HandleFunc("/mgmt/tm/sys/file/ssl-crl", srv.handleFileSSLCRL)
 	srv.mux.HandleFunc("/mgmt/tm/sys/file/ssl-crl/", srv.handleFileSSLCRL)
+	srv.mux.HandleFunc("/mgmt/tm/sys/file/ssl-cert/", srv.handleFileSSLCert)
+	srv.mux.HandleFunc("/mgmt/tm/util/unix-cp", srv.handleUtilUnixCp)
+	srv.mux.HandleFunc("/mgmt/tm/util/unix-rm", srv.handleUtilUnixRm)
+	srv.mux.HandleFunc("/mgmt/shared/file-transfer/downloads/", srv.handleFileTransferDownloads)
+	srv.mux.HandleFunc("/mgmt/tm/sys/config", srv.handleSysConfig)
+	srv.mux.HandleFunc("/mgmt/tm/cm", srv.handleCM)
+	srv.mux.HandleFunc("/mgmt/tm/cm/sync-status", srv.handleCMSyncStatus)
+	srv.mux.HandleFunc("/mgmt/tm/cm/failover-status", srv.handleCMFailoverStatus)
+	srv.mux.HandleFunc("/mgmt/tm/cm/device-group/", srv.handleCMDeviceGroup)
 	return srv
 }
 
@@ -60,6 +128,141 @@ func (srv *bigIPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	srv.mux.ServeHTTP(w, r)
 }
 
+func (srv *bigIPServer) handleProfileClientSSLList(w http.ResponseWriter, r *http.Request) {
+	if srv.Disable == "client-ssl_list" {
+		http.Error(w, "disabled", http.StatusNotFound)
+		return
+	}
+	switch method := r.Method; method {
+	case "GET":
+		items := []string{fmt.Sprintf(clientSSLProfile, srv.crlFile)}
+		for _, name := range srv.otherProfileNames() {
+			items = append(items, srv.otherProfile(name))
+		}
+		w.Write([]byte(`{"kind":"tm:ltm:profile:client-ssl:client-sslcollectionstate","items":[` + strings.Join(items, ",") + `]}`))
+	default:
+		http.Error(w, fmt.Sprintf("unsupported method %q for %q", method, r.URL.Path), http.StatusBadRequest)
+	}
+}
+
+func (srv *bigIPServer) otherProfileNames() []string {
+	var names []string
+	for name := range srv.otherProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// mockFullPath returns the full path of an object named as in the maps of the
+// mock server.
+func mockFullPath(name string) string {
+	if strings.HasPrefix(name, "/") {
+		return name
+	}
+	return "/Common/" + name
+}
+
+// lookupObject returns the key in objects of the object referenced by the last
+// element of the request path, e.g. "~Common~clientssl".
+func lookupObject(urlPath string, objects map[string]string) (string, bool) {
+	fullPath := strings.Replace(urlPath[strings.LastIndex(urlPath, "/")+1:], "~", "/", -1)
+	for name := range objects {
+		if mockFullPath(name) == fullPath {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func (srv *bigIPServer) otherProfile(name string) string {
+	caFile := srv.profileCAFiles[name]
+	if caFile == "" {
+		caFile = "none"
+	}
+	fullPath := mockFullPath(name)
+	return fmt.Sprintf(`{"kind":"tm:ltm:profile:client-ssl:client-sslstate","name":%q,"fullPath":%q,"caFile":%q,"clientCertCa":"none","crlFile":%q}`,
+		path.Base(fullPath), fullPath, caFile, srv.otherProfiles[name])
+}
+
+func (srv *bigIPServer) handleOtherProfileClientSSL(w http.ResponseWriter, r *http.Request) {
+	name, ok := lookupObject(r.URL.Path, srv.otherProfiles)
+	if !ok {
+		http.Error(w, `{"code":404,"message":"profile not found"}`, http.StatusNotFound)
+		return
+	}
+	switch method := r.Method; method {
+	case "GET":
+		w.Write([]byte(srv.otherProfile(name)))
+	case "PUT":
+		var cfg ltm.ProfileClientSSLConfig
+		dec := json.NewDecoder(r.Body)
+		if err := dec.Decode(&cfg); err != nil {
+			http.Error(w, "malformed request json data", http.StatusBadRequest)
+			return
+		}
+		srv.otherProfiles[name] = cfg.CRLFile
+		w.Write([]byte(editProfileResp))
+	default:
+		http.Error(w, fmt.Sprintf("unsupported method %q for %q", method, r.URL.Path), http.StatusBadRequest)
+	}
+}
+
+func (srv *bigIPServer) serverProfile(name string) string {
+	fullPath := mockFullPath(name)
+	return fmt.Sprintf(`{"kind":"tm:ltm:profile:server-ssl:server-sslstate","name":%q,"fullPath":%q,"caFile":"none","crlFile":%q}`,
+		path.Base(fullPath), fullPath, srv.serverProfiles[name])
+}
+
+func (srv *bigIPServer) handleProfileServerSSLList(w http.ResponseWriter, r *http.Request) {
+	if srv.Disable == "server-ssl_list" {
+		http.Error(w, "disabled", http.StatusNotFound)
+		return
+	}
+	switch method := r.Method; method {
+	case "GET":
+		var names []string
+		for name := range srv.serverProfiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var items []string
+		for _, name := range names {
+			items = append(items, srv.serverProfile(name))
+		}
+		w.Write([]byte(`{"kind":"tm:ltm:profile:server-ssl:server-sslcollectionstate","items":[` + strings.Join(items, ",") + `]}`))
+	default:
+		http.Error(w, fmt.Sprintf("unsupported method %q for %q", method, r.URL.Path), http.StatusBadRequest)
+	}
+}
+
+func (srv *bigIPServer) handleProfileServerSSL(w http.ResponseWriter, r *http.Request) {
+	name, ok := lookupObject(r.URL.Path, srv.serverProfiles)
+	if !ok {
+		http.Error(w, `{"code":404,"message":"profile not found"}`, http.StatusNotFound)
+		return
+	}
+	switch method := r.Method; method {
+	case "GET":
+		w.Write([]byte(srv.serverProfile(name)))
+	case "PUT":
+		if srv.Disable == "server-ssl_put" {
+			http.Error(w, "disabled", http.StatusNotFound)
+			return
+		}
+		var cfg ltm.ProfileServerSSLConfig
+		dec := json.NewDecoder(r.Body)
+		if err := dec.Decode(&cfg); err != nil {
+			http.Error(w, "malformed request json data", http.StatusBadRequest)
+			return
+		}
+		srv.serverProfiles[name] = cfg.CRLFile
+		w.Write([]byte(editProfileResp))
+	default:
+		http.Error(w, fmt.Sprintf("unsupported method %q for %q", method, r.URL.Path), http.StatusBadRequest)
+	}
+}
+
 func (srv *bigIPServer) handleProfileClientSSL(w http.ResponseWriter, r *http.Request) {
 	switch method := r.Method; method {
 	case "GET":
@@ -78,6 +281,7 @@ func (srv *bigIPServer) handleProfileClientSSL(w http.ResponseWriter, r *http.Re
 			w.Write([]byte(fmt.Sprintf(clientSSLProfile, srv.crlFile)))
 		}
 	case "PUT":
+		srv.callsToClientSSLPut++
 		if srv.Disable == "client-ssl_put" {
 			http.Error(w, "disabled", http.StatusNotFound)
 			return
@@ -88,6 +292,11 @@ func (srv *bigIPServer) handleProfileClientSSL(w http.ResponseWriter, r *http.Re
 			http.Error(w, "malformed request json data", http.StatusBadRequest)
 			return
 		}
+		// The first modification is accepted but not applied.
+		if srv.Disable == "client-ssl_put_ignored_once" && srv.callsToClientSSLPut == 1 {
+			w.Write([]byte(editProfileResp))
+			return
+		}
 		srv.crlFile = cfg.CRLFile
 		w.Write([]byte(editProfileResp))
 	default:
@@ -100,6 +309,11 @@ func (srv *bigIPServer) handleTransaction(w http.ResponseWriter, r *http.Request
 		http.Error(w, "disabled", http.StatusNotFound)
 		return
 	}
+	if srv.failTransactions > 0 && r.Method == "POST" {
+		srv.failTransactions--
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
 	switch path := strings.TrimSuffix(r.URL.Path, "/"); path {
 	case "/mgmt/tm/transaction":
 		switch method := r.Method; method {
@@ -138,6 +352,39 @@ func (srv *bigIPServer) handleFileSSLCRL(w http.ResponseWriter, r *http.Request)
 	}
 	var filename string
 	switch method := r.Method; method {
+	case "GET":
+		if name := strings.TrimPrefix(r.URL.Path, "/mgmt/tm/sys/file/ssl-crl/"); name != r.URL.Path {
+			fullPath := strings.Replace(name, "~", "/", -1)
+			if _, ok := srv.crlContents[fullPath]; !ok {
+				http.Error(w, `{"code":404,"message":"file not found"}`, http.StatusNotFound)
+				return
+			}
+			fmt.Fprintf(w, `{"kind":"tm:sys:file:ssl-crl:ssl-crlstate","name":%q,"fullPath":%q,"cachePath":%q}`,
+				path.Base(fullPath), fullPath, crlCachePath(fullPath))
+			return
+		}
+		var items []string
+		for _, fullPath := range srv.crlFiles {
+			items = append(items, fmt.Sprintf(`{"kind":"tm:sys:file:ssl-crl:ssl-crlstate","name":%q,"partition":%q,"fullPath":%q}`,
+				path.Base(fullPath), strings.Trim(path.Dir(fullPath), "/"), fullPath))
+		}
+		w.Write([]byte(`{"kind":"tm:sys:file:ssl-crl:ssl-crlcollectionstate","items":[` + strings.Join(items, ",") + `]}`))
+		return
+	case "DELETE":
+		if srv.Disable == "ssl-crl_delete" {
+			http.Error(w, "disabled", http.StatusNotFound)
+			return
+		}
+		filename = strings.Replace(r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:], "~", "/", -1)
+		for i, fullPath := range srv.crlFiles {
+			if fullPath == filename {
+				srv.crlFiles = append(srv.crlFiles[:i], srv.crlFiles[i+1:]...)
+				w.Write([]byte(`{}`))
+				return
+			}
+		}
+		http.Error(w, `{"code":404,"message":"file not found"}`, http.StatusNotFound)
+		return
 	case "POST":
 		data := make(map[string]string)
 		dec := json.NewDecoder(r.Body)
@@ -151,6 +398,14 @@ func (srv *bigIPServer) handleFileSSLCRL(w http.ResponseWriter, r *http.Request)
 			http.Error(w, "missing name in request data", http.StatusBadRequest)
 			return
 		}
+		partition := data["partition"]
+		if partition == "" {
+			partition = "Common"
+		}
+		// The file is accepted but not stored.
+		if srv.Disable != "ssl-crl_lost" {
+			srv.crlFiles = append(srv.crlFiles, "/"+partition+"/"+filename+".crl")
+		}
 	case "PUT": // PUT?
 		filename = r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
 	default:
@@ -159,3 +414,186 @@ func (srv *bigIPServer) handleFileSSLCRL(w http.ResponseWriter, r *http.Request)
 	}
 	w.Write([]byte(fmt.Sprintf(`{"remainingByteCount":0,"usedChunks":{"0":930},"totalByteCount":930,"localFilePath":"/var/config/rest/downloads/%s","temporaryFilePath":"/var/config/rest/downloads/tmp/%s","generation":0,"lastUpdateMicros":1503997621775731}`, filename, filename)))
 }
+
+func (srv *bigIPServer) handleFileSSLCert(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, fmt.Sprintf("unsupported method %q for %q", r.Method, r.URL.Path), http.StatusBadRequest)
+		return
+	}
+	fullPath := strings.Replace(strings.TrimPrefix(r.URL.Path, "/mgmt/tm/sys/file/ssl-cert/"), "~", "/", -1)
+	if _, ok := srv.certFiles[fullPath]; !ok {
+		http.Error(w, `{"code":404,"message":"file not found"}`, http.StatusNotFound)
+		return
+	}
+	name := fullPath[strings.LastIndex(fullPath, "/")+1:]
+	fmt.Fprintf(w, `{"kind":"tm:sys:file:ssl-cert:ssl-certstate","name":%q,"fullPath":%q,"cachePath":%q}`, name, fullPath, certCachePath(fullPath))
+}
+
+// certCachePath returns the path of an ssl-cert file in the filestore of the
+// server, e.g. "/config/filestore/files_d/Common_d/certificate_d/:Common:ca.crt_1".
+func certCachePath(fullPath string) string {
+	return "/config/filestore/files_d/Common_d/certificate_d/" + strings.Replace(fullPath, "/", ":", -1) + "_1"
+}
+
+// crlCachePath returns the path of an ssl-crl file in the filestore of the
+// server.
+func crlCachePath(fullPath string) string {
+	return "/config/filestore/files_d/Common_d/certificate_revocation_list_d/" + strings.Replace(fullPath, "/", ":", -1) + "_1"
+}
+
+// filestore returns the content of the ssl-cert and ssl-crl files of the
+// server by path in the filestore.
+func (srv *bigIPServer) filestore() map[string]string {
+	files := make(map[string]string)
+	for fullPath, content := range srv.certFiles {
+		files[certCachePath(fullPath)] = content
+	}
+	for fullPath, content := range srv.crlContents {
+		files[crlCachePath(fullPath)] = content
+	}
+	return files
+}
+
+// handleUtilUnixCp only supports copying files of the filestore to
+// /var/config/rest/downloads.
+func (srv *bigIPServer) handleUtilUnixCp(w http.ResponseWriter, r *http.Request) {
+	if srv.Disable == "unix-cp" {
+		http.Error(w, `{"code":401,"message":"Authorization failed: user=admin resource=/mgmt/tm/util/unix-cp"}`, http.StatusUnauthorized)
+		return
+	}
+	var data map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil || r.Method != "POST" || data["command"] != "run" {
+		http.Error(w, "unsupported request", http.StatusBadRequest)
+		return
+	}
+	args := strings.Fields(data["utilCmdArgs"])
+	if len(args) != 2 || !strings.HasPrefix(args[1], "/var/config/rest/downloads/") {
+		http.Error(w, "unsupported command "+data["utilCmdArgs"], http.StatusBadRequest)
+		return
+	}
+	content, ok := srv.filestore()[args[0]]
+	if !ok {
+		fmt.Fprintf(w, `{"kind":"tm:util:unix-cp:runstate","command":"run","utilCmdArgs":%q,"commandResult":%q}`,
+			data["utilCmdArgs"], "cp: cannot stat '"+args[0]+"': No such file or directory\n")
+		return
+	}
+	if srv.downloads == nil {
+		srv.downloads = make(map[string]string)
+	}
+	srv.downloads[path.Base(args[1])] = content
+	fmt.Fprintf(w, `{"kind":"tm:util:unix-cp:runstate","command":"run","utilCmdArgs":%q}`, data["utilCmdArgs"])
+}
+
+// handleUtilUnixRm only supports removing files of
+// /var/config/rest/downloads.
+func (srv *bigIPServer) handleUtilUnixRm(w http.ResponseWriter, r *http.Request) {
+	var data map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil || r.Method != "POST" || data["command"] != "run" {
+		http.Error(w, "unsupported request", http.StatusBadRequest)
+		return
+	}
+	delete(srv.downloads, path.Base(data["utilCmdArgs"]))
+	fmt.Fprintf(w, `{"kind":"tm:util:unix-rm:runstate","command":"run","utilCmdArgs":%q}`, data["utilCmdArgs"])
+}
+
+// handleFileTransferDownloads serves the files of /var/config/rest/downloads
+// in chunks of at most 4 bytes, as requested by the Content-Range header.
+func (srv *bigIPServer) handleFileTransferDownloads(w http.ResponseWriter, r *http.Request) {
+	content, ok := srv.downloads[strings.TrimPrefix(r.URL.Path, "/mgmt/shared/file-transfer/downloads/")]
+	if r.Method != "GET" || !ok {
+		http.Error(w, `{"code":404,"message":"file not found"}`, http.StatusNotFound)
+		return
+	}
+	var start, end, size int
+	if _, err := fmt.Sscanf(r.Header.Get("Content-Range"), "%d-%d/%d", &start, &end, &size); err != nil || start > end {
+		http.Error(w, `{"code":400,"message":"invalid content range"}`, http.StatusBadRequest)
+		return
+	}
+	if end-start >= 4 {
+		end = start + 3
+	}
+	if end >= len(content) {
+		end = len(content) - 1
+	}
+	w.Header().Set("Content-Range", fmt.Sprintf("%d-%d/%d", start, end, len(content)))
+	if start < len(content) {
+		w.Write([]byte(content[start : end+1]))
+	}
+}
+
+func (srv *bigIPServer) handleSysConfig(w http.ResponseWriter, r *http.Request) {
+	if srv.Disable == "save_config" {
+		http.Error(w, "disabled", http.StatusNotFound)
+		return
+	}
+	data := make(map[string]string)
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil || r.Method != "POST" || data["command"] != "save" {
+		http.Error(w, fmt.Sprintf("unsupported request %s %q", r.Method, r.URL.Path), http.StatusBadRequest)
+		return
+	}
+	srv.saves++
+	w.Write([]byte(`{"kind":"tm:sys:config:savestate","command":"save"}`))
+}
+
+func (srv *bigIPServer) handleCM(w http.ResponseWriter, r *http.Request) {
+	if srv.Disable == "cm_sync" {
+		http.Error(w, "disabled", http.StatusNotFound)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, fmt.Sprintf("unsupported method %q for %q", r.Method, r.URL.Path), http.StatusBadRequest)
+		return
+	}
+	data := make(map[string]string)
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "malformed request data: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if data["command"] != "run" {
+		http.Error(w, fmt.Sprintf("unsupported command %q", data["command"]), http.StatusBadRequest)
+		return
+	}
+	srv.syncCommands = append(srv.syncCommands, data["utilCmdArgs"])
+	w.Write([]byte(`{"kind":"tm:cm:runstate","command":"run"}`))
+}
+
+func (srv *bigIPServer) handleCMSyncStatus(w http.ResponseWriter, r *http.Request) {
+	status := "In Sync"
+	if len(srv.syncStatuses) > 0 {
+		status = srv.syncStatuses[0]
+		if len(srv.syncStatuses) > 1 {
+			srv.syncStatuses = srv.syncStatuses[1:]
+		}
+	}
+	// Another device group has pending changes, which must not be taken for
+	// the status of the synced one.
+	fmt.Fprintf(w, `{"kind":"tm:cm:sync-status:sync-statusstats","entries":{"https://localhost/mgmt/tm/cm/sync-status/0":{"nestedStats":{"entries":{`+
+		`"color":{"description":"blue"},"mode":{"description":"high-availability"},"status":{"description":"Changes Pending"},"summary":{"description":"There is a possible change conflict"},`+
+		`"https://localhost/mgmt/tm/cm/syncStatus/0/details":{"nestedStats":{"entries":{`+
+		`"https://localhost/mgmt/tm/cm/syncStatus/0/details/0":{"nestedStats":{"entries":{"details":{"description":"bigip-b: connected"}}}},`+
+		`"https://localhost/mgmt/tm/cm/syncStatus/0/details/1":{"nestedStats":{"entries":{"details":{"description":"datasync-global-dg (Changes Pending): There is a possible change conflict"}}}},`+
+		`"https://localhost/mgmt/tm/cm/syncStatus/0/details/2":{"nestedStats":{"entries":{"details":{"description":"failover (%s): mock summary"}}}}`+
+		`}}}}}}}}`, status)
+}
+
+func (srv *bigIPServer) handleCMFailoverStatus(w http.ResponseWriter, r *http.Request) {
+	if srv.Disable == "failover-status" {
+		http.Error(w, "disabled", http.StatusNotFound)
+		return
+	}
+	state := srv.failoverState
+	if state == "" {
+		state = "ACTIVE"
+	}
+	fmt.Fprintf(w, `{"kind":"tm:cm:failover-status:failover-statusstats","entries":{"https://localhost/mgmt/tm/cm/failover-status/0":{"nestedStats":{"entries":{"color":{"description":"green"},"status":{"description":%q},"summary":{"description":"1/1 active"}}}}}}`, state)
+}
+
+func (srv *bigIPServer) handleCMDeviceGroup(w http.ResponseWriter, r *http.Request) {
+	name, ok := lookupObject(r.URL.Path, srv.deviceGroups)
+	if !ok {
+		http.Error(w, `{"code":404,"message":"device group not found"}`, http.StatusNotFound)
+		return
+	}
+	fmt.Fprintf(w, `{"kind":"tm:cm:device-group:device-groupstate","name":%q,"fullPath":%q,"type":%q}`,
+		path.Base(mockFullPath(name)), mockFullPath(name), srv.deviceGroups[name])
+}
@@ -10,16 +10,36 @@ import (
 // discardLogger does not write any log.
 type discardLogger struct{}
 
-func (dl discardLogger) Error(...interface{})           {}
-func (dl discardLogger) Errorf(string, ...interface{})  {}
-func (dl discardLogger) Notice(...interface{})          {}
-func (dl discardLogger) Noticef(string, ...interface{}) {}
+func (dl discardLogger) Debug(...interface{})            {}
+func (dl discardLogger) Info(...interface{})             {}
+func (dl discardLogger) Warn(...interface{})             {}
+func (dl discardLogger) Error(...interface{})            {}
+func (dl discardLogger) Errorf(string, ...interface{})   {}
+func (dl discardLogger) Notice(...interface{})           {}
+func (dl discardLogger) Noticef(string, ...interface{})  {}
+func (dl discardLogger) With(string, interface{}) logger { return dl }
 
 // bufferedLogger does not write any log but keep them into a buffer instead.
+// Debug events are dropped, the other events below the error level are kept in
+// the notice buffer, and fields are ignored.
 type bufferedLogger struct {
 	errBuf, noticeBuf string
 }
 
+func (bl *bufferedLogger) Debug(v ...interface{}) {}
+
+func (bl *bufferedLogger) Info(v ...interface{}) {
+	bl.noticeBuf = fmt.Sprint(v...)
+}
+
+func (bl *bufferedLogger) Warn(v ...interface{}) {
+	bl.noticeBuf = fmt.Sprint(v...)
+}
+
+func (bl *bufferedLogger) With(string, interface{}) logger {
+	return bl
+}
+
 func (bl *bufferedLogger) Error(v ...interface{}) {
 	bl.errBuf = fmt.Sprint(v...)
 }
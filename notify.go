@@ -0,0 +1,487 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Events a webhook can be notified of.
+const (
+	notifyFetchFailure = "fetch_failure"
+	notifyPushFailure  = "push_failure"
+	notifyExpiring     = "expiring"
+	notifyRecovery     = "recovery"
+)
+
+var notifyEvents = []string{notifyFetchFailure, notifyPushFailure, notifyExpiring, notifyRecovery}
+
+// Default settings of a webhook.
+const (
+	defaultNotifyMethod          = "POST"
+	defaultNotifyTimeout         = 10 * time.Second
+	defaultNotifyRepeatInterval  = time.Hour
+	defaultNotifyExpiryThreshold = 24 * time.Hour
+)
+
+// notifyQueueSize is the maximum number of events waiting to be sent to a
+// webhook. Events are dropped when the queue is full.
+const notifyQueueSize = 100
+
+func isNotifyEvent(name string) bool {
+	for _, e := range notifyEvents {
+		if e == name {
+			return true
+		}
+	}
+	return false
+}
+
+// notifyEvent describes what happened to a CRL. It is the data given to the
+// template of the webhooks.
+type notifyEvent struct {
+	// Event is one of fetch_failure, push_failure, expiring or recovery.
+	Event string
+	CRL   string
+
+	// BigIP is the BigIP the event relates to, empty for fetch events.
+	BigIP string
+
+	Message string
+	Error   string
+
+	// Recovered is the event a recovery ends, i.e. fetch_failure or
+	// push_failure.
+	Recovered string
+
+	Time time.Time
+
+	// Since is the time of the first of the failures, or of the first
+	// notification about the expiring CRL.
+	Since time.Time
+
+	// NextUpdate is the expiration time of the deployed CRL, only set for
+	// the expiring events.
+	NextUpdate time.Time
+
+	// Suppressed is the number of occurrences of the event that have not
+	// been notified since the last notification.
+	Suppressed int
+}
+
+// webhookPayload is the JSON document sent to the webhooks without template.
+type webhookPayload struct {
+	Event      string `json:"event"`
+	CRL        string `json:"crl"`
+	BigIP      string `json:"bigip,omitempty"`
+	Message    string `json:"message"`
+	Error      string `json:"error,omitempty"`
+	Recovered  string `json:"recovered,omitempty"`
+	Time       string `json:"time"`
+	Since      string `json:"since,omitempty"`
+	NextUpdate string `json:"next_update,omitempty"`
+	Suppressed int    `json:"suppressed,omitempty"`
+}
+
+func formatOptionalTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// payload returns the JSON document describing the event.
+func (e notifyEvent) payload() ([]byte, error) {
+	return json.Marshal(webhookPayload{
+		Event:      e.Event,
+		CRL:        e.CRL,
+		BigIP:      e.BigIP,
+		Message:    e.Message,
+		Error:      e.Error,
+		Recovered:  e.Recovered,
+		Time:       e.Time.Format(time.RFC3339),
+		Since:      formatOptionalTime(e.Since),
+		NextUpdate: formatOptionalTime(e.NextUpdate),
+		Suppressed: e.Suppressed,
+	})
+}
+
+// parseNotifyTemplate parses the JSON template of a webhook. Besides the
+// builtin functions, the template can use "json" to encode a value, e.g.
+// `{"text": {{json .Message}}}`.
+func parseNotifyTemplate(text string) (*template.Template, error) {
+	return template.New("notify").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(text)
+}
+
+// renderNotifyTemplate executes the template for the event and makes sure the
+// result is a JSON document.
+func renderNotifyTemplate(tmpl *template.Template, e notifyEvent) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, e); err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err := json.Unmarshal(buf.Bytes(), &v); err != nil {
+		return nil, errors.New("template does not produce valid JSON: " + err.Error())
+	}
+	return buf.Bytes(), nil
+}
+
+// alertKey identifies the failures of a CRL, or its expiration, on which a
+// webhook is notified once per repeat interval.
+type alertKey struct {
+	event string
+	crl   string
+	bigIP string
+}
+
+// alertState tracks an ongoing failure or expiration.
+type alertState struct {
+	since      time.Time
+	lastSent   time.Time
+	suppressed int
+}
+
+// webhook sends the events it subscribed to over HTTP. An ongoing failure is
+// notified at most once per repeat interval, the occurrences in between being
+// counted as suppressed, and its recovery is notified once it has been.
+//
+// Events are sent in order by a goroutine of their own, so that a slow webhook
+// does not delay the workers.
+type webhook struct {
+	name            string
+	url             string
+	method          string
+	headers         map[string]string
+	events          map[string]bool
+	tmpl            *template.Template
+	client          *http.Client
+	repeatInterval  time.Duration
+	expiryThreshold time.Duration
+
+	mu     sync.Mutex
+	alerts map[alertKey]*alertState
+
+	queue   chan delivery
+	pending sync.WaitGroup
+}
+
+// delivery is an event waiting to be sent to a webhook.
+type delivery struct {
+	key alertKey // zero for recoveries
+	e   notifyEvent
+	l   logger
+
+	// lastSent is the time the alert had last been sent before this event,
+	// restored if the event cannot be sent.
+	lastSent time.Time
+}
+
+func newWebhook(cfg notifyConfig) (*webhook, error) {
+	h := &webhook{
+		name:            cfg.Name,
+		url:             cfg.URL,
+		method:          cfg.Method,
+		headers:         cfg.Headers,
+		events:          make(map[string]bool),
+		client:          &http.Client{Timeout: cfg.Timeout.Duration},
+		repeatInterval:  cfg.RepeatInterval.Duration,
+		expiryThreshold: cfg.ExpiryThreshold.Duration,
+		alerts:          make(map[alertKey]*alertState),
+		queue:           make(chan delivery, notifyQueueSize),
+	}
+	if h.name == "" {
+		h.name = h.url
+	}
+	if h.url == "" {
+		return nil, errors.New("notify \"" + h.name + "\": no url provided")
+	}
+	if h.method == "" {
+		h.method = defaultNotifyMethod
+	}
+	if h.client.Timeout == 0 {
+		h.client.Timeout = defaultNotifyTimeout
+	}
+	if h.repeatInterval == 0 {
+		h.repeatInterval = defaultNotifyRepeatInterval
+	}
+	if h.expiryThreshold == 0 {
+		h.expiryThreshold = defaultNotifyExpiryThreshold
+	}
+	events := cfg.Events
+	if len(events) == 0 {
+		events = notifyEvents
+	}
+	for _, e := range events {
+		if !isNotifyEvent(e) {
+			return nil, errors.New("notify \"" + h.name + "\": unsupported event \"" + e + "\"")
+		}
+		h.events[e] = true
+	}
+	if cfg.Template != "" {
+		tmpl, err := parseNotifyTemplate(cfg.Template)
+		if err != nil {
+			return nil, errors.New("notify \"" + h.name + "\": invalid template: " + err.Error())
+		}
+		h.tmpl = tmpl
+	}
+	go h.run()
+	return h, nil
+}
+
+// alert records an occurrence of a failure or expiration and returns the
+// event to send, if any, along with the time the alert had last been sent. The
+// alert is recorded as sent right away so that the occurrences that follow are
+// suppressed while the event is being sent, see failed.
+func (h *webhook) alert(key alertKey, e notifyEvent) (notifyEvent, time.Time, bool) {
+	if !h.events[key.event] {
+		return e, time.Time{}, false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	st, ok := h.alerts[key]
+	if !ok {
+		st = &alertState{since: e.Time}
+		h.alerts[key] = st
+	}
+	if !st.lastSent.IsZero() && e.Time.Sub(st.lastSent) < h.repeatInterval {
+		st.suppressed++
+		return e, time.Time{}, false
+	}
+	e.Since = st.since
+	e.Suppressed = st.suppressed
+	lastSent := st.lastSent
+	st.lastSent = e.Time
+	st.suppressed = 0
+	return e, lastSent, true
+}
+
+// failed records that the event about the alert could not be sent, so that the
+// next occurrence is notified.
+func (h *webhook) failed(d delivery) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if st, ok := h.alerts[d.key]; ok && st.lastSent.Equal(d.e.Time) {
+		st.lastSent = d.lastSent
+		st.suppressed += d.e.Suppressed
+	}
+}
+
+// clear ends the alert and returns the recovery event to send, if any. A
+// recovery is only sent for the failures that have been notified.
+func (h *webhook) clear(key alertKey, e notifyEvent) (notifyEvent, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	st, ok := h.alerts[key]
+	if !ok {
+		return e, false
+	}
+	delete(h.alerts, key)
+	if st.lastSent.IsZero() || key.event == notifyExpiring || !h.events[notifyRecovery] {
+		return e, false
+	}
+	e.Since = st.since
+	return e, true
+}
+
+// run sends the queued events until the queue is closed.
+func (h *webhook) run() {
+	for d := range h.queue {
+		if err := h.send(d.e); err != nil {
+			d.l.Error("cannot notify ", h.name, " of ", d.e.Event, " of crl \"", d.e.CRL, "\": ", err)
+			if d.key.event != "" {
+				h.failed(d)
+			}
+		} else {
+			d.l.Info("notified ", h.name, " of ", d.e.Event, " of crl \"", d.e.CRL, "\"")
+		}
+		h.pending.Done()
+	}
+}
+
+// send posts the event to the webhook.
+func (h *webhook) send(e notifyEvent) error {
+	var (
+		body []byte
+		err  error
+	)
+	if h.tmpl != nil {
+		body, err = renderNotifyTemplate(h.tmpl, e)
+	} else {
+		body, err = e.payload()
+	}
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(h.method, h.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range h.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("unexpected http status: " + resp.Status)
+	}
+	return nil
+}
+
+// notifier sends the events of the workers to the webhooks. Like metrics, a
+// nil *notifier sends nothing. Webhooks are called asynchronously, see wait.
+type notifier struct {
+	webhooks []*webhook
+}
+
+// newNotifier returns the notifier of the webhooks, nil if there is none.
+func newNotifier(cfgs []notifyConfig) (*notifier, error) {
+	if len(cfgs) == 0 {
+		return nil, nil
+	}
+	n := new(notifier)
+	for _, cfg := range cfgs {
+		h, err := newWebhook(cfg)
+		if err != nil {
+			return nil, err
+		}
+		n.webhooks = append(n.webhooks, h)
+	}
+	return n, nil
+}
+
+// wait waits for the events queued so far to be sent, within the timeout of
+// each webhook.
+func (n *notifier) wait() {
+	if n == nil {
+		return
+	}
+	for _, h := range n.webhooks {
+		h.pending.Wait()
+	}
+}
+
+// fetchResult notifies the failure of the fetch of a CRL or, on success, the
+// recovery of a previous failure.
+func (n *notifier) fetchResult(crl string, err error, l logger) {
+	if n == nil {
+		return
+	}
+	n.result(alertKey{event: notifyFetchFailure, crl: crl}, err, time.Now(), l)
+}
+
+// pushResult notifies the failure of the push of a CRL to a BigIP, including
+// the failure to save or sync its configuration, or, on success, the recovery
+// of a previous failure.
+func (n *notifier) pushResult(crl, bigIP string, err error, l logger) {
+	if n == nil {
+		return
+	}
+	n.result(alertKey{event: notifyPushFailure, crl: crl, bigIP: bigIP}, err, time.Now(), l)
+}
+
+// checkExpiry notifies that the CRL deployed on a BigIP is about to expire,
+// according to the expiry threshold of each webhook.
+func (n *notifier) checkExpiry(crl, bigIP string, nextUpdate time.Time, l logger) {
+	if n == nil {
+		return
+	}
+	n.expiry(alertKey{event: notifyExpiring, crl: crl, bigIP: bigIP}, nextUpdate, time.Now(), l)
+}
+
+func (n *notifier) result(key alertKey, err error, now time.Time, l logger) {
+	for _, h := range n.webhooks {
+		if err != nil {
+			e := notifyEvent{Event: key.event, CRL: key.crl, BigIP: key.bigIP, Error: err.Error(), Time: now}
+			if key.bigIP == "" {
+				e.Message = "cannot fetch crl \"" + key.crl + "\": " + e.Error
+			} else if _, ok := err.(*saveError); ok {
+				e.Message = "crl \"" + key.crl + "\" pushed to " + key.bigIP + " but " + e.Error
+			} else {
+				e.Message = "cannot push crl \"" + key.crl + "\" to " + key.bigIP + ": " + e.Error
+			}
+			if e, lastSent, ok := h.alert(key, e); ok {
+				h.deliver(delivery{key: key, e: e, l: l, lastSent: lastSent})
+			}
+			continue
+		}
+		e := notifyEvent{Event: notifyRecovery, CRL: key.crl, BigIP: key.bigIP, Recovered: key.event, Time: now}
+		if e, ok := h.clear(key, e); ok {
+			if key.bigIP == "" {
+				e.Message = "crl \"" + key.crl + "\" fetched again after failing since " + e.Since.Format(time.RFC3339)
+			} else {
+				e.Message = "crl \"" + key.crl + "\" pushed to " + key.bigIP + " again after failing since " + e.Since.Format(time.RFC3339)
+			}
+			h.deliver(delivery{e: e, l: l})
+		}
+	}
+}
+
+func (n *notifier) expiry(key alertKey, nextUpdate, now time.Time, l logger) {
+	for _, h := range n.webhooks {
+		if nextUpdate.IsZero() || nextUpdate.Sub(now) >= h.expiryThreshold {
+			h.clear(key, notifyEvent{})
+			continue
+		}
+		e := notifyEvent{
+			Event:      notifyExpiring,
+			CRL:        key.crl,
+			BigIP:      key.bigIP,
+			Message:    "crl \"" + key.crl + "\" deployed on " + key.bigIP + " expires at " + nextUpdate.Format(time.RFC3339),
+			Time:       now,
+			NextUpdate: nextUpdate,
+		}
+		if e, lastSent, ok := h.alert(key, e); ok {
+			h.deliver(delivery{key: key, e: e, l: l, lastSent: lastSent})
+		}
+	}
+}
+
+// deliver queues the event to be sent to the webhook. The event is dropped if
+// the queue is full.
+func (h *webhook) deliver(d delivery) {
+	h.pending.Add(1)
+	select {
+	case h.queue <- d:
+	default:
+		h.pending.Done()
+		d.l.Error("cannot notify ", h.name, " of ", d.e.Event, " of crl \"", d.e.CRL, "\": too many pending notifications")
+		if d.key.event != "" {
+			h.failed(d)
+		}
+	}
+}
+
+// validNotifyTemplate reports whether the template parses and renders a JSON
+// document for a sample event.
+func validNotifyTemplate(text string) error {
+	tmpl, err := parseNotifyTemplate(text)
+	if err != nil {
+		return err
+	}
+	_, err = renderNotifyTemplate(tmpl, notifyEvent{
+		Event:   notifyPushFailure,
+		CRL:     "test",
+		BigIP:   "https://bigip",
+		Message: "cannot push crl \"test\" to https://bigip: \"quoted\"",
+		Error:   "\"quoted\"",
+		Time:    time.Now(),
+	})
+	return err
+}
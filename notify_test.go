@@ -0,0 +1,343 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/e-XpertSolutions/f5-rest-client/f5"
+)
+
+// webhookServer records the requests sent to a webhook.
+type webhookServer struct {
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   []map[string]interface{}
+	status   int
+}
+
+func (s *webhookServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var body map[string]interface{}
+	data, _ := ioutil.ReadAll(r.Body)
+	json.Unmarshal(data, &body)
+	s.requests = append(s.requests, r)
+	s.bodies = append(s.bodies, body)
+	if s.status != 0 {
+		w.WriteHeader(s.status)
+	}
+}
+
+// events returns the event of each of the requests received.
+func (s *webhookServer) events() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var events []string
+	for _, body := range s.bodies {
+		events = append(events, body["event"].(string))
+	}
+	return events
+}
+
+func newTestNotifier(t *testing.T, cfg notifyConfig) *notifier {
+	n, err := newNotifier([]notifyConfig{cfg})
+	if err != nil {
+		t.Fatal("setup: ", err)
+	}
+	return n
+}
+
+func TestNotifier_Dedup(t *testing.T) {
+	srv := new(webhookServer)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	n := newTestNotifier(t, notifyConfig{URL: ts.URL, RepeatInterval: duration{Duration: time.Hour}})
+	key := alertKey{event: notifyPushFailure, crl: "test", bigIP: "https://bigip"}
+	now := time.Date(2017, 9, 4, 3, 0, 0, 0, time.UTC)
+	fail := errors.New("cannot commit transaction")
+
+	n.result(key, fail, now, discardLogger{})
+	n.result(key, fail, now.Add(10*time.Minute), discardLogger{})
+	n.result(key, fail, now.Add(20*time.Minute), discardLogger{})
+	n.result(key, fail, now.Add(70*time.Minute), discardLogger{})
+	n.result(key, nil, now.Add(80*time.Minute), discardLogger{})
+	n.result(key, nil, now.Add(90*time.Minute), discardLogger{})
+	n.wait()
+
+	if got, want := srv.events(), []string{notifyPushFailure, notifyPushFailure, notifyRecovery}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got events %q; want %q", got, want)
+	}
+	first := srv.bodies[0]
+	want := map[string]interface{}{
+		"event":   notifyPushFailure,
+		"crl":     "test",
+		"bigip":   "https://bigip",
+		"message": "cannot push crl \"test\" to https://bigip: cannot commit transaction",
+		"error":   "cannot commit transaction",
+		"time":    "2017-09-04T03:00:00Z",
+		"since":   "2017-09-04T03:00:00Z",
+	}
+	if !reflect.DeepEqual(first, want) {
+		t.Errorf("got first payload %v; want %v", first, want)
+	}
+	if got := srv.bodies[1]["suppressed"]; got != float64(2) {
+		t.Errorf("got %v suppressed failures in the repeated notification; want 2", got)
+	}
+	recovery := srv.bodies[2]
+	if recovery["recovered"] != notifyPushFailure || recovery["since"] != "2017-09-04T03:00:00Z" {
+		t.Errorf("got recovery payload %v; want the push failure since 03:00", recovery)
+	}
+	if ct := srv.requests[0].Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("got content type %q; want \"application/json\"", ct)
+	}
+}
+
+func TestNotifier_DeliveryFailure(t *testing.T) {
+	srv := &webhookServer{status: http.StatusInternalServerError}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	n := newTestNotifier(t, notifyConfig{URL: ts.URL})
+	key := alertKey{event: notifyFetchFailure, crl: "test"}
+	now := time.Now()
+	l := &bufferedLogger{}
+	n.result(key, errors.New("404 Not Found"), now, l)
+	n.wait()
+	n.result(key, errors.New("404 Not Found"), now.Add(time.Minute), l)
+	n.wait()
+	// Failures that have not been notified do not lead to a recovery.
+	n.result(key, nil, now.Add(2*time.Minute), l)
+	n.wait()
+
+	if got, want := srv.events(), []string{notifyFetchFailure, notifyFetchFailure}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got events %q; want %q", got, want)
+	}
+	if l.errBuf == "" {
+		t.Error("got no error logged; want the delivery failures")
+	}
+}
+
+func TestNotifier_Template(t *testing.T) {
+	srv := new(webhookServer)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	n := newTestNotifier(t, notifyConfig{
+		URL:      ts.URL,
+		Method:   "PUT",
+		Events:   []string{notifyExpiring},
+		Template: `{"text": {{json .Message}}, "event": {{json .Event}}, "expires": "{{.NextUpdate.Format "2006-01-02"}}"}`,
+		Headers:  map[string]string{"Authorization": "Bearer secret", "Content-Type": "application/vnd.test+json"},
+	})
+	now := time.Date(2017, 9, 4, 12, 0, 0, 0, time.UTC)
+	key := alertKey{event: notifyExpiring, crl: "test", bigIP: "https://bigip"}
+
+	// Not subscribed.
+	n.result(alertKey{event: notifyPushFailure, crl: "test", bigIP: "https://bigip"}, errors.New("failed"), now, discardLogger{})
+	// Not expiring yet.
+	n.expiry(key, now.Add(48*time.Hour), now, discardLogger{})
+	n.expiry(key, now.Add(12*time.Hour), now, discardLogger{})
+	n.expiry(key, now.Add(12*time.Hour), now.Add(time.Minute), discardLogger{})
+	n.wait()
+
+	if len(srv.requests) != 1 {
+		t.Fatalf("got %d requests; want 1", len(srv.requests))
+	}
+	r := srv.requests[0]
+	if r.Method != "PUT" || r.Header.Get("Authorization") != "Bearer secret" || r.Header.Get("Content-Type") != "application/vnd.test+json" {
+		t.Errorf("got request %s with headers %v; want a PUT with the configured headers", r.Method, r.Header)
+	}
+	want := map[string]interface{}{
+		"text":    "crl \"test\" deployed on https://bigip expires at 2017-09-05T00:00:00Z",
+		"event":   notifyExpiring,
+		"expires": "2017-09-05",
+	}
+	if got := srv.bodies[0]; !reflect.DeepEqual(got, want) {
+		t.Errorf("got payload %v; want %v", got, want)
+	}
+}
+
+func TestNewNotifier_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     notifyConfig
+		wantErr string
+	}{
+		{
+			name:    "No URL",
+			cfg:     notifyConfig{Name: "ops"},
+			wantErr: "notify \"ops\": no url provided",
+		},
+		{
+			name:    "Unknown Event",
+			cfg:     notifyConfig{URL: "https://hooks", Events: []string{"push_success"}},
+			wantErr: "notify \"https://hooks\": unsupported event \"push_success\"",
+		},
+		{
+			name:    "Invalid Template",
+			cfg:     notifyConfig{Name: "ops", URL: "https://hooks", Template: "{{.Message"},
+			wantErr: "notify \"ops\": invalid template: template: notify:1: unclosed action",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := newNotifier([]notifyConfig{test.cfg})
+			if err == nil || err.Error() != test.wantErr {
+				t.Errorf("newNotifier: got error %v; want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestWorker_DoNotify(t *testing.T) {
+	srv := newBigIPServer()
+	tsBigIP := httptest.NewServer(srv)
+	defer tsBigIP.Close()
+
+	f5Client, err := f5.NewBasicClient(tsBigIP.URL, "admin", "admin")
+	if err != nil {
+		t.Fatal("cannot instanciate f5 basic client: ", err)
+	}
+	hooks := new(webhookServer)
+	tsHooks := httptest.NewServer(hooks)
+	defer tsHooks.Close()
+
+	pemCRL := newTestPEMCRL(1, time.Now())
+	tsCA := newCRLServer(pemCRL)
+	defer tsCA.Close()
+	tsDown := newCRLServer(nil)
+	defer tsDown.Close()
+
+	p := &pool{notifier: newTestNotifier(t, notifyConfig{URL: tsHooks.URL})}
+	if err := p.addWorker(crlConfig{Name: "test", URL: tsDown.URL, ProfileName: "clientssl"}); err != nil {
+		t.Fatal("setup: ", err)
+	}
+	w := p.workers[0]
+	bigIPs := []*bigIP{{name: "bigip", client: f5Client}}
+
+	w.do(bigIPs, discardLogger{})
+	w.do(bigIPs, discardLogger{})
+	w.urls = []string{tsCA.URL}
+	w.do(bigIPs, discardLogger{})
+	p.notifier.wait()
+
+	if got, want := hooks.events(), []string{notifyFetchFailure, notifyRecovery}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got events %q; want %q", got, want)
+	}
+}
+
+func TestWorker_DoNotifySaveFailure(t *testing.T) {
+	defer func(d time.Duration) { saveBatchDelay = d }(saveBatchDelay)
+	saveBatchDelay = time.Millisecond
+
+	srv := newBigIPServer()
+	srv.Disable = "save_config"
+	tsBigIP := httptest.NewServer(srv)
+	defer tsBigIP.Close()
+
+	f5Client, err := f5.NewBasicClient(tsBigIP.URL, "admin", "admin")
+	if err != nil {
+		t.Fatal("cannot instanciate f5 basic client: ", err)
+	}
+	hooks := new(webhookServer)
+	tsHooks := httptest.NewServer(hooks)
+	defer tsHooks.Close()
+	tsCA := newCRLServer(newTestPEMCRL(1, time.Now()))
+	defer tsCA.Close()
+
+	p := &pool{notifier: newTestNotifier(t, notifyConfig{URL: tsHooks.URL})}
+	if err := p.addWorker(crlConfig{Name: "test", URL: tsCA.URL, ProfileName: "clientssl"}); err != nil {
+		t.Fatal("setup: ", err)
+	}
+	w := p.workers[0]
+	bigIPs := []*bigIP{{name: "bigip", client: f5Client, saver: new(configSaver)}}
+
+	// The configuration cannot be saved: the push is reported as failed
+	// until it is.
+	w.do(bigIPs, discardLogger{})
+	srv.Disable = ""
+	w.do(bigIPs, discardLogger{})
+	p.notifier.wait()
+
+	if got, want := hooks.events(), []string{notifyPushFailure, notifyRecovery}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got events %q; want %q", got, want)
+	}
+	want := "crl \"test\" pushed to bigip but cannot save sys config: "
+	if got := hooks.bodies[0]["message"].(string); !strings.HasPrefix(got, want) {
+		t.Errorf("got message %q; want %q", got, want)
+	}
+}
+
+func TestNotifier_Async(t *testing.T) {
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer ts.Close()
+	defer close(done)
+
+	n := newTestNotifier(t, notifyConfig{URL: ts.URL, Timeout: duration{Duration: time.Minute}})
+	start := time.Now()
+	n.result(alertKey{event: notifyFetchFailure, crl: "test"}, errors.New("404 Not Found"), start, discardLogger{})
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("notifier.result: took %v with a hanging webhook; want it not to wait for the delivery", d)
+	}
+}
+
+func TestWorker_CheckExpiryDeployed(t *testing.T) {
+	srv := newBigIPServer()
+	srv.crlFile = "/Common/old.crl"
+	srv.crlContents = map[string]string{"/Common/old.crl": string(newTestPEMCRL(1, time.Now().Add(-100*365*24*time.Hour+time.Hour)))}
+	tsBigIP := httptest.NewServer(srv)
+	defer tsBigIP.Close()
+
+	f5Client, err := f5.NewBasicClient(tsBigIP.URL, "admin", "admin")
+	if err != nil {
+		t.Fatal("cannot instanciate f5 basic client: ", err)
+	}
+	srv2 := newBigIPServer()
+	srv2.crlFile = "/Common/old.crl"
+	tsBigIP2 := httptest.NewServer(srv2)
+	defer tsBigIP2.Close()
+
+	f5Client2, err := f5.NewBasicClient(tsBigIP2.URL, "admin", "admin")
+	if err != nil {
+		t.Fatal("cannot instanciate f5 basic client: ", err)
+	}
+	hooks := new(webhookServer)
+	tsHooks := httptest.NewServer(hooks)
+	defer tsHooks.Close()
+	tsDown := newCRLServer(nil)
+	defer tsDown.Close()
+
+	// The distribution point is down since the start: the CRL deployed on
+	// the BigIP is read from it.
+	p := &pool{notifier: newTestNotifier(t, notifyConfig{URL: tsHooks.URL, Events: []string{notifyExpiring}})}
+	if err := p.addWorker(crlConfig{Name: "test", URL: tsDown.URL, ProfileName: "clientssl"}); err != nil {
+		t.Fatal("setup: ", err)
+	}
+	w := p.workers[0]
+	bigIPs := []*bigIP{{name: "bigip", client: f5Client}, {name: "bigip2", client: f5Client2}}
+	w.do(bigIPs, discardLogger{})
+
+	// The CRL that could not be read is not read again.
+	srv2.crlContents = srv.crlContents
+	w.do(bigIPs, discardLogger{})
+	p.notifier.wait()
+
+	if got, want := hooks.events(), []string{notifyExpiring}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got events %q; want %q", got, want)
+	}
+	if got := hooks.bodies[0]["bigip"]; got != "bigip" {
+		t.Errorf("got expiring event for %q; want %q", got, "bigip")
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// Exit status of the run-once mode.
+const (
+	exitSuccess        = 0 // the CRLs have been pushed to every (active) BigIP
+	exitFailure        = 1 // no CRL could be pushed to any BigIP
+	exitPartialFailure = 2 // some CRLs could not be pushed to some BigIPs
+)
+
+// summarize writes to out the outcome of a single run of every worker, for
+// every BigIP, and returns the corresponding exit status. The statuses are
+// given in the order of the workers.
+func summarize(out io.Writer, workers []*worker, bigIPs []*bigIP, statuses []runStatus) int {
+	var succeeded, failed, skipped int
+	for i, status := range statuses {
+		for j, b := range bigIPs {
+			if j < len(status.skipped) && status.skipped[j] {
+				fmt.Fprintf(out, "crl %q on %s: skipped, not the active unit\n", workers[i].crlName, b.name)
+				skipped++
+				continue
+			}
+			if err := status.saveErr(j); err != nil {
+				fmt.Fprintf(out, "crl %q on %s: pushed, save failed: %v\n", workers[i].crlName, b.name, err)
+				failed++
+				continue
+			}
+			if err := status.syncErr(j); err != nil {
+				fmt.Fprintf(out, "crl %q on %s: pushed, sync failed: %v\n", workers[i].crlName, b.name, err)
+				failed++
+				continue
+			}
+			if err := status.err(j); err != nil {
+				fmt.Fprintf(out, "crl %q on %s: failed: %v\n", workers[i].crlName, b.name, err)
+				failed++
+				continue
+			}
+			fmt.Fprintf(out, "crl %q on %s: ok\n", workers[i].crlName, b.name)
+			succeeded++
+		}
+	}
+	if skipped > 0 {
+		fmt.Fprintf(out, "%d succeeded, %d failed, %d skipped\n", succeeded, failed, skipped)
+	} else {
+		fmt.Fprintf(out, "%d succeeded, %d failed\n", succeeded, failed)
+	}
+
+	switch {
+	case failed == 0:
+		return exitSuccess
+	case succeeded == 0:
+		return exitFailure
+	}
+	return exitPartialFailure
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSummarize(t *testing.T) {
+	tests := []struct {
+		statuses   []runStatus
+		wantStatus int
+		wantOutput string
+	}{
+		{
+			statuses: []runStatus{
+				{pushErrs: []error{nil, nil}},
+				{},
+			},
+			wantStatus: exitSuccess,
+			wantOutput: `crl "a" on bigip1: ok
+crl "a" on bigip2: ok
+crl "b" on bigip1: ok
+crl "b" on bigip2: ok
+4 succeeded, 0 failed
+`,
+		},
+		{
+			statuses: []runStatus{
+				{pushErrs: []error{nil, errors.New("cannot commit transaction")}},
+				{fetchErr: errors.New("cannot fetch crl")},
+			},
+			wantStatus: exitPartialFailure,
+			wantOutput: `crl "a" on bigip1: ok
+crl "a" on bigip2: failed: cannot commit transaction
+crl "b" on bigip1: failed: cannot fetch crl
+crl "b" on bigip2: failed: cannot fetch crl
+1 succeeded, 3 failed
+`,
+		},
+		{
+			statuses: []runStatus{
+				{pushErrs: []error{nil, nil}, syncErrs: []error{nil, &syncError{group: "failover", err: errors.New("Sync Failure: error")}}},
+				{pushErrs: []error{nil, nil}},
+			},
+			wantStatus: exitPartialFailure,
+			wantOutput: `crl "a" on bigip1: ok
+crl "a" on bigip2: pushed, sync failed: cannot sync device group "failover": Sync Failure: error
+crl "b" on bigip1: ok
+crl "b" on bigip2: ok
+3 succeeded, 1 failed
+`,
+		},
+		{
+			statuses: []runStatus{
+				{pushErrs: []error{nil, nil}, skipped: []bool{false, true}},
+				{pushErrs: []error{nil, nil}, skipped: []bool{false, true}},
+			},
+			wantStatus: exitSuccess,
+			wantOutput: `crl "a" on bigip1: ok
+crl "a" on bigip2: skipped, not the active unit
+crl "b" on bigip1: ok
+crl "b" on bigip2: skipped, not the active unit
+2 succeeded, 0 failed, 2 skipped
+`,
+		},
+		{
+			statuses: []runStatus{
+				{pushErrs: []error{errors.New("timeout"), errors.New("timeout")}},
+				{fetchErr: errors.New("cannot fetch crl")},
+			},
+			wantStatus: exitFailure,
+			wantOutput: `crl "a" on bigip1: failed: timeout
+crl "a" on bigip2: failed: timeout
+crl "b" on bigip1: failed: cannot fetch crl
+crl "b" on bigip2: failed: cannot fetch crl
+0 succeeded, 4 failed
+`,
+		},
+	}
+	for i, test := range tests {
+		var out bytes.Buffer
+		workers := []*worker{{crlName: "a"}, {crlName: "b"}}
+		bigIPs := []*bigIP{{name: "bigip1"}, {name: "bigip2"}}
+		status := summarize(&out, workers, bigIPs, test.statuses)
+		if status != test.wantStatus {
+			t.Errorf("%d. summarize: got status %d; want %d", i, status, test.wantStatus)
+		}
+		if got := out.String(); got != test.wantOutput {
+			t.Errorf("%d. summarize: got output\n%s\nwant\n%s", i, got, test.wantOutput)
+		}
+	}
+}
@@ -2,91 +2,686 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"errors"
+	"fmt"
+	"math/big"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/e-XpertSolutions/f5-rest-client/f5"
-	"github.com/e-XpertSolutions/f5-rest-client/f5/ltm"
-	"github.com/e-XpertSolutions/f5-rest-client/f5/sys"
 )
 
+// crlState identifies a CRL so that two fetched CRLs can be compared without
+// keeping their whole content around.
+type crlState struct {
+	fingerprint [sha256.Size]byte
+	number      *big.Int
+	thisUpdate  time.Time
+	nextUpdate  time.Time
+}
+
+func newCRLState(pemCRL []byte, crl *pkix.CertificateList) (crlState, error) {
+	number, err := crlNumber(crl)
+	if err != nil {
+		return crlState{}, err
+	}
+	return crlState{
+		fingerprint: sha256.Sum256(pemCRL),
+		number:      number,
+		thisUpdate:  crl.TBSCertList.ThisUpdate,
+		nextUpdate:  crl.TBSCertList.NextUpdate,
+	}, nil
+}
+
+// newerThan reports whether s identifies a more recent CRL than other. CRL
+// numbers are compared when both CRLs define one, otherwise their ThisUpdate
+// field is used.
+func (s crlState) newerThan(other crlState) bool {
+	if s.number != nil && other.number != nil {
+		return s.number.Cmp(other.number) > 0
+	}
+	return s.thisUpdate.After(other.thisUpdate)
+}
+
+// equal reports whether s and other identify the same CRL.
+func (s crlState) equal(other crlState) bool {
+	if s.fingerprint != other.fingerprint {
+		return false
+	}
+	if s.number == nil || other.number == nil {
+		return s.number == other.number
+	}
+	return s.number.Cmp(other.number) == 0
+}
+
+// bigIP is a BigIP device the CRLs are pushed to.
+type bigIP struct {
+	// name identifies the BigIP in logs and reports.
+	name   string
+	client *f5.Client
+
+	// syncDeviceGroup is the device group the configuration is synced to
+	// once a CRL has been pushed, if any. Syncing waits up to syncTimeout for
+	// the device group to be in sync.
+	syncDeviceGroup string
+	syncTimeout     time.Duration
+
+	// saver saves the configuration of the BigIP once a CRL has been pushed,
+	// if set.
+	saver *configSaver
+
+	// haMode defines whether the CRLs are pushed to the BigIP regardless of
+	// its failover state, or only when it is the active unit of its device
+	// group, see selectActiveUnits.
+	haMode string
+}
+
 type worker struct {
-	url          string
+	urls         []string
 	crlName      string
-	profileName  string
+	partition    string
+	profiles     profileSelector
 	refreshDelay time.Duration
 	validate     bool
+	issuers      []*x509.Certificate
+	forcePush    bool
+	keepLast     int
+	maxAge       time.Duration
+
+	// Scheduling settings, see nextFetchDelay.
+	schedule       string
+	scheduleOffset time.Duration
+	jitter         time.Duration
+
+	// Publication dates of the last fetched CRL, used for scheduling.
+	thisUpdate          time.Time
+	nextUpdate          time.Time
+	publicationInterval time.Duration
+
+	// caches holds, for each url, the http validators of the last fetched
+	// CRL.
+	caches []httpCache
+
+	// current identifies the most recent CRL fetched so far.
+	current *crlState
+
+	retry retryPolicy
+
+	// metrics records the outcome of the fetches and pushes, if enabled.
+	metrics *metrics
+
+	// notifier sends the failures and recoveries to the webhooks, if any.
+	notifier *notifier
+
+	// pushed keeps track, for each BigIP, of the last CRL that has been
+	// successfully pushed. It is guarded by mu since BigIPs are handled
+	// concurrently.
+	mu     sync.Mutex
+	pushed map[*bigIP]crlState
+
+	// unsaved keeps track, for each BigIP, of the CRL that has been pushed
+	// but whose configuration could not be saved, so that the next run
+	// saves it again instead of uploading another CRL file.
+	unsaved map[*bigIP]crlState
+
+	// unsynced keeps track, for each BigIP, of the CRL that has been pushed
+	// and saved but could not be synced to the device group, so that the
+	// next run only syncs it again.
+	unsynced map[*bigIP]crlState
+
+	// selections keeps track, for each BigIP, of the profiles the last CRL
+	// uploaded has been attached to, as formatted by formatProfiles, so that
+	// the CRL is pushed again when the selection changes.
+	selections map[*bigIP]string
+
+	// deployed keeps track, for each BigIP the CRL has not been pushed to
+	// yet, of the CRL read from the BigIP or uploaded to it, see
+	// deployedNextUpdate.
+	deployed map[*bigIP]deployedExpiry
+
+	// lastFetched is the most recent CRL fetched, pushed again when the
+	// profiles selected on a BigIP change although the CRL has not.
+	lastFetched *fetchedCRL
+
+	// running tells whether the worker routine is alive. lastFetch and
+	// results report the outcome of the last fetch and, for each BigIP, of
+	// the last push, see health. They are guarded by mu as well.
+	running   bool
+	lastFetch runResult
+	results   map[*bigIP]*pushResult
 
 	stopCh chan struct{}
 }
 
-func (w *worker) run(f5Clients []*f5.Client, l logger) {
+func (w *worker) run(bigIPs []*bigIP, l logger) {
 	w.stopCh = make(chan struct{})
+	w.setRunning(true)
 	go func() {
-		w.do(f5Clients, l)
+		defer w.setRunning(false)
+		w.do(bigIPs, l)
 		for {
 			select {
-			case <-time.After(w.refreshDelay):
-				w.do(f5Clients, l)
+			case <-time.After(w.nextFetchDelay(time.Now())):
+				w.do(bigIPs, l)
 			case <-w.stopCh:
-				l.Notice("stop signal received, terminating worker routine")
+				l.With(fieldWorker, w.crlName).Notice("stop signal received, terminating worker routine")
 				return
 			}
 		}
 	}()
 }
 
-func (w *worker) do(f5Clients []*f5.Client, l logger) {
+// runStatus reports the outcome of a single run of a worker.
+type runStatus struct {
+	// fetchErr is the error that prevented the CRL from being fetched, if
+	// any. In that case, the CRL has not been pushed to any BigIP.
+	fetchErr error
+
+	// pushErrs holds, for each BigIP, in the order they were given, the error
+	// that prevented the CRL from being pushed, if any.
+	pushErrs []error
+
+	// saveErrs holds, for each BigIP, in the order they were given, the error
+	// that prevented its configuration from being saved once the CRL has been
+	// pushed, if any.
+	saveErrs []error
+
+	// syncErrs holds, for each BigIP, in the order they were given, the error
+	// that prevented its configuration from being synced to its device group
+	// once the CRL has been pushed, if any.
+	syncErrs []error
+
+	// skipped tells, for each BigIP, in the order they were given, whether it
+	// has been skipped because it is not the active unit of its device group.
+	skipped []bool
+}
+
+// err returns the error that occurred for the i-th BigIP, if any.
+func (s runStatus) err(i int) error {
+	if s.fetchErr != nil {
+		return s.fetchErr
+	}
+	if i < len(s.pushErrs) && s.pushErrs[i] != nil {
+		return s.pushErrs[i]
+	}
+	if err := s.saveErr(i); err != nil {
+		return err
+	}
+	return s.syncErr(i)
+}
+
+// saveErr returns the error that occurred while saving the configuration of
+// the i-th BigIP, if any.
+func (s runStatus) saveErr(i int) error {
+	if i < len(s.saveErrs) {
+		return s.saveErrs[i]
+	}
+	return nil
+}
+
+// syncErr returns the error that occurred while syncing the i-th BigIP to its
+// device group, if any.
+func (s runStatus) syncErr(i int) error {
+	if i < len(s.syncErrs) {
+		return s.syncErrs[i]
+	}
+	return nil
+}
+
+func (w *worker) do(bigIPs []*bigIP, l logger) (status runStatus) {
+	l = l.With(fieldWorker, w.crlName)
+
 	// Make sure no panic will interrupt the program.
 	defer func() {
 		if r := recover(); r != nil {
 			l.Error("panic recovered: ", r)
+			status.fetchErr = errors.New(fmt.Sprint("panic recovered: ", r))
 		}
 	}()
-	crl, err := fetchCRL(w.url)
+
+	// The http validators are forgotten unless the CRL has been successfully
+	// pushed everywhere, so that a failure leads to a full download and push
+	// at the next run instead of a "not modified" response.
+	var succeeded bool
+	defer func() {
+		if !succeeded {
+			w.resetCaches()
+		}
+	}()
+
+	defer w.checkExpiry(bigIPs, l)
+
+	var (
+		fetched     *fetchedCRL
+		notModified bool
+	)
+	err := w.retry.do(w.stopCh, w.logRetry(l, "fetch"), func() (err error) {
+		fetched, err = w.fetch(l)
+		if err == errNotModified {
+			notModified = true
+			return nil
+		}
+		return err
+	})
+	w.recordFetch(err)
+	w.notifier.fetchResult(w.crlName, err, l)
 	if err != nil {
 		l.Error(err)
-		return
+		status.fetchErr = err
+		return status
+	}
+	switch {
+	case notModified && w.profiles.hasPattern() && w.lastFetched != nil:
+		// The profiles selected may have changed since the last push.
+		l.Notice("crl \"", w.crlName, "\" has not been modified since last fetch, checking the selected profiles")
+		fetched = w.lastFetched
+	case notModified:
+		l.Notice("crl \"", w.crlName, "\" has not been modified since last fetch, nothing to do")
+		w.recordUpToDate(bigIPs)
+		succeeded = true
+		return status
+	default:
+		w.observeCRL(fetched.crl)
+		w.lastFetched = fetched
 	}
-	for _, f5Client := range f5Clients {
-		if err := w.pushCRLToClients(f5Client, crl); err != nil {
-			l.Error(err)
+
+	// Each BigIP is handled on its own so that retrying a flaky one does not
+	// delay the others.
+	var wg sync.WaitGroup
+	status.pushErrs = make([]error, len(bigIPs))
+	status.saveErrs = make([]error, len(bigIPs))
+	status.syncErrs = make([]error, len(bigIPs))
+	status.skipped = make([]bool, len(bigIPs))
+	units := w.selectActiveUnits(bigIPs, l)
+	for i, b := range bigIPs {
+		if units[i].err != nil {
+			status.pushErrs[i] = units[i].err
+			w.recordPush(b, units[i].err, false)
+			w.notifier.pushResult(w.crlName, b.name, units[i].err, l.With(fieldBigIP, b.name))
+			continue
+		}
+		if units[i].skip {
+			status.skipped[i] = true
+			w.recordPush(b, nil, true)
+			continue
+		}
+		wg.Add(1)
+		go func(i int, b *bigIP) {
+			defer wg.Done()
+			err := w.pushTo(b, fetched, l)
+			w.recordPush(b, err, false)
+			l := l.With(fieldBigIP, b.name)
+			switch err.(type) {
+			case nil:
+				w.notifier.pushResult(w.crlName, b.name, nil, l)
+			case *saveError:
+				// The CRL is live but would be lost on reboot.
+				w.notifier.pushResult(w.crlName, b.name, err, l)
+				l.Error("crl \"", w.crlName, "\" pushed to ", b.name, " but ", err)
+				status.saveErrs[i] = err
+			case *syncError:
+				w.notifier.pushResult(w.crlName, b.name, err, l)
+				l.Error("crl \"", w.crlName, "\" pushed to ", b.name, " but ", err)
+				status.syncErrs[i] = err
+			default:
+				w.notifier.pushResult(w.crlName, b.name, err, l)
+				l.Error(err)
+				status.pushErrs[i] = err
+			}
+		}(i, b)
+	}
+	wg.Wait()
+
+	succeeded = true
+	for i := range bigIPs {
+		if status.err(i) != nil {
+			succeeded = false
 		}
 	}
+	return status
+}
+
+// logRetry returns a function logging the failure of an operation that is
+// going to be retried.
+func (w *worker) logRetry(l logger, operation string) func(error, int, time.Duration) {
+	return func(err error, attempt int, delay time.Duration) {
+		l.Error(err)
+		l.Notice("crl \"", w.crlName, "\": ", operation, " attempt ", attempt, "/", w.retry.maxAttempts,
+			" failed, retrying in ", delay)
+	}
 }
 
-func (w *worker) pushCRLToClients(f5Client *f5.Client, crl []byte) error {
-	tx, err := f5Client.Begin()
+// pushTo pushes the fetched CRL to a single BigIP, unless it is already up to
+// date, retrying on failure as defined by the retry policy of the worker. The
+// profiles are selected at each run, and the CRL is pushed again when they
+// differ from the ones it has been attached to. The configuration of the BigIP
+// is then saved, if requested, and synced to its device group, if any, a
+// *saveError or *syncError being returned if that fails. The CRL is only
+// recorded as pushed once saved and synced. Until then, as long as the CRL and
+// the selected profiles do not change, the next runs do not upload it again
+// but only save the configuration or sync the device group.
+func (w *worker) pushTo(b *bigIP, fetched *fetchedCRL, l logger) (err error) {
+	// Make sure no panic will interrupt the program.
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.New(fmt.Sprint("panic recovered: ", r))
+		}
+	}()
+
+	l = l.With(fieldBigIP, b.name)
+	var profiles []profileRef
+	err = w.retry.do(w.stopCh, w.logRetry(l, "profile selection"), func() (err error) {
+		profiles, err = w.profiles.resolve(b.client, fetched.crl, l)
+		return err
+	})
 	if err != nil {
 		return err
 	}
+	selection := formatProfiles(profiles)
+	if w.profiles.hasPattern() {
+		l.Notice("crl \"", w.crlName, "\": profiles selected on ", b.name, ": ", selection)
+	}
+	sameSelection := w.lastSelection(b) == selection
+	if last, ok := w.lastPushed(b); ok && !w.forcePush && last.equal(fetched.state) {
+		if sameSelection {
+			l.Notice("crl \"", w.crlName, "\" has not changed since last push, skipping")
+			return nil
+		}
+		l.Notice("crl \"", w.crlName, "\": profiles selected on ", b.name, " changed since last push, pushing again")
+	}
+	// Resume a push whose save or sync failed, as long as neither the CRL
+	// nor the selected profiles have changed since.
+	var saveOnly, syncOnly bool
+	if !w.forcePush && sameSelection {
+		if unsynced, ok := w.lastUnsynced(b); ok && unsynced.equal(fetched.state) {
+			syncOnly = true
+		} else if unsaved, ok := w.lastUnsaved(b); ok && unsaved.equal(fetched.state) {
+			saveOnly = true
+		}
+	}
+	switch {
+	case syncOnly:
+		l.Notice("crl \"", w.crlName, "\" already pushed to ", b.name, ", syncing its device group again")
+	case saveOnly:
+		l.Notice("crl \"", w.crlName, "\" already pushed to ", b.name, ", saving its configuration again")
+	default:
+		err = w.retry.do(w.stopCh, w.logRetry(l, "push"), func() error {
+			err := w.pushCRLToClients(b, fetched, profiles, l)
+			w.metrics.observePush(w.crlName, b.name, err)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		w.setSelection(b, selection)
+		w.setUnsynced(b, nil)
+		w.setDeployed(b, deployedExpiry{nextUpdate: fetched.state.nextUpdate})
+		w.pruneCRLFiles(b.client, l)
+	}
+	if b.saver != nil && !syncOnly {
+		if err := b.saver.save(b.client); err != nil {
+			w.setUnsaved(b, &fetched.state)
+			return &saveError{err: err}
+		}
+		l.Notice("crl \"", w.crlName, "\": configuration of ", b.name, " saved")
+	}
+	w.setUnsaved(b, nil)
+	if b.syncDeviceGroup != "" {
+		if err := syncDeviceGroup(b, w.stopCh); err != nil {
+			w.setUnsynced(b, &fetched.state)
+			return err
+		}
+		l.Notice("crl \"", w.crlName, "\": ", b.name, " synced to device group \"", b.syncDeviceGroup, "\"")
+	}
+	w.setUnsynced(b, nil)
+	w.setPushed(b, fetched.state)
+	w.metrics.observeDeployed(w.crlName, b.name, fetched)
+	return nil
+}
 
-	buf := bytes.NewBuffer(crl)
-	crlName := w.crlName + "_" + strconv.FormatInt(time.Now().Unix(), 10)
+// checkExpiry notifies the CRLs deployed on the BigIPs that are about to
+// expire, whatever the outcome of the run.
+func (w *worker) checkExpiry(bigIPs []*bigIP, l logger) {
+	if w.notifier == nil {
+		return
+	}
+	for _, b := range bigIPs {
+		l := l.With(fieldBigIP, b.name)
+		if nextUpdate, ok := w.deployedNextUpdate(b, l); ok {
+			w.notifier.checkExpiry(w.crlName, b.name, nextUpdate, l)
+		}
+	}
+}
+
+// deployedExpiry is the NextUpdate of the CRL deployed on a BigIP, or the error
+// that occurred while reading it.
+type deployedExpiry struct {
+	nextUpdate time.Time
+	err        error
+}
+
+// deployedNextUpdate returns the NextUpdate of the CRL deployed on the BigIP,
+// i.e. of the last CRL pushed to it. Until one is, e.g. after a restart while
+// the distribution points are down, the CRL is read from the BigIP. It is read
+// only once, even if that fails, until a CRL is uploaded.
+func (w *worker) deployedNextUpdate(b *bigIP, l logger) (time.Time, bool) {
+	if state, ok := w.lastPushed(b); ok {
+		return state.nextUpdate, true
+	}
+	w.mu.Lock()
+	deployed, ok := w.deployed[b]
+	w.mu.Unlock()
+	if !ok {
+		deployed.nextUpdate, deployed.err = w.readDeployedNextUpdate(b, l)
+		if deployed.err != nil {
+			l.Warn("crl \"", w.crlName, "\": cannot read the crl deployed on ", b.name, ": ", deployed.err)
+		}
+		w.setDeployed(b, deployed)
+	}
+	return deployed.nextUpdate, deployed.err == nil
+}
+
+// setDeployed records the CRL deployed on the BigIP.
+func (w *worker) setDeployed(b *bigIP, deployed deployedExpiry) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.deployed == nil {
+		w.deployed = make(map[*bigIP]deployedExpiry)
+	}
+	w.deployed[b] = deployed
+}
 
-	sysClient := sys.New(tx)
-	err = sysClient.FileSSLCRL().CreateFromFile(crlName, buf, int64(buf.Len()))
+// readDeployedNextUpdate reads from the BigIP the NextUpdate of the CRL file
+// attached to the first of the selected profiles. Profiles are not discovered
+// since that requires the CRL.
+func (w *worker) readDeployedNextUpdate(b *bigIP, l logger) (time.Time, error) {
+	selector := w.profiles
+	selector.discover = false
+	if len(selector.refs) == 0 && !selector.hasPattern() {
+		return time.Time{}, errors.New("no profile selected by name or pattern")
+	}
+	profiles, err := selector.resolve(b.client, nil, l)
 	if err != nil {
-		return err
+		return time.Time{}, err
+	}
+	for _, ref := range profiles {
+		profile, err := getProfile(b.client, ref)
+		if err != nil {
+			return time.Time{}, errors.New("cannot get " + ref.String() + ": " + err.Error())
+		}
+		if isNone(profile.crlFile) {
+			continue
+		}
+		data, err := readSSLFile(b.client, "ssl-crl", profile.crlFile)
+		if err != nil {
+			return time.Time{}, err
+		}
+		crl, err := parseCRL(data)
+		if err != nil {
+			return time.Time{}, errors.New("invalid ssl-crl file \"" + profile.crlFile + "\": " + err.Error())
+		}
+		return crl.TBSCertList.NextUpdate, nil
+	}
+	return time.Time{}, errors.New("no crl file attached to " + formatProfiles(profiles))
+}
+
+// lastPushed returns the state of the last CRL successfully pushed to the
+// BigIP, if any.
+func (w *worker) lastPushed(b *bigIP) (crlState, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	state, ok := w.pushed[b]
+	return state, ok
+}
+
+// setPushed records the state of the CRL successfully pushed to the BigIP.
+func (w *worker) setPushed(b *bigIP, state crlState) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.pushed == nil {
+		w.pushed = make(map[*bigIP]crlState)
 	}
+	w.pushed[b] = state
+}
+
+// lastUnsaved returns the state of the CRL pushed to the BigIP whose
+// configuration could not be saved, if any.
+func (w *worker) lastUnsaved(b *bigIP) (crlState, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	state, ok := w.unsaved[b]
+	return state, ok
+}
+
+// setUnsaved records the state of the CRL pushed to the BigIP whose
+// configuration could not be saved, or forgets it if state is nil.
+func (w *worker) setUnsaved(b *bigIP, state *crlState) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if state == nil {
+		delete(w.unsaved, b)
+		return
+	}
+	if w.unsaved == nil {
+		w.unsaved = make(map[*bigIP]crlState)
+	}
+	w.unsaved[b] = *state
+}
+
+// lastUnsynced returns the state of the CRL pushed to the BigIP that could not
+// be synced to its device group, if any.
+func (w *worker) lastUnsynced(b *bigIP) (crlState, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	state, ok := w.unsynced[b]
+	return state, ok
+}
+
+// setUnsynced records the state of the CRL pushed to the BigIP that could not
+// be synced to its device group, or forgets it if state is nil.
+func (w *worker) setUnsynced(b *bigIP, state *crlState) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if state == nil {
+		delete(w.unsynced, b)
+		return
+	}
+	if w.unsynced == nil {
+		w.unsynced = make(map[*bigIP]crlState)
+	}
+	w.unsynced[b] = *state
+}
+
+// lastSelection returns the profiles the last CRL uploaded to the BigIP has
+// been attached to, as formatted by formatProfiles.
+func (w *worker) lastSelection(b *bigIP) string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.selections[b]
+}
+
+// setSelection records the profiles the CRL uploaded to the BigIP has been
+// attached to.
+func (w *worker) setSelection(b *bigIP, selection string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.selections == nil {
+		w.selections = make(map[*bigIP]string)
+	}
+	w.selections[b] = selection
+}
+
+// crlFileName returns the name of the CRL file uploaded at the given time,
+// without the ".crl" extension added by the BigIP.
+func (w *worker) crlFileName(now time.Time) string {
+	return w.crlName + "_" + strconv.FormatInt(now.Unix(), 10)
+}
+
+// crlPartition returns the administrative partition the CRL files are uploaded
+// to.
+func (w *worker) crlPartition() string {
+	if w.partition == "" {
+		return defaultPartition
+	}
+	return w.partition
+}
 
-	ltmClient := ltm.New(tx)
+// crlFilePath returns the full path of the CRL file uploaded under the given
+// name, e.g. "/Common/test_1504526650.crl".
+func (w *worker) crlFilePath(name string) string {
+	return "/" + w.crlPartition() + "/" + name + ".crl"
+}
 
-	cfg, err := ltmClient.ProfileClientSSL().Get(w.profileName)
+// createCRLFile uploads the CRL and creates from it a sys file ssl-crl object
+// with the given name in the given partition.
+func createCRLFile(f5Client *f5.Client, partition, name string, data []byte) error {
+	resp, err := f5Client.UploadFile(bytes.NewReader(data), name, int64(len(data)))
 	if err != nil {
-		return errors.New("cannot get client ssl: " + err.Error())
+		return errors.New("failed to upload crl file: " + err.Error())
+	}
+	file := map[string]string{
+		"name":        name,
+		"partition":   partition,
+		"source-path": "file:" + resp.LocalFilePath,
 	}
+	if err := f5Client.ModQuery("POST", "/mgmt/tm/sys/file/ssl-crl", file); err != nil {
+		return errors.New("failed to import crl file: " + err.Error())
+	}
+	return nil
+}
 
-	// .crl extension is automatically added while uploading the file, therefore
-	// we need to concatenate it to crlName so thtat the client-ssl API can
-	// retrieve it.
-	cfg.CRLFile = crlName + ".crl"
+// pushCRLToClients uploads the CRL to the BigIP and attaches it to the given
+// profiles in a single transaction.
+func (w *worker) pushCRLToClients(b *bigIP, fetched *fetchedCRL, profiles []profileRef, l logger) error {
+	tx, err := b.client.Begin()
+	if err != nil {
+		return err
+	}
+	l = l.With(fieldTxID, tx.TxID())
+	l.Debug("crl \"", w.crlName, "\": transaction started on ", b.name)
+
+	crlName := w.crlFileName(time.Now())
+
+	// The CRL file is uploaded once and shared by all the profiles.
+	if err := createCRLFile(tx, w.crlPartition(), crlName, fetched.pem); err != nil {
+		return err
+	}
 
-	if err := ltmClient.ProfileClientSSL().Edit(w.profileName, *cfg); err != nil {
-		return errors.New("cannot modify client-ssl: " + err.Error())
+	// .crl extension is automatically added while uploading the file,
+	// therefore we need to concatenate it to crlName so thtat the client-ssl
+	// and server-ssl APIs can retrieve it.
+	crlPath := w.crlFilePath(crlName)
+	l = l.With(fieldCRLFile, crlPath)
+	l.Debug("crl \"", w.crlName, "\": crl file uploaded to ", b.name)
+	previous := make([]string, len(profiles))
+	for i, ref := range profiles {
+		if previous[i], err = setProfileCRLFile(tx, ref, crlPath); err != nil {
+			return err
+		}
 	}
 
 	if err := tx.Commit(); err != nil {
@@ -94,15 +689,37 @@ func (w *worker) pushCRLToClients(f5Client *f5.Client, crl []byte) error {
 	}
 
 	// Now that the transaction has been committed, we verify that everything
-	// worked as intended.
-	cfg, err = ltmClient.ProfileClientSSL().Get(w.profileName)
+	// worked as intended, and restore the previous crl files otherwise.
+	if err := verifyPush(tx, profiles, crlPath); err != nil {
+		l.Error("crl \"", w.crlName, "\": verification failed on ", b.name, ", rolling back: ", err)
+		if rbErr := w.rollbackPush(b, profiles, previous, crlPath, l); rbErr != nil {
+			return errors.New(err.Error() + " (rollback failed: " + rbErr.Error() + ")")
+		}
+		return err
+	}
+
+	return nil
+}
+
+// verifyPush verifies that the profiles reference the crl file with the given
+// full path and that this file exists on the BigIP.
+func verifyPush(f5Client *f5.Client, profiles []profileRef, crlPath string) error {
+	for _, ref := range profiles {
+		profile, err := getProfile(f5Client, ref)
+		if err != nil {
+			return errors.New("cannot retrieve updated " + ref.String() + ": " + err.Error())
+		}
+		if profile.crlFile != crlPath {
+			return errors.New(ref.String() + " has not been updated with the newly updated crl")
+		}
+	}
+	exists, err := hasCRLFile(f5Client, crlPath)
 	if err != nil {
-		return errors.New("cannot retrieve updated client ssl profile: " + err.Error())
+		return err
 	}
-	if !strings.HasSuffix(cfg.CRLFile, crlName+".crl") {
-		return errors.New("client-ssl has not been updated with the newly updated crl")
+	if !exists {
+		return errors.New("crl file \"" + crlPath + "\" referenced by " + formatProfiles(profiles) + " does not exist")
 	}
-
 	return nil
 }
 
@@ -113,31 +730,108 @@ func (w *worker) stop() {
 
 type pool struct {
 	workers []*worker
+
+	// bigIPs are the BigIPs the workers have been started for.
+	bigIPs []*bigIP
+
+	// notifier is shared by the workers, if any webhook is configured. It
+	// must be set before adding workers.
+	notifier *notifier
+
+	// metrics is shared by the workers, if enabled. It must be set before
+	// the workers are added.
+	metrics *metrics
 }
 
-func (p *pool) addWorker(cfg crlConfig) {
-	p.workers = append(p.workers, &worker{
-		url:          cfg.URL,
+func (p *pool) addWorker(cfg crlConfig) error {
+	w := &worker{
+		urls:         cfg.urls(),
 		crlName:      cfg.Name,
-		profileName:  cfg.ProfileName,
-		refreshDelay: cfg.RefreshDelay.Duration * time.Hour,
+		partition:    cfg.Partition,
+		refreshDelay: cfg.RefreshDelay.Duration,
 		validate:     cfg.Validate,
-	})
+		forcePush:    cfg.ForcePush,
+		keepLast:     cfg.KeepLast,
+		maxAge:       cfg.MaxAge.Duration,
+
+		schedule:       cfg.Schedule,
+		scheduleOffset: cfg.ScheduleOffset.Duration,
+		jitter:         cfg.Jitter.Duration,
+
+		metrics:  p.metrics,
+		notifier: p.notifier,
+
+		retry: retryPolicy{
+			maxAttempts:    cfg.RetryMaxAttempts,
+			initialBackoff: cfg.RetryInitialBackoff.Duration,
+			maxBackoff:     cfg.RetryMaxBackoff.Duration,
+			jitter:         cfg.RetryJitter.Duration,
+		},
+	}
+	if w.retry.initialBackoff == 0 {
+		w.retry.initialBackoff = defaultInitialBackoff
+	}
+	if w.retry.maxBackoff == 0 {
+		w.retry.maxBackoff = defaultMaxBackoff
+	}
+	if len(w.urls) == 0 {
+		return errors.New("crl \"" + cfg.Name + "\": no url provided")
+	}
+	profiles, err := newProfileSelector(cfg)
+	if err != nil {
+		return errors.New("crl \"" + cfg.Name + "\": " + err.Error())
+	}
+	w.profiles = profiles
+	if !isValidSchedule(cfg.Schedule) {
+		return errors.New("crl \"" + cfg.Name + "\": unsupported schedule \"" + cfg.Schedule + "\"")
+	}
+	if cfg.Validate {
+		if cfg.IssuerCA == "" {
+			return errors.New("crl \"" + cfg.Name + "\": validate is enabled but no issuer_ca is provided")
+		}
+		issuers, err := loadCertificates(cfg.IssuerCA)
+		if err != nil {
+			return errors.New("crl \"" + cfg.Name + "\": cannot load issuer_ca: " + err.Error())
+		}
+		w.issuers = issuers
+	}
+	p.metrics.addCRL(w.crlName)
+	p.workers = append(p.workers, w)
+	return nil
 }
 
-func (p *pool) startAll(f5Clients []*f5.Client, l logger) error {
-	if f5Clients == nil {
+func (p *pool) startAll(bigIPs []*bigIP, l logger) error {
+	if bigIPs == nil {
 		return errors.New("f5 clients list is nil")
 	}
-	if len(f5Clients) == 0 {
+	if len(bigIPs) == 0 {
 		return errors.New("f5 clients list is empty")
 	}
+	p.bigIPs = bigIPs
 	for _, w := range p.workers {
-		w.run(f5Clients, l)
+		w.run(bigIPs, l)
 	}
 	return nil
 }
 
+// runOnce runs every worker a single time, concurrently, and waits for all of
+// them to complete, as well as for the notifications they sent. The status of
+// each worker is returned in the order the workers were added.
+func (p *pool) runOnce(bigIPs []*bigIP, l logger) []runStatus {
+	statuses := make([]runStatus, len(p.workers))
+	var wg sync.WaitGroup
+	for i, w := range p.workers {
+		wg.Add(1)
+		go func(i int, w *worker) {
+			defer wg.Done()
+			statuses[i] = w.do(bigIPs, l)
+		}(i, w)
+	}
+	wg.Wait()
+	p.notifier.wait()
+	return statuses
+}
+
 // XXX(gilliek): wait with timeout?
 func (p *pool) stopAll() {
 	var wg sync.WaitGroup
@@ -149,4 +843,5 @@ func (p *pool) stopAll() {
 		}(w)
 	}
 	wg.Wait()
+	p.notifier.wait()
 }
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"crypto/x509"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -18,7 +20,7 @@ func TestWorker_Run(t *testing.T) {
 	defer tsCA.Close()
 
 	w := &worker{
-		url:          tsCA.URL,
+		urls:         []string{tsCA.URL},
 		refreshDelay: 50 * time.Millisecond,
 	}
 
@@ -35,7 +37,10 @@ func TestWorker_Run(t *testing.T) {
 
 func TestWorker_Do(t *testing.T) {
 	t.Run("Happy Path", testWorkerDoHappyPath)
+	t.Run("Skip Unchanged CRL", testWorkerDoSkipUnchangedCRL)
+	t.Run("CRL Not Modified", testWorkerDoCRLNotModified)
 	t.Run("Invalid CRL distribution URL", testWorkerDoInvalidURL)
+	t.Run("Fail Validate CRL", testWorkerDoFailValidateCRL)
 	t.Run("Fail Begin Transaction", testWorkerDoFailBeginTransaction)
 	t.Run("Fail SSL CRL", testWorkerDoFailSSLCRL)
 	t.Run("Fail Get Client SSL", testWorkerDoFailGetProfileClientSSL)
@@ -43,6 +48,87 @@ func TestWorker_Do(t *testing.T) {
 	t.Run("Fail Commit Transaction", testWorkerDoFailCommitTransaction)
 	t.Run("Fail Verify Request", testWorkerDoFailVerifyRequest)
 	t.Run("Fail Verify", testWorkerDoFailVerify)
+	t.Run("Multiple Profiles", testWorkerDoMultipleProfiles)
+	t.Run("Selection Changed", testWorkerDoSelectionChanged)
+}
+
+func testWorkerDoMultipleProfiles(t *testing.T) {
+	srv := newBigIPServer()
+	srv.otherProfiles = map[string]string{
+		"clientssl-app-1": "/Common/old.crl",
+		"clientssl-app-2": "/Common/old.crl",
+		"clientssl-web":   "/Common/old.crl",
+
+		"/TenantA/clientssl-app-3": "/Common/old.crl",
+	}
+	srv.serverProfiles = map[string]string{
+		"serverssl-backend": "/Common/old.crl",
+		"serverssl-other":   "/Common/old.crl",
+	}
+	tsBigIP := httptest.NewServer(srv)
+	defer tsBigIP.Close()
+
+	f5Client, err := f5.NewBasicClient(tsBigIP.URL, "admin", "admin")
+	if err != nil {
+		t.Fatal("cannot instanciate f5 basic client: ", err)
+	}
+
+	tsCA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(crlFile))
+	}))
+	defer tsCA.Close()
+
+	w := worker{
+		urls:      []string{tsCA.URL},
+		crlName:   "test",
+		partition: "TenantA",
+		profiles: profileSelector{
+			refs:     []profileRef{{clientSSL, "/Common/clientssl"}, {serverSSL, "/Common/serverssl-backend"}},
+			patterns: []profilePattern{{kind: clientSSL, glob: "clientssl-app-*"}},
+		},
+		refreshDelay: 300,
+		stopCh:       make(chan struct{}),
+	}
+	l := new(bufferedLogger)
+	w.do([]*bigIP{{name: tsBigIP.URL, client: f5Client}}, l)
+	if err := l.GetLastError(); err != nil {
+		t.Fatalf("worker.do: unexpected error %q", err.Error())
+	}
+	if got, want := len(srv.crlFiles), 1; got != want {
+		t.Fatalf("worker.do: got %d uploaded crl files; want %d", got, want)
+	}
+	uploaded := srv.crlFiles[0]
+	if !strings.HasPrefix(uploaded, "/TenantA/test_") {
+		t.Errorf("worker.do: got uploaded crl file %q; want it in partition %q", uploaded, "TenantA")
+	}
+	for _, name := range []string{"clientssl-app-1", "clientssl-app-2", "/TenantA/clientssl-app-3"} {
+		if got := srv.otherProfiles[name]; got != uploaded {
+			t.Errorf("worker.do: got crl file %q for profile %q; want %q", got, name, uploaded)
+		}
+	}
+	if got := srv.crlFile; got != uploaded {
+		t.Errorf("worker.do: got crl file %q for profile %q; want %q", got, "clientssl", uploaded)
+	}
+	if got := srv.serverProfiles["serverssl-backend"]; got != uploaded {
+		t.Errorf("worker.do: got crl file %q for server-ssl profile %q; want %q", got, "serverssl-backend", uploaded)
+	}
+	if got, want := srv.otherProfiles["clientssl-web"], "/Common/old.crl"; got != want {
+		t.Errorf("worker.do: got crl file %q for unselected profile; want %q", got, want)
+	}
+	if got, want := srv.serverProfiles["serverssl-other"], "/Common/old.crl"; got != want {
+		t.Errorf("worker.do: got crl file %q for unselected server-ssl profile; want %q", got, want)
+	}
+
+	srv.Disable = "server-ssl_put"
+	w.do([]*bigIP{{name: tsBigIP.URL, client: f5Client}}, l)
+	if err := l.GetLastError(); err == nil {
+		t.Error("worker.do: expected error, got nil")
+	} else {
+		wantErr := "cannot modify server-ssl profile \"/Common/serverssl-backend\": http response error: 404 Not Found"
+		if err.Error() != wantErr {
+			t.Errorf("worker.do: got error %q; want %q", err.Error(), wantErr)
+		}
+	}
 }
 
 func testWorkerDoHappyPath(t *testing.T) {
@@ -61,17 +147,117 @@ func testWorkerDoHappyPath(t *testing.T) {
 	defer tsCA.Close()
 
 	w := worker{
-		url:          tsCA.URL,
+		urls:         []string{tsCA.URL},
+		crlName:      "test",
+		profiles:     profileSelector{refs: []profileRef{{clientSSL, "/Common/clientssl"}}},
+		refreshDelay: 300,
+		stopCh:       make(chan struct{}),
+	}
+	l := new(bufferedLogger)
+	w.do([]*bigIP{{name: tsBigIP.URL, client: f5Client}}, l)
+	if err := l.GetLastError(); err != nil {
+		t.Errorf("worker.do: unexpected error %q", err.Error())
+	}
+}
+
+func testWorkerDoSkipUnchangedCRL(t *testing.T) {
+	srv := newBigIPServer()
+	tsBigIP := httptest.NewServer(srv)
+	defer tsBigIP.Close()
+
+	f5Client, err := f5.NewBasicClient(tsBigIP.URL, "admin", "admin")
+	if err != nil {
+		t.Fatal("cannot instanciate f5 basic client: ", err)
+	}
+
+	tsCA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(crlFile))
+	}))
+	defer tsCA.Close()
+
+	w := worker{
+		urls:         []string{tsCA.URL},
+		crlName:      "test",
+		profiles:     profileSelector{refs: []profileRef{{clientSSL, "/Common/clientssl"}}},
+		refreshDelay: 300,
+		stopCh:       make(chan struct{}),
+	}
+	bigIPs := []*bigIP{{name: tsBigIP.URL, client: f5Client}}
+	l := new(bufferedLogger)
+	w.do(bigIPs, l)
+	w.do(bigIPs, l)
+	if err := l.GetLastError(); err != nil {
+		t.Errorf("worker.do: unexpected error %q", err.Error())
+	}
+	if got, want := srv.callsToClientSSLGet, 2; got != want {
+		t.Errorf("worker.do: got %d calls to client-ssl; want %d", got, want)
+	}
+	wantNotice := "crl \"test\" has not changed since last push, skipping"
+	if got := l.GetLastNotice(); got != wantNotice {
+		t.Errorf("worker.do: got notice %q; want %q", got, wantNotice)
+	}
+
+	w.forcePush = true
+	w.do(bigIPs, l)
+	if got, want := srv.callsToClientSSLGet, 4; got != want {
+		t.Errorf("worker.do: got %d calls to client-ssl with force push; want %d", got, want)
+	}
+}
+
+func testWorkerDoCRLNotModified(t *testing.T) {
+	srv := newBigIPServer()
+	tsBigIP := httptest.NewServer(srv)
+	defer tsBigIP.Close()
+
+	f5Client, err := f5.NewBasicClient(tsBigIP.URL, "admin", "admin")
+	if err != nil {
+		t.Fatal("cannot instanciate f5 basic client: ", err)
+	}
+
+	var totalDownloads int
+	tsCA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		totalDownloads++
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(crlFile))
+	}))
+	defer tsCA.Close()
+
+	w := worker{
+		urls:         []string{tsCA.URL},
 		crlName:      "test",
-		profileName:  "clientssl",
+		profiles:     profileSelector{refs: []profileRef{{clientSSL, "/Common/clientssl"}}},
 		refreshDelay: 300,
 		stopCh:       make(chan struct{}),
 	}
+	bigIPs := []*bigIP{{name: tsBigIP.URL, client: f5Client}}
 	l := new(bufferedLogger)
-	w.do([]*f5.Client{f5Client}, l)
+	w.do(bigIPs, l)
+	w.do(bigIPs, l)
 	if err := l.GetLastError(); err != nil {
 		t.Errorf("worker.do: unexpected error %q", err.Error())
 	}
+	if totalDownloads != 1 {
+		t.Errorf("worker.do: got %d downloads; want %d", totalDownloads, 1)
+	}
+	wantNotice := "crl \"test\" has not been modified since last fetch, nothing to do"
+	if got := l.GetLastNotice(); got != wantNotice {
+		t.Errorf("worker.do: got notice %q; want %q", got, wantNotice)
+	}
+
+	// A failed push must lead to a full download at the next run.
+	srv.Disable = "begin_transaction"
+	w.forcePush = true
+	w.do(bigIPs, l)
+	w.forcePush = false
+	srv.Disable = ""
+	w.do(bigIPs, l)
+	if totalDownloads != 3 {
+		t.Errorf("worker.do: got %d downloads after failure; want %d", totalDownloads, 3)
+	}
 }
 
 func testWorkerDoInvalidURL(t *testing.T) {
@@ -81,14 +267,14 @@ func testWorkerDoInvalidURL(t *testing.T) {
 	defer tsCA.Close()
 
 	w := worker{
-		url:          tsCA.URL,
+		urls:         []string{tsCA.URL},
 		crlName:      "test",
-		profileName:  "clientssl",
+		profiles:     profileSelector{refs: []profileRef{{clientSSL, "/Common/clientssl"}}},
 		refreshDelay: 300,
 		stopCh:       make(chan struct{}),
 	}
 	l := new(bufferedLogger)
-	w.do([]*f5.Client{nil}, l)
+	w.do([]*bigIP{{name: "bigip"}}, l)
 	if err := l.GetLastError(); err == nil {
 		t.Error("worker.do: expected error, got nil")
 	} else {
@@ -99,6 +285,33 @@ func testWorkerDoInvalidURL(t *testing.T) {
 	}
 }
 
+func testWorkerDoFailValidateCRL(t *testing.T) {
+	tsCA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(crlFile))
+	}))
+	defer tsCA.Close()
+
+	w := worker{
+		urls:         []string{tsCA.URL},
+		crlName:      "test",
+		profiles:     profileSelector{refs: []profileRef{{clientSSL, "/Common/clientssl"}}},
+		refreshDelay: 300,
+		validate:     true,
+		issuers:      []*x509.Certificate{newSelfSignedCert("other")},
+		stopCh:       make(chan struct{}),
+	}
+	l := new(bufferedLogger)
+	w.do([]*bigIP{{name: "bigip"}}, l)
+	if err := l.GetLastError(); err == nil {
+		t.Error("worker.do: expected error, got nil")
+	} else {
+		wantErr := "crl validation failed: crl issuer does not match any issuer certificate"
+		if err.Error() != wantErr {
+			t.Errorf("worker.do: got error %q; want %q", err.Error(), wantErr)
+		}
+	}
+}
+
 func testWorkerDoFailBeginTransaction(t *testing.T) {
 	srv := newBigIPServer()
 	srv.Disable = "begin_transaction"
@@ -116,14 +329,14 @@ func testWorkerDoFailBeginTransaction(t *testing.T) {
 	defer tsCA.Close()
 
 	w := worker{
-		url:          tsCA.URL,
+		urls:         []string{tsCA.URL},
 		crlName:      "test",
-		profileName:  "clientssl",
+		profiles:     profileSelector{refs: []profileRef{{clientSSL, "/Common/clientssl"}}},
 		refreshDelay: 300,
 		stopCh:       make(chan struct{}),
 	}
 	l := new(bufferedLogger)
-	w.do([]*f5.Client{f5Client}, l)
+	w.do([]*bigIP{{name: tsBigIP.URL, client: f5Client}}, l)
 	if err := l.GetLastError(); err == nil {
 		t.Error("worker.do: expected error, got nil")
 	} else {
@@ -151,14 +364,14 @@ func testWorkerDoFailSSLCRL(t *testing.T) {
 	defer tsCA.Close()
 
 	w := worker{
-		url:          tsCA.URL,
+		urls:         []string{tsCA.URL},
 		crlName:      "test",
-		profileName:  "clientssl",
+		profiles:     profileSelector{refs: []profileRef{{clientSSL, "/Common/clientssl"}}},
 		refreshDelay: 300,
 		stopCh:       make(chan struct{}),
 	}
 	l := new(bufferedLogger)
-	w.do([]*f5.Client{f5Client}, l)
+	w.do([]*bigIP{{name: tsBigIP.URL, client: f5Client}}, l)
 	if err := l.GetLastError(); err == nil {
 		t.Error("worker.do: expected error, got nil")
 	} else {
@@ -186,18 +399,18 @@ func testWorkerDoFailGetProfileClientSSL(t *testing.T) {
 	defer tsCA.Close()
 
 	w := worker{
-		url:          tsCA.URL,
+		urls:         []string{tsCA.URL},
 		crlName:      "test",
-		profileName:  "clientssl",
+		profiles:     profileSelector{refs: []profileRef{{clientSSL, "/Common/clientssl"}}},
 		refreshDelay: 300,
 		stopCh:       make(chan struct{}),
 	}
 	l := new(bufferedLogger)
-	w.do([]*f5.Client{f5Client}, l)
+	w.do([]*bigIP{{name: tsBigIP.URL, client: f5Client}}, l)
 	if err := l.GetLastError(); err == nil {
 		t.Error("worker.do: expected error, got nil")
 	} else {
-		wantErr := "cannot get client ssl: http response error: 404 Not Found"
+		wantErr := "cannot get client-ssl profile \"/Common/clientssl\": http response error: 404 Not Found"
 		if err.Error() != wantErr {
 			t.Errorf("worker.do: got error %q; want %q", err.Error(), wantErr)
 		}
@@ -221,18 +434,18 @@ func testWorkerDoFailEditProfileClientSSL(t *testing.T) {
 	defer tsCA.Close()
 
 	w := worker{
-		url:          tsCA.URL,
+		urls:         []string{tsCA.URL},
 		crlName:      "test",
-		profileName:  "clientssl",
+		profiles:     profileSelector{refs: []profileRef{{clientSSL, "/Common/clientssl"}}},
 		refreshDelay: 300,
 		stopCh:       make(chan struct{}),
 	}
 	l := new(bufferedLogger)
-	w.do([]*f5.Client{f5Client}, l)
+	w.do([]*bigIP{{name: tsBigIP.URL, client: f5Client}}, l)
 	if err := l.GetLastError(); err == nil {
 		t.Error("worker.do: expected error, got nil")
 	} else {
-		wantErr := "cannot modify client-ssl: http response error: 404 Not Found"
+		wantErr := "cannot modify client-ssl profile \"/Common/clientssl\": http response error: 404 Not Found"
 		if err.Error() != wantErr {
 			t.Errorf("worker.do: got error %q; want %q", err.Error(), wantErr)
 		}
@@ -256,14 +469,14 @@ func testWorkerDoFailCommitTransaction(t *testing.T) {
 	defer tsCA.Close()
 
 	w := worker{
-		url:          tsCA.URL,
+		urls:         []string{tsCA.URL},
 		crlName:      "test",
-		profileName:  "clientssl",
+		profiles:     profileSelector{refs: []profileRef{{clientSSL, "/Common/clientssl"}}},
 		refreshDelay: 300,
 		stopCh:       make(chan struct{}),
 	}
 	l := new(bufferedLogger)
-	w.do([]*f5.Client{f5Client}, l)
+	w.do([]*bigIP{{name: tsBigIP.URL, client: f5Client}}, l)
 	if err := l.GetLastError(); err == nil {
 		t.Error("worker.do: expected error, got nil")
 	} else {
@@ -291,18 +504,18 @@ func testWorkerDoFailVerify(t *testing.T) {
 	defer tsCA.Close()
 
 	w := worker{
-		url:          tsCA.URL,
+		urls:         []string{tsCA.URL},
 		crlName:      "test",
-		profileName:  "clientssl",
+		profiles:     profileSelector{refs: []profileRef{{clientSSL, "/Common/clientssl"}}},
 		refreshDelay: 300,
 		stopCh:       make(chan struct{}),
 	}
 	l := new(bufferedLogger)
-	w.do([]*f5.Client{f5Client}, l)
+	w.do([]*bigIP{{name: tsBigIP.URL, client: f5Client}}, l)
 	if err := l.GetLastError(); err == nil {
 		t.Error("worker.do: expected error, got nil")
 	} else {
-		wantErr := "client-ssl has not been updated with the newly updated crl"
+		wantErr := "client-ssl profile \"/Common/clientssl\" has not been updated with the newly updated crl"
 		if err.Error() != wantErr {
 			t.Errorf("worker.do: got error %q; want %q", err.Error(), wantErr)
 		}
@@ -326,18 +539,19 @@ func testWorkerDoFailVerifyRequest(t *testing.T) {
 	defer tsCA.Close()
 
 	w := worker{
-		url:          tsCA.URL,
+		urls:         []string{tsCA.URL},
 		crlName:      "test",
-		profileName:  "clientssl",
+		profiles:     profileSelector{refs: []profileRef{{clientSSL, "/Common/clientssl"}}},
 		refreshDelay: 300,
 		stopCh:       make(chan struct{}),
 	}
 	l := new(bufferedLogger)
-	w.do([]*f5.Client{f5Client}, l)
+	w.do([]*bigIP{{name: tsBigIP.URL, client: f5Client}}, l)
 	if err := l.GetLastError(); err == nil {
 		t.Error("worker.do: expected error, got nil")
 	} else {
-		wantErr := "cannot retrieve updated client ssl profile: http response error: 500 Internal Server Error"
+		wantErr := "cannot retrieve updated client-ssl profile \"/Common/clientssl\": http response error: 500 Internal Server Error" +
+			" (rollback failed: cannot get client-ssl profile \"/Common/clientssl\": http response error: 500 Internal Server Error)"
 		if err.Error() != wantErr {
 			t.Errorf("worker.do: got error %q; want %q", err.Error(), wantErr)
 		}
@@ -359,6 +573,48 @@ func TestWorker_Stop(t *testing.T) {
 	}
 }
 
+func TestPool_AddWorker(t *testing.T) {
+	tests := []struct {
+		cfg     crlConfig
+		wantErr string
+	}{
+		{
+			cfg: crlConfig{Name: "test", URL: "http://localhost/test.crl"},
+		},
+		{
+			cfg:     crlConfig{Name: "test"},
+			wantErr: "crl \"test\": no url provided",
+		},
+		{
+			cfg:     crlConfig{Name: "test", URL: "http://localhost/test.crl", Validate: true},
+			wantErr: "crl \"test\": validate is enabled but no issuer_ca is provided",
+		},
+		{
+			cfg:     crlConfig{Name: "test", URL: "http://localhost/test.crl", Validate: true, IssuerCA: "some-path-that-does-not-exist"},
+			wantErr: "crl \"test\": cannot load issuer_ca: cannot read certificate file: open some-path-that-does-not-exist: no such file or directory",
+		},
+		{
+			cfg: crlConfig{Name: "test", URLs: []string{"http://localhost/test.crl"}, Validate: true, IssuerCA: "misc/x509/test.crt"},
+		},
+		{
+			cfg:     crlConfig{Name: "test", URL: "http://localhost/test.crl", Schedule: "hourly"},
+			wantErr: "crl \"test\": unsupported schedule \"hourly\"",
+		},
+	}
+	for i, test := range tests {
+		p := new(pool)
+		err := p.addWorker(test.cfg)
+		switch {
+		case err == nil && test.wantErr != "":
+			t.Errorf("%d. pool.addWorker: expected error %q, got nil", i, test.wantErr)
+		case err != nil && err.Error() != test.wantErr:
+			t.Errorf("%d. pool.addWorker: got error %q; want %q", i, err.Error(), test.wantErr)
+		case err == nil && test.cfg.Validate && len(p.workers[0].issuers) != 1:
+			t.Errorf("%d. pool.addWorker: got %d issuers; want 1", i, len(p.workers[0].issuers))
+		}
+	}
+}
+
 func TestPool_StartAll(t *testing.T) {
 	t.Run("Happy Path", testPoolStartAll)
 	t.Run("F5 Clients nil", testPoolStartAllNilClients)
@@ -391,7 +647,7 @@ func testPoolStartAll(t *testing.T) {
 	}
 	f5Client.DisableCertCheck()
 
-	if err := pool.startAll([]*f5.Client{f5Client}, &discardLogger{}); err != nil {
+	if err := pool.startAll([]*bigIP{{name: tsBigIP.URL, client: f5Client}}, &discardLogger{}); err != nil {
 		t.Errorf("pool.startAll: unexpected error %q", err.Error())
 	}
 }
@@ -410,7 +666,7 @@ func testPoolStartAllNilClients(t *testing.T) {
 
 func testPoolStartAllEmptyClients(t *testing.T) {
 	pool := &pool{}
-	if err := pool.startAll([]*f5.Client{}, &discardLogger{}); err == nil {
+	if err := pool.startAll([]*bigIP{}, &discardLogger{}); err == nil {
 		t.Errorf("pool.startAll: expected error, got nil")
 	} else {
 		wantErr := "f5 clients list is empty"
@@ -419,3 +675,118 @@ func testPoolStartAllEmptyClients(t *testing.T) {
 		}
 	}
 }
+
+func TestPool_RunOnce(t *testing.T) {
+	tsBigIP := httptest.NewServer(newBigIPServer())
+	defer tsBigIP.Close()
+	failingBigIP := newBigIPServer()
+	failingBigIP.Disable = "begin_transaction"
+	tsFailingBigIP := httptest.NewServer(failingBigIP)
+	defer tsFailingBigIP.Close()
+
+	tsCA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/test.crl" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(crlFile))
+	}))
+	defer tsCA.Close()
+
+	var bigIPs []*bigIP
+	for _, url := range []string{tsBigIP.URL, tsFailingBigIP.URL} {
+		f5Client, err := f5.NewBasicClient(url, "admin", "admin")
+		if err != nil {
+			t.Fatal("cannot instanciate f5 basic client: ", err)
+		}
+		bigIPs = append(bigIPs, &bigIP{name: url, client: f5Client})
+	}
+
+	p := &pool{}
+	for _, cfg := range []crlConfig{
+		{URL: tsCA.URL + "/test.crl", Name: "test", ProfileName: "clientssl"},
+		{URL: tsCA.URL + "/missing.crl", Name: "missing", ProfileName: "clientssl"},
+	} {
+		if err := p.addWorker(cfg); err != nil {
+			t.Fatal("setup: ", err)
+		}
+	}
+
+	statuses := p.runOnce(bigIPs, &discardLogger{})
+	if got, want := len(statuses), 2; got != want {
+		t.Fatalf("pool.runOnce: got %d statuses; want %d", got, want)
+	}
+	if err := statuses[0].err(0); err != nil {
+		t.Errorf("pool.runOnce: unexpected error %q for the first bigip", err.Error())
+	}
+	if err := statuses[0].err(1); err == nil {
+		t.Error("pool.runOnce: expected error for the second bigip, got nil")
+	}
+	wantErr := "cannot fetch crl due to http error: 404 Not Found"
+	for i := range bigIPs {
+		if err := statuses[1].err(i); err == nil {
+			t.Errorf("pool.runOnce: expected error %q for bigip %d, got nil", wantErr, i)
+		} else if err.Error() != wantErr {
+			t.Errorf("pool.runOnce: got error %q for bigip %d; want %q", err.Error(), i, wantErr)
+		}
+	}
+}
+
+func testWorkerDoSelectionChanged(t *testing.T) {
+	srv := newBigIPServer()
+	srv.otherProfiles = map[string]string{"clientssl-app-1": "/Common/old.crl"}
+	tsBigIP := httptest.NewServer(srv)
+	defer tsBigIP.Close()
+
+	f5Client, err := f5.NewBasicClient(tsBigIP.URL, "admin", "admin")
+	if err != nil {
+		t.Fatal("cannot instanciate f5 basic client: ", err)
+	}
+
+	tsCA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(crlFile))
+	}))
+	defer tsCA.Close()
+
+	w := worker{
+		urls:         []string{tsCA.URL},
+		crlName:      "test",
+		profiles:     profileSelector{patterns: []profilePattern{{kind: clientSSL, glob: "clientssl-app-*"}}},
+		refreshDelay: 300,
+		stopCh:       make(chan struct{}),
+	}
+	bigIPs := []*bigIP{{name: tsBigIP.URL, client: f5Client}}
+	l := new(bufferedLogger)
+	w.do(bigIPs, l)
+
+	// The selection is logged at each run, the CRL is not pushed again as
+	// long as it does not change.
+	w.do(bigIPs, l)
+	if got, want := len(srv.crlFiles), 1; got != want {
+		t.Fatalf("worker.do: got %d uploaded crl files; want %d", got, want)
+	}
+	wantNotice := "crl \"test\" has not changed since last push, skipping"
+	if got := l.GetLastNotice(); got != wantNotice {
+		t.Errorf("worker.do: got notice %q; want %q", got, wantNotice)
+	}
+
+	// A new profile matches the glob while the CRL is not modified.
+	srv.otherProfiles["clientssl-app-2"] = "/Common/old.crl"
+	w.do(bigIPs, l)
+	if err := l.GetLastError(); err != nil {
+		t.Fatalf("worker.do: unexpected error %q", err.Error())
+	}
+	if got, want := len(srv.crlFiles), 2; got != want {
+		t.Fatalf("worker.do: got %d uploaded crl files; want %d", got, want)
+	}
+	for _, name := range []string{"clientssl-app-1", "clientssl-app-2"} {
+		if got, want := srv.otherProfiles[name], srv.crlFiles[1]; got != want {
+			t.Errorf("worker.do: got crl file %q for profile %q; want %q", got, name, want)
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"crypto/x509/pkix"
+	"errors"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/e-XpertSolutions/f5-rest-client/f5"
+	"github.com/e-XpertSolutions/f5-rest-client/f5/ltm"
+)
+
+// profileKind is the kind of SSL profile a CRL is attached to.
+type profileKind string
+
+// Supported kinds of profile.
+const (
+	clientSSL profileKind = "client-ssl"
+	serverSSL profileKind = "server-ssl"
+)
+
+// profileKinds lists the supported kinds of profile, the default one first.
+var profileKinds = []profileKind{clientSSL, serverSSL}
+
+// profileKindRegexp matches what looks like a kind of profile in a profile
+// reference, so that a misspelled kind is reported instead of being taken as
+// part of the profile name.
+var profileKindRegexp = regexp.MustCompile(`^[a-z]+-ssl$`)
+
+// profileRef references a profile, or a pattern of profile names, of a given
+// kind. Once resolved, the name of the profile is its full path, e.g.
+// "/Common/clientssl".
+type profileRef struct {
+	kind profileKind
+	name string
+}
+
+// parseProfileRef parses a profile reference of the form "[kind:]name", where
+// kind is either "client-ssl" (the default) or "server-ssl", e.g.
+// "server-ssl:serverssl-backend".
+func parseProfileRef(s string) (profileRef, error) {
+	i := strings.Index(s, ":")
+	if i < 0 || !profileKindRegexp.MatchString(s[:i]) {
+		return profileRef{kind: clientSSL, name: s}, nil
+	}
+	switch kind := profileKind(s[:i]); kind {
+	case clientSSL, serverSSL:
+		return profileRef{kind: kind, name: s[i+1:]}, nil
+	}
+	return profileRef{}, errors.New("unsupported profile type \"" + s[:i] + "\", must be \"client-ssl\" or \"server-ssl\"")
+}
+
+// String returns a human readable representation of the reference, e.g.
+// `server-ssl profile "serverssl-backend"`.
+func (r profileRef) String() string {
+	return string(r.kind) + " profile \"" + r.name + "\""
+}
+
+// sslProfile is the subset of the client-ssl and server-ssl profile
+// configurations needed to select profiles and attach CRLs to them.
+type sslProfile struct {
+	name     string
+	fullPath string
+	caFiles  []string // names of the trusted CA certificate files
+	crlFile  string   // full path of the CRL file
+}
+
+// newSSLProfile returns a profile whose full path and CRL file are qualified,
+// since the BigIP may omit the default partition.
+func newSSLProfile(name, fullPath, crlFile string, caFiles ...string) sslProfile {
+	if fullPath == "" {
+		fullPath = qualifyName(name)
+	}
+	if !isNone(crlFile) {
+		crlFile = qualifyName(crlFile)
+	}
+	return sslProfile{name: name, fullPath: fullPath, caFiles: caFiles, crlFile: crlFile}
+}
+
+// listProfiles returns all the profiles of the given kind defined on the
+// BigIP.
+func listProfiles(f5Client *f5.Client, kind profileKind) ([]sslProfile, error) {
+	var profiles []sslProfile
+	switch kind {
+	case serverSSL:
+		list, err := ltm.New(f5Client).ProfileServerSSL().ListAll()
+		if err != nil {
+			return nil, errors.New("cannot list server-ssl profiles: " + err.Error())
+		}
+		for _, cfg := range list.Items {
+			profiles = append(profiles, newSSLProfile(cfg.Name, cfg.FullPath, cfg.CRLFile, cfg.CaFile))
+		}
+	default:
+		list, err := ltm.New(f5Client).ProfileClientSSL().ListAll()
+		if err != nil {
+			return nil, errors.New("cannot list client-ssl profiles: " + err.Error())
+		}
+		for _, cfg := range list.Items {
+			profiles = append(profiles, newSSLProfile(cfg.Name, cfg.FullPath, cfg.CRLFile, cfg.CaFile, cfg.ClientCertCa))
+		}
+	}
+	return profiles, nil
+}
+
+// getProfile retrieves the referenced profile.
+func getProfile(f5Client *f5.Client, ref profileRef) (*sslProfile, error) {
+	switch ref.kind {
+	case serverSSL:
+		cfg, err := ltm.New(f5Client).ProfileServerSSL().Get(restName(ref.name))
+		if err != nil {
+			return nil, err
+		}
+		profile := newSSLProfile(cfg.Name, cfg.FullPath, cfg.CRLFile, cfg.CaFile)
+		return &profile, nil
+	default:
+		cfg, err := ltm.New(f5Client).ProfileClientSSL().Get(restName(ref.name))
+		if err != nil {
+			return nil, err
+		}
+		profile := newSSLProfile(cfg.Name, cfg.FullPath, cfg.CRLFile, cfg.CaFile, cfg.ClientCertCa)
+		return &profile, nil
+	}
+}
+
+// setProfileCRLFile attaches the CRL file with the given full path to the
+// referenced profile. The whole configuration of the profile is retrieved and
+// sent back with the new CRL file. It returns the full path of the CRL file
+// previously attached to the profile, or "none".
+func setProfileCRLFile(f5Client *f5.Client, ref profileRef, crlFile string) (string, error) {
+	ltmClient := ltm.New(f5Client)
+	var (
+		previous string
+		err      error
+	)
+	switch ref.kind {
+	case serverSSL:
+		var cfg *ltm.ProfileServerSSLConfig
+		if cfg, err = ltmClient.ProfileServerSSL().Get(restName(ref.name)); err == nil {
+			previous = cfg.CRLFile
+			cfg.CRLFile = crlFile
+			if err = ltmClient.ProfileServerSSL().Edit(restName(ref.name), *cfg); err != nil {
+				return "", errors.New("cannot modify " + ref.String() + ": " + err.Error())
+			}
+		}
+	default:
+		var cfg *ltm.ProfileClientSSLConfig
+		if cfg, err = ltmClient.ProfileClientSSL().Get(restName(ref.name)); err == nil {
+			previous = cfg.CRLFile
+			cfg.CRLFile = crlFile
+			if err = ltmClient.ProfileClientSSL().Edit(restName(ref.name), *cfg); err != nil {
+				return "", errors.New("cannot modify " + ref.String() + ": " + err.Error())
+			}
+		}
+	}
+	if err != nil {
+		return "", errors.New("cannot get " + ref.String() + ": " + err.Error())
+	}
+	if isNone(previous) {
+		return "none", nil
+	}
+	return qualifyName(previous), nil
+}
+
+// profilePattern selects the profiles of a given kind whose name matches a
+// glob or a regular expression.
+type profilePattern struct {
+	kind   profileKind
+	glob   string
+	regexp *regexp.Regexp
+}
+
+// matches reports whether the profile name matches the pattern.
+func (p profilePattern) matches(name string) bool {
+	if p.regexp != nil {
+		return p.regexp.MatchString(name)
+	}
+	ok, _ := path.Match(p.glob, name)
+	return ok
+}
+
+// String returns a human readable representation of the pattern, e.g.
+// `client-ssl glob "clientssl-*"`.
+func (p profilePattern) String() string {
+	if p.regexp != nil {
+		return string(p.kind) + " regex \"" + p.regexp.String() + "\""
+	}
+	return string(p.kind) + " glob \"" + p.glob + "\""
+}
+
+// profileSelector selects the client-ssl and server-ssl profiles a CRL is
+// attached to, either by name, by matching the names of the profiles defined on
+// the BigIP against a glob or a regular expression, or by discovering the
+// client-ssl profiles that trust the CA that issued the CRL.
+type profileSelector struct {
+	refs     []profileRef
+	patterns []profilePattern
+	discover bool
+}
+
+// newProfileSelector returns the selector of the profiles defined by the CRL
+// configuration.
+func newProfileSelector(cfg crlConfig) (profileSelector, error) {
+	s := profileSelector{discover: cfg.DiscoverProfiles}
+	for _, name := range cfg.profileNames() {
+		ref, err := parseProfileRef(name)
+		if err != nil {
+			return profileSelector{}, errors.New("invalid profile reference \"" + name + "\": " + err.Error())
+		}
+		ref.name = qualifyName(ref.name)
+		s.refs = append(s.refs, ref)
+	}
+	if cfg.ProfileGlob != "" {
+		ref, err := parseProfileRef(cfg.ProfileGlob)
+		if err != nil {
+			return profileSelector{}, errors.New("invalid profile_glob: " + err.Error())
+		}
+		s.patterns = append(s.patterns, profilePattern{kind: ref.kind, glob: ref.name})
+	}
+	if cfg.ProfileRegex != "" {
+		ref, err := parseProfileRef(cfg.ProfileRegex)
+		if err != nil {
+			return profileSelector{}, errors.New("invalid profile_regex: " + err.Error())
+		}
+		re, err := regexp.Compile(ref.name)
+		if err != nil {
+			return profileSelector{}, errors.New("invalid profile_regex: " + err.Error())
+		}
+		s.patterns = append(s.patterns, profilePattern{kind: ref.kind, regexp: re})
+	}
+	return s, nil
+}
+
+// hasPattern reports whether the selector needs the list of the profiles
+// defined on the BigIP to be resolved.
+func (s profileSelector) hasPattern() bool {
+	return len(s.patterns) > 0 || s.discover
+}
+
+// lists reports whether the profiles of the given kind defined on the BigIP
+// must be listed to resolve the selector.
+func (s profileSelector) lists(kind profileKind) bool {
+	if s.discover && kind == clientSSL {
+		return true
+	}
+	for _, p := range s.patterns {
+		if p.kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the name of a profile of the given kind matches one
+// of the patterns of the selector.
+func (s profileSelector) matches(kind profileKind, name string) bool {
+	for _, p := range s.patterns {
+		if p.kind == kind && p.matches(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns a human readable representation of the selector.
+func (s profileSelector) String() string {
+	var parts []string
+	for _, ref := range s.refs {
+		parts = append(parts, ref.String())
+	}
+	for _, p := range s.patterns {
+		parts = append(parts, p.String())
+	}
+	if s.discover {
+		parts = append(parts, "client-ssl trusted ca")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// resolve returns the profiles selected on the BigIP for the given CRL, sorted
+// by kind and full path. The profiles of a kind are only listed when the
+// selector defines a pattern for this kind. An error is returned if no profile
+// is selected.
+//
+// Discovery failures are logged rather than returned, so that they do not
+// prevent the CRL from being pushed to the other profiles: a profile whose
+// certificates cannot be read is skipped, and so is the discovery as a whole if
+// the CRL has no usable authority key identifier.
+func (s profileSelector) resolve(f5Client *f5.Client, crl *pkix.CertificateList, l logger) ([]profileRef, error) {
+	selected := make(map[profileRef]bool)
+	for _, ref := range s.refs {
+		selected[ref] = true
+	}
+	for _, kind := range profileKinds {
+		if !s.lists(kind) {
+			continue
+		}
+		profiles, err := listProfiles(f5Client, kind)
+		if err != nil {
+			return nil, err
+		}
+		var discoverer *caDiscoverer
+		if s.discover && kind == clientSSL {
+			if discoverer, err = newCADiscoverer(f5Client, crl); err != nil {
+				l.Error("cannot discover client-ssl profiles: ", err)
+			}
+		}
+		for _, profile := range profiles {
+			ref := profileRef{kind: kind, name: profile.fullPath}
+			if s.matches(kind, profile.name) || s.matches(kind, profile.fullPath) {
+				selected[ref] = true
+				continue
+			}
+			if discoverer == nil {
+				continue
+			}
+			trusted, err := discoverer.trustsCRLIssuer(profile)
+			if err != nil {
+				l.Error("cannot discover client-ssl profiles: skipping ", profile.fullPath, ": ", err)
+				continue
+			}
+			if trusted {
+				selected[ref] = true
+			}
+		}
+	}
+	if len(selected) == 0 {
+		return nil, errors.New("no profile matches " + s.String())
+	}
+
+	refs := make([]profileRef, 0, len(selected))
+	for ref := range selected {
+		refs = append(refs, ref)
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].kind != refs[j].kind {
+			return refs[i].kind < refs[j].kind
+		}
+		return refs[i].name < refs[j].name
+	})
+	return refs, nil
+}
+
+// formatProfiles returns a comma separated list of the referenced profiles.
+func formatProfiles(refs []profileRef) string {
+	parts := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		parts = append(parts, ref.String())
+	}
+	return strings.Join(parts, ", ")
+}
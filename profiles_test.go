@@ -0,0 +1,150 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/e-XpertSolutions/f5-rest-client/f5"
+)
+
+func TestProfileSelector_Matches(t *testing.T) {
+	s := profileSelector{
+		patterns: []profilePattern{
+			{kind: clientSSL, glob: "clientssl-app-*"},
+			{kind: serverSSL, regexp: regexp.MustCompile(`^mtls-(a|b)$`)},
+		},
+	}
+	tests := []struct {
+		kind profileKind
+		name string
+		want bool
+	}{
+		{clientSSL, "clientssl-app-1", true},
+		{clientSSL, "clientssl-web", false},
+		{serverSSL, "clientssl-app-1", false},
+		{serverSSL, "mtls-a", true},
+		{serverSSL, "mtls-c", false},
+		{clientSSL, "mtls-a", false},
+	}
+	for i, test := range tests {
+		if got := s.matches(test.kind, test.name); got != test.want {
+			t.Errorf("%d. profileSelector.matches(%q, %q): got %v; want %v", i, test.kind, test.name, got, test.want)
+		}
+	}
+}
+
+func TestProfileSelector_Resolve(t *testing.T) {
+	srv := newBigIPServer()
+	srv.otherProfiles = map[string]string{
+		"clientssl-app-1": "",
+		"clientssl-app-2": "",
+		"clientssl-web":   "",
+		"/TenantA/app":    "",
+	}
+	srv.serverProfiles = map[string]string{
+		"serverssl-app-1": "",
+		"serverssl-web":   "",
+	}
+	tsBigIP := httptest.NewServer(srv)
+	defer tsBigIP.Close()
+
+	f5Client, err := f5.NewBasicClient(tsBigIP.URL, "admin", "admin")
+	if err != nil {
+		t.Fatal("cannot instanciate f5 basic client: ", err)
+	}
+
+	tests := []struct {
+		selector profileSelector
+		want     []profileRef
+		wantErr  string
+	}{
+		{
+			selector: profileSelector{refs: []profileRef{{clientSSL, "/Common/clientssl"}}},
+			want:     []profileRef{{clientSSL, "/Common/clientssl"}},
+		},
+		{
+			selector: profileSelector{
+				refs:     []profileRef{{clientSSL, "/Common/clientssl-web"}},
+				patterns: []profilePattern{{kind: clientSSL, glob: "clientssl-app-*"}},
+			},
+			want: []profileRef{{clientSSL, "/Common/clientssl-app-1"}, {clientSSL, "/Common/clientssl-app-2"}, {clientSSL, "/Common/clientssl-web"}},
+		},
+		{
+			selector: profileSelector{patterns: []profilePattern{{kind: clientSSL, regexp: regexp.MustCompile(`^clientssl(-web)?$`)}}},
+			want:     []profileRef{{clientSSL, "/Common/clientssl"}, {clientSSL, "/Common/clientssl-web"}},
+		},
+		{
+			selector: profileSelector{
+				refs:     []profileRef{{serverSSL, "/Common/serverssl-web"}},
+				patterns: []profilePattern{{kind: serverSSL, glob: "*-app-*"}},
+			},
+			want: []profileRef{{serverSSL, "/Common/serverssl-app-1"}, {serverSSL, "/Common/serverssl-web"}},
+		},
+		{
+			selector: profileSelector{patterns: []profilePattern{{kind: clientSSL, glob: "/TenantA/*"}}},
+			want:     []profileRef{{clientSSL, "/TenantA/app"}},
+		},
+		{
+			selector: profileSelector{patterns: []profilePattern{{kind: clientSSL, glob: "serverssl-*"}}},
+			wantErr:  "no profile matches client-ssl glob \"serverssl-*\"",
+		},
+	}
+	for i, test := range tests {
+		got, err := test.selector.resolve(f5Client, nil, discardLogger{})
+		if test.wantErr != "" {
+			if err == nil {
+				t.Errorf("%d. profileSelector.resolve: expected error %q, got nil", i, test.wantErr)
+			} else if err.Error() != test.wantErr {
+				t.Errorf("%d. profileSelector.resolve: got error %q; want %q", i, err.Error(), test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d. profileSelector.resolve: unexpected error %q", i, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%d. profileSelector.resolve: got %q; want %q", i, got, test.want)
+		}
+	}
+
+	srv.Disable = "server-ssl_list"
+	if _, err := (profileSelector{patterns: []profilePattern{{kind: serverSSL, glob: "*"}}}).resolve(f5Client, nil, discardLogger{}); err == nil {
+		t.Error("profileSelector.resolve: expected error, got nil")
+	}
+}
+
+func TestParseProfileRef(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    profileRef
+		wantErr string
+	}{
+		{in: "clientssl", want: profileRef{clientSSL, "clientssl"}},
+		{in: "client-ssl:clientssl", want: profileRef{clientSSL, "clientssl"}},
+		{in: "server-ssl:/Common/serverssl", want: profileRef{serverSSL, "/Common/serverssl"}},
+		{in: "server-ssl:^serverssl-[0-9]+$", want: profileRef{serverSSL, "^serverssl-[0-9]+$"}},
+		{in: "(a|b):c", want: profileRef{clientSSL, "(a|b):c"}},
+		{in: "sever-ssl:serverssl", wantErr: "unsupported profile type \"sever-ssl\", must be \"client-ssl\" or \"server-ssl\""},
+	}
+	for _, test := range tests {
+		got, err := parseProfileRef(test.in)
+		if test.wantErr != "" {
+			if err == nil {
+				t.Errorf("parseProfileRef(%q): expected error %q, got nil", test.in, test.wantErr)
+			} else if err.Error() != test.wantErr {
+				t.Errorf("parseProfileRef(%q): got error %q; want %q", test.in, err.Error(), test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseProfileRef(%q): unexpected error %q", test.in, err.Error())
+			continue
+		}
+		if got != test.want {
+			t.Errorf("parseProfileRef(%q): got %+v; want %+v", test.in, got, test.want)
+		}
+	}
+}
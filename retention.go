@@ -0,0 +1,127 @@
+package main
+
+import (
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/e-XpertSolutions/f5-rest-client/f5"
+	"github.com/e-XpertSolutions/f5-rest-client/f5/sys"
+)
+
+// uploadedCRL describes a CRL file uploaded on a BigIP by a worker.
+type uploadedCRL struct {
+	name      string // full path of the file
+	createdAt time.Time
+}
+
+// parseCRLFileName extracts the upload time from the name of a CRL file
+// created by pushCRLToClients, i.e. "<prefix>_<unix timestamp>.crl". It
+// reports false if name does not follow that format.
+func parseCRLFileName(prefix, name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, prefix+"_") || !strings.HasSuffix(name, ".crl") {
+		return time.Time{}, false
+	}
+	ts := strings.TrimSuffix(strings.TrimPrefix(name, prefix+"_"), ".crl")
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(sec, 0), true
+}
+
+// hasRetentionPolicy reports whether the worker is configured to delete
+// superseded CRL files.
+func (w *worker) hasRetentionPolicy() bool {
+	return w.keepLast > 0 || w.maxAge > 0
+}
+
+// selectSupersededCRLFiles returns the files that are not retained by the
+// retention policy of the worker. When both keepLast and maxAge are set, a file
+// is retained as long as one of them says so. Files listed in inUse are never
+// returned.
+func (w *worker) selectSupersededCRLFiles(files []uploadedCRL, inUse map[string]bool, now time.Time) []uploadedCRL {
+	sorted := make([]uploadedCRL, len(files))
+	copy(sorted, files)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].createdAt.After(sorted[j].createdAt)
+	})
+
+	var superseded []uploadedCRL
+	for i, f := range sorted {
+		if inUse[f.name] {
+			continue
+		}
+		if w.keepLast > 0 && i < w.keepLast {
+			continue
+		}
+		if w.maxAge > 0 && now.Sub(f.createdAt) <= w.maxAge {
+			continue
+		}
+		superseded = append(superseded, f)
+	}
+	return superseded
+}
+
+// referencedCRLFiles returns the full paths of all CRL files referenced by a
+// client SSL or server SSL profile of the BigIP.
+func referencedCRLFiles(f5Client *f5.Client) (map[string]bool, error) {
+	inUse := make(map[string]bool)
+	for _, kind := range profileKinds {
+		profiles, err := listProfiles(f5Client, kind)
+		if err != nil {
+			return nil, err
+		}
+		for _, profile := range profiles {
+			if isNone(profile.crlFile) {
+				continue
+			}
+			inUse[profile.crlFile] = true
+		}
+	}
+	return inUse, nil
+}
+
+// pruneCRLFiles deletes the CRL files previously uploaded by the worker that
+// are not retained anymore by its retention policy. Files still referenced by
+// a client SSL or server SSL profile are never deleted. Errors are only logged
+// since they do not affect the CRL that has just been pushed.
+func (w *worker) pruneCRLFiles(f5Client *f5.Client, l logger) {
+	if !w.hasRetentionPolicy() {
+		return
+	}
+
+	inUse, err := referencedCRLFiles(f5Client)
+	if err != nil {
+		l.Error("cannot prune crl files: ", err)
+		return
+	}
+
+	sysClient := sys.New(f5Client)
+	list, err := sysClient.FileSSLCRL().ListAll()
+	if err != nil {
+		l.Error("cannot prune crl files: cannot list crl files: ", err)
+		return
+	}
+	var files []uploadedCRL
+	for _, item := range list.Items {
+		fullPath := qualifyName(item.FullPath)
+		if path.Dir(fullPath) != "/"+w.crlPartition() {
+			continue
+		}
+		if createdAt, ok := parseCRLFileName(w.crlName, path.Base(fullPath)); ok {
+			files = append(files, uploadedCRL{name: fullPath, createdAt: createdAt})
+		}
+	}
+
+	for _, f := range w.selectSupersededCRLFiles(files, inUse, time.Now()) {
+		fl := l.With(fieldCRLFile, f.name)
+		if err := sysClient.FileSSLCRL().Delete(restName(f.name)); err != nil {
+			fl.Error("cannot delete crl file \"", f.name, "\": ", err)
+			continue
+		}
+		fl.Notice("superseded crl file \"", f.name, "\" deleted")
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/e-XpertSolutions/f5-rest-client/f5"
+)
+
+func TestParseCRLFileName(t *testing.T) {
+	tests := []struct {
+		name   string
+		want   time.Time
+		wantOk bool
+	}{
+		{name: "test_1504526650.crl", want: time.Unix(1504526650, 0), wantOk: true},
+		{name: "test_abc.crl"},
+		{name: "test_1504526650"},
+		{name: "other_1504526650.crl"},
+		{name: "test_other_1504526650.crl"},
+		{name: "test.crl"},
+	}
+	for i, test := range tests {
+		got, ok := parseCRLFileName("test", test.name)
+		if ok != test.wantOk {
+			t.Errorf("%d. parseCRLFileName(%q): got ok %v; want %v", i, test.name, ok, test.wantOk)
+			continue
+		}
+		if !got.Equal(test.want) {
+			t.Errorf("%d. parseCRLFileName(%q): got %v; want %v", i, test.name, got, test.want)
+		}
+	}
+}
+
+func TestWorker_SelectSupersededCRLFiles(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	files := []uploadedCRL{
+		{name: "a", createdAt: now.Add(-4 * time.Hour)},
+		{name: "b", createdAt: now.Add(-1 * time.Hour)},
+		{name: "c", createdAt: now.Add(-3 * time.Hour)},
+		{name: "d", createdAt: now.Add(-2 * time.Hour)},
+	}
+	tests := []struct {
+		keepLast int
+		maxAge   time.Duration
+		inUse    map[string]bool
+		want     []string
+	}{
+		{keepLast: 2, want: []string{"c", "a"}},
+		{keepLast: 2, inUse: map[string]bool{"a": true}, want: []string{"c"}},
+		{maxAge: 150 * time.Minute, want: []string{"c", "a"}},
+		{keepLast: 1, maxAge: 150 * time.Minute, want: []string{"c", "a"}},
+		{keepLast: 3, maxAge: 30 * time.Minute, want: []string{"a"}},
+		{keepLast: 10, want: nil},
+	}
+	for i, test := range tests {
+		w := worker{keepLast: test.keepLast, maxAge: test.maxAge}
+		var got []string
+		for _, f := range w.selectSupersededCRLFiles(files, test.inUse, now) {
+			got = append(got, f.name)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%d. worker.selectSupersededCRLFiles: got %v; want %v", i, got, test.want)
+		}
+	}
+}
+
+func TestWorker_PruneCRLFiles(t *testing.T) {
+	t.Run("Happy Path", testWorkerPruneCRLFilesHappyPath)
+	t.Run("Fail List Client SSL", testWorkerPruneCRLFilesFailListClientSSL)
+	t.Run("Fail Delete", testWorkerPruneCRLFilesFailDelete)
+	t.Run("Partition", testWorkerPruneCRLFilesPartition)
+}
+
+func newPruneTestServer() *bigIPServer {
+	srv := newBigIPServer()
+	srv.crlFile = "/Common/test_1500000003.crl"
+	srv.crlFiles = []string{
+		"/Common/test_1500000001.crl",
+		"/Common/test_1500000002.crl",
+		"/Common/test_1500000003.crl",
+		"/Common/other_1500000000.crl",
+		"/Common/test.crl",
+		"/TenantA/test_1500000000.crl",
+	}
+	return srv
+}
+
+func testWorkerPruneCRLFilesHappyPath(t *testing.T) {
+	srv := newPruneTestServer()
+	tsBigIP := httptest.NewServer(srv)
+	defer tsBigIP.Close()
+
+	f5Client, err := f5.NewBasicClient(tsBigIP.URL, "admin", "admin")
+	if err != nil {
+		t.Fatal("cannot instanciate f5 basic client: ", err)
+	}
+
+	// The most recent file is referenced by the client-ssl profile and must be
+	// kept even if it is not retained by the retention policy.
+	w := worker{crlName: "test", keepLast: 1, maxAge: time.Nanosecond}
+	l := new(bufferedLogger)
+	w.pruneCRLFiles(f5Client, l)
+	if err := l.GetLastError(); err != nil {
+		t.Errorf("worker.pruneCRLFiles: unexpected error %q", err.Error())
+	}
+	want := []string{"/Common/test_1500000003.crl", "/Common/other_1500000000.crl", "/Common/test.crl", "/TenantA/test_1500000000.crl"}
+	if !reflect.DeepEqual(srv.crlFiles, want) {
+		t.Errorf("worker.pruneCRLFiles: got remaining files %v; want %v", srv.crlFiles, want)
+	}
+}
+
+func testWorkerPruneCRLFilesFailListClientSSL(t *testing.T) {
+	srv := newPruneTestServer()
+	srv.Disable = "client-ssl_list"
+	tsBigIP := httptest.NewServer(srv)
+	defer tsBigIP.Close()
+
+	f5Client, err := f5.NewBasicClient(tsBigIP.URL, "admin", "admin")
+	if err != nil {
+		t.Fatal("cannot instanciate f5 basic client: ", err)
+	}
+
+	w := worker{crlName: "test", keepLast: 1}
+	l := new(bufferedLogger)
+	w.pruneCRLFiles(f5Client, l)
+	wantErr := "cannot prune crl files: cannot list client-ssl profiles: http response error: 404 Not Found"
+	if err := l.GetLastError(); err == nil {
+		t.Error("worker.pruneCRLFiles: expected error, got nil")
+	} else if err.Error() != wantErr {
+		t.Errorf("worker.pruneCRLFiles: got error %q; want %q", err.Error(), wantErr)
+	}
+	if got := len(srv.crlFiles); got != 6 {
+		t.Errorf("worker.pruneCRLFiles: got %d remaining files; want %d", got, 6)
+	}
+}
+
+func testWorkerPruneCRLFilesFailDelete(t *testing.T) {
+	srv := newPruneTestServer()
+	srv.Disable = "ssl-crl_delete"
+	tsBigIP := httptest.NewServer(srv)
+	defer tsBigIP.Close()
+
+	f5Client, err := f5.NewBasicClient(tsBigIP.URL, "admin", "admin")
+	if err != nil {
+		t.Fatal("cannot instanciate f5 basic client: ", err)
+	}
+
+	w := worker{crlName: "test", keepLast: 1}
+	l := new(bufferedLogger)
+	w.pruneCRLFiles(f5Client, l)
+	wantErr := "cannot delete crl file \"/Common/test_1500000001.crl\": http response error: 404 Not Found"
+	if err := l.GetLastError(); err == nil {
+		t.Error("worker.pruneCRLFiles: expected error, got nil")
+	} else if err.Error() != wantErr {
+		t.Errorf("worker.pruneCRLFiles: got error %q; want %q", err.Error(), wantErr)
+	}
+}
+
+func testWorkerPruneCRLFilesPartition(t *testing.T) {
+	srv := newPruneTestServer()
+	tsBigIP := httptest.NewServer(srv)
+	defer tsBigIP.Close()
+
+	f5Client, err := f5.NewBasicClient(tsBigIP.URL, "admin", "admin")
+	if err != nil {
+		t.Fatal("cannot instanciate f5 basic client: ", err)
+	}
+
+	// Only the files of the partition of the worker are considered.
+	w := worker{crlName: "test", partition: "TenantA", maxAge: time.Nanosecond}
+	l := new(bufferedLogger)
+	w.pruneCRLFiles(f5Client, l)
+	if err := l.GetLastError(); err != nil {
+		t.Errorf("worker.pruneCRLFiles: unexpected error %q", err.Error())
+	}
+	want := []string{
+		"/Common/test_1500000001.crl",
+		"/Common/test_1500000002.crl",
+		"/Common/test_1500000003.crl",
+		"/Common/other_1500000000.crl",
+		"/Common/test.crl",
+	}
+	if !reflect.DeepEqual(srv.crlFiles, want) {
+		t.Errorf("worker.pruneCRLFiles: got remaining files %v; want %v", srv.crlFiles, want)
+	}
+}
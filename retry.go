@@ -0,0 +1,67 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Default values of the retry policy, used when only the maximum number of
+// attempts is configured.
+const (
+	defaultInitialBackoff = 30 * time.Second
+	defaultMaxBackoff     = 10 * time.Minute
+)
+
+// retryPolicy defines how a failed operation is retried.
+type retryPolicy struct {
+	// maxAttempts is the maximum number of attempts, including the first
+	// one. Values lower than 2 disable retries.
+	maxAttempts int
+
+	// initialBackoff is the delay before the first retry. It is doubled at
+	// each subsequent retry up to maxBackoff.
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+
+	// jitter is the maximum random delay added to each backoff.
+	jitter time.Duration
+}
+
+// backoff returns the delay to wait before the n-th retry, n starting at 1.
+func (p retryPolicy) backoff(n int) time.Duration {
+	delay := p.initialBackoff
+	for i := 1; i < n && (p.maxBackoff <= 0 || delay < p.maxBackoff); i++ {
+		delay *= 2
+	}
+	if p.maxBackoff > 0 && delay > p.maxBackoff {
+		delay = p.maxBackoff
+	}
+	if p.jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(p.jitter)))
+	}
+	return delay
+}
+
+// do calls fn until it succeeds or the maximum number of attempts is reached,
+// waiting between attempts as defined by the policy. onFailure, if not nil, is
+// called after each failed attempt that is going to be retried, along with the
+// delay before the next attempt. Retries are abandoned as soon as stop is
+// closed or receives a value. The error returned by the last attempt is
+// returned.
+func (p retryPolicy) do(stop <-chan struct{}, onFailure func(err error, attempt int, delay time.Duration), fn func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil || attempt >= p.maxAttempts {
+			return err
+		}
+		delay := p.backoff(attempt)
+		if onFailure != nil {
+			onFailure(err, attempt, delay)
+		}
+		select {
+		case <-time.After(delay):
+		case <-stop:
+			return err
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/e-XpertSolutions/f5-rest-client/f5"
+)
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := retryPolicy{initialBackoff: time.Second, maxBackoff: 5 * time.Second}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		if got := p.backoff(i + 1); got != w {
+			t.Errorf("retryPolicy.backoff(%d): got %v; want %v", i+1, got, w)
+		}
+	}
+
+	p.jitter = time.Second
+	for i := 0; i < 100; i++ {
+		if got := p.backoff(1); got < time.Second || got >= 2*time.Second {
+			t.Fatalf("retryPolicy.backoff(1): got %v; want between %v and %v", got, time.Second, 2*time.Second)
+		}
+	}
+}
+
+func TestRetryPolicy_Do(t *testing.T) {
+	p := retryPolicy{maxAttempts: 3, initialBackoff: time.Millisecond}
+
+	var calls, failures int
+	onFailure := func(error, int, time.Duration) { failures++ }
+
+	// Succeeds at the second attempt.
+	err := p.do(nil, onFailure, func() error {
+		calls++
+		if calls < 2 {
+			return errors.New("failure")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("retryPolicy.do: unexpected error %q", err.Error())
+	}
+	if calls != 2 || failures != 1 {
+		t.Errorf("retryPolicy.do: got %d calls and %d failures; want 2 and 1", calls, failures)
+	}
+
+	// Always fails.
+	calls, failures = 0, 0
+	err = p.do(nil, onFailure, func() error {
+		calls++
+		return errors.New("failure")
+	})
+	if err == nil || err.Error() != "failure" {
+		t.Errorf("retryPolicy.do: got error %v; want %q", err, "failure")
+	}
+	if calls != 3 || failures != 2 {
+		t.Errorf("retryPolicy.do: got %d calls and %d failures; want 3 and 2", calls, failures)
+	}
+
+	// Retries are disabled.
+	calls = 0
+	retryPolicy{}.do(nil, nil, func() error {
+		calls++
+		return errors.New("failure")
+	})
+	if calls != 1 {
+		t.Errorf("retryPolicy.do: got %d calls; want 1", calls)
+	}
+
+	// Stopped while waiting.
+	stop := make(chan struct{})
+	close(stop)
+	calls = 0
+	p.initialBackoff = time.Hour
+	p.do(stop, nil, func() error {
+		calls++
+		return errors.New("failure")
+	})
+	if calls != 1 {
+		t.Errorf("retryPolicy.do: got %d calls after stop; want 1", calls)
+	}
+}
+
+func TestWorker_DoWithRetry(t *testing.T) {
+	srv := newBigIPServer()
+	srv.failTransactions = 2
+	tsBigIP := httptest.NewServer(srv)
+	defer tsBigIP.Close()
+
+	f5Client, err := f5.NewBasicClient(tsBigIP.URL, "admin", "admin")
+	if err != nil {
+		t.Fatal("cannot instanciate f5 basic client: ", err)
+	}
+
+	var totalRequests int
+	tsCA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		totalRequests++
+		if totalRequests == 1 {
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(crlFile))
+	}))
+	defer tsCA.Close()
+
+	w := worker{
+		urls:     []string{tsCA.URL},
+		crlName:  "test",
+		profiles: profileSelector{refs: []profileRef{{clientSSL, "/Common/clientssl"}}},
+		retry:    retryPolicy{maxAttempts: 3, initialBackoff: time.Millisecond},
+		stopCh:   make(chan struct{}),
+	}
+	b := &bigIP{name: "bigip", client: f5Client}
+	l := new(bufferedLogger)
+	w.do([]*bigIP{b}, l)
+	if totalRequests != 2 {
+		t.Errorf("worker.do: got %d requests to the crl distribution point; want %d", totalRequests, 2)
+	}
+	if _, ok := w.lastPushed(b); !ok {
+		t.Error("worker.do: crl has not been pushed")
+	}
+	wantNotice := "crl \"test\": push attempt 2/3 failed, retrying in 2ms"
+	if got := l.GetLastNotice(); got != wantNotice {
+		t.Errorf("worker.do: got notice %q; want %q", got, wantNotice)
+	}
+}
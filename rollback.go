@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/e-XpertSolutions/f5-rest-client/f5"
+	"github.com/e-XpertSolutions/f5-rest-client/f5/sys"
+)
+
+// hasCRLFile reports whether the ssl-crl file with the given full path exists
+// on the BigIP.
+func hasCRLFile(f5Client *f5.Client, fullPath string) (bool, error) {
+	list, err := sys.New(f5Client).FileSSLCRL().ListAll()
+	if err != nil {
+		return false, errors.New("cannot list crl files: " + err.Error())
+	}
+	for _, item := range list.Items {
+		if qualifyName(item.FullPath) == fullPath {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// rollbackPush undoes a push whose verification failed: the crl files
+// previously attached to the profiles are restored in a new transaction, then
+// the crl file uploaded by the push is deleted. The uploaded file is kept if
+// the profiles cannot be restored since some of them may still reference it.
+// Every step is logged.
+func (w *worker) rollbackPush(b *bigIP, profiles []profileRef, previous []string, crlPath string, l logger) error {
+	prefix := "crl \"" + w.crlName + "\": rollback on " + b.name + ": "
+
+	tx, err := b.client.Begin()
+	if err != nil {
+		l.Error(prefix, err)
+		return err
+	}
+	l = l.With(fieldTxID, tx.TxID())
+	for i, ref := range profiles {
+		l.Notice(prefix, "restoring crl file \"", previous[i], "\" on ", ref)
+		if _, err := setProfileCRLFile(tx, ref, previous[i]); err != nil {
+			l.Error(prefix, err)
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		l.Error(prefix, err)
+		return err
+	}
+	l.Notice(prefix, "previous crl files restored on ", formatProfiles(profiles))
+
+	exists, err := hasCRLFile(b.client, crlPath)
+	if err != nil {
+		l.Error(prefix, err)
+		return err
+	}
+	if !exists {
+		l.Notice(prefix, "crl file \"", crlPath, "\" does not exist, nothing to delete")
+		return nil
+	}
+	if err := sys.New(b.client).FileSSLCRL().Delete(restName(crlPath)); err != nil {
+		err = errors.New("cannot delete crl file \"" + crlPath + "\": " + err.Error())
+		l.Error(prefix, err)
+		return err
+	}
+	l.Notice(prefix, "orphaned crl file \"", crlPath, "\" deleted")
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/e-XpertSolutions/f5-rest-client/f5"
+)
+
+func TestWorker_DoRollback(t *testing.T) {
+	tsCA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(crlFile))
+	}))
+	defer tsCA.Close()
+
+	tests := []struct {
+		name    string
+		disable string
+		wantErr string
+	}{
+		{
+			name:    "Profile Not Updated",
+			disable: "client-ssl_put_ignored_once",
+			wantErr: "client-ssl profile \"/Common/clientssl\" has not been updated with the newly updated crl",
+		},
+		{
+			name:    "Missing CRL File",
+			disable: "ssl-crl_lost",
+			wantErr: "crl file \"/Common/test_",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := newBigIPServer()
+			srv.Disable = test.disable
+			srv.crlFile = "/Common/test_1500000000.crl"
+			srv.crlFiles = []string{"/Common/test_1500000000.crl"}
+			tsBigIP := httptest.NewServer(srv)
+			defer tsBigIP.Close()
+
+			f5Client, err := f5.NewBasicClient(tsBigIP.URL, "admin", "admin")
+			if err != nil {
+				t.Fatal("cannot instanciate f5 basic client: ", err)
+			}
+
+			w := worker{
+				urls:     []string{tsCA.URL},
+				crlName:  "test",
+				profiles: profileSelector{refs: []profileRef{{clientSSL, "/Common/clientssl"}}},
+				stopCh:   make(chan struct{}),
+			}
+			l := new(bufferedLogger)
+			w.do([]*bigIP{{name: tsBigIP.URL, client: f5Client}}, l)
+			if err := l.GetLastError(); err == nil {
+				t.Errorf("worker.do: expected error %q, got nil", test.wantErr)
+			} else if !strings.HasPrefix(err.Error(), test.wantErr) {
+				t.Errorf("worker.do: got error %q; want prefix %q", err.Error(), test.wantErr)
+			}
+
+			if want := "/Common/test_1500000000.crl"; srv.crlFile != want {
+				t.Errorf("worker.do: profile crl file is %q after rollback; want %q", srv.crlFile, want)
+			}
+			if len(srv.crlFiles) != 1 || srv.crlFiles[0] != "/Common/test_1500000000.crl" {
+				t.Errorf("worker.do: crl files after rollback are %q; want only the previous one", srv.crlFiles)
+			}
+		})
+	}
+}
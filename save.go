@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/e-XpertSolutions/f5-rest-client/f5"
+)
+
+// saveBatchDelay is the time during which the requests to save the
+// configuration of a BigIP are batched together.
+var saveBatchDelay = 2 * time.Second
+
+// saveSysConfig saves the running configuration of the BigIP, i.e. runs the
+// equivalent of "tmsh save sys config".
+func saveSysConfig(f5Client *f5.Client) error {
+	cmd := map[string]string{"command": "save"}
+	if err := f5Client.ModQuery("POST", "/mgmt/tm/sys/config", cmd); err != nil {
+		return errors.New("cannot save sys config: " + err.Error())
+	}
+	return nil
+}
+
+// saveError reports that the configuration of a BigIP could not be saved once
+// the CRL has been pushed. It is reported separately from push errors since the
+// CRL is live on the BigIP, only not persisted yet.
+type saveError struct {
+	err error
+}
+
+func (e *saveError) Error() string {
+	return e.err.Error()
+}
+
+// configSaver saves the running configuration of a BigIP on behalf of the
+// workers. The requests made while a save is pending are batched, so that
+// workers completing their push together lead to a single save.
+type configSaver struct {
+	mu      sync.Mutex
+	pending *saveBatch
+}
+
+// saveBatch is a save shared by several requests.
+type saveBatch struct {
+	done chan struct{} // closed once the save completed
+	err  error
+}
+
+// save requests the configuration of the BigIP to be saved and waits for the
+// save to complete. The save is performed saveBatchDelay after the first
+// request of the batch, requests made while a save is in progress starting a
+// new batch since their changes may not be included.
+func (s *configSaver) save(f5Client *f5.Client) error {
+	s.mu.Lock()
+	batch := s.pending
+	if batch == nil {
+		batch = &saveBatch{done: make(chan struct{})}
+		s.pending = batch
+		go func() {
+			time.Sleep(saveBatchDelay)
+			s.mu.Lock()
+			s.pending = nil
+			s.mu.Unlock()
+			batch.err = saveSysConfig(f5Client)
+			close(batch.done)
+		}()
+	}
+	s.mu.Unlock()
+
+	<-batch.done
+	return batch.err
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/e-XpertSolutions/f5-rest-client/f5"
+)
+
+func TestConfigSaver_Save(t *testing.T) {
+	defer func(d time.Duration) { saveBatchDelay = d }(saveBatchDelay)
+	saveBatchDelay = 20 * time.Millisecond
+
+	srv := newBigIPServer()
+	tsBigIP := httptest.NewServer(srv)
+	defer tsBigIP.Close()
+
+	f5Client, err := f5.NewBasicClient(tsBigIP.URL, "admin", "admin")
+	if err != nil {
+		t.Fatal("cannot instanciate f5 basic client: ", err)
+	}
+
+	saveConcurrently := func(s *configSaver, n int) []error {
+		errs := make([]error, n)
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = s.save(f5Client)
+			}(i)
+		}
+		wg.Wait()
+		return errs
+	}
+
+	s := new(configSaver)
+	for _, err := range saveConcurrently(s, 3) {
+		if err != nil {
+			t.Errorf("configSaver.save: unexpected error %q", err.Error())
+		}
+	}
+	if got, want := srv.saves, 1; got != want {
+		t.Errorf("configSaver.save: got %d saves for concurrent requests; want %d", got, want)
+	}
+
+	if err := s.save(f5Client); err != nil {
+		t.Errorf("configSaver.save: unexpected error %q", err.Error())
+	}
+	if got, want := srv.saves, 2; got != want {
+		t.Errorf("configSaver.save: got %d saves; want %d", got, want)
+	}
+
+	srv.Disable = "save_config"
+	wantErr := "cannot save sys config: http response error: 404 Not Found"
+	for _, err := range saveConcurrently(s, 2) {
+		if err == nil {
+			t.Errorf("configSaver.save: expected error %q, got nil", wantErr)
+		} else if err.Error() != wantErr {
+			t.Errorf("configSaver.save: got error %q; want %q", err.Error(), wantErr)
+		}
+	}
+}
+
+func TestPool_RunOnceSaveConfig(t *testing.T) {
+	defer func(d time.Duration) { saveBatchDelay = d }(saveBatchDelay)
+	saveBatchDelay = 50 * time.Millisecond
+
+	// Each worker has its own profile since the mock does not isolate
+	// concurrent transactions.
+	srv := newBigIPServer()
+	srv.otherProfiles = map[string]string{"clientssl-a": "", "clientssl-b": "", "clientssl-c": ""}
+	tsBigIP := httptest.NewServer(srv)
+	defer tsBigIP.Close()
+
+	f5Client, err := f5.NewBasicClient(tsBigIP.URL, "admin", "admin")
+	if err != nil {
+		t.Fatal("cannot instanciate f5 basic client: ", err)
+	}
+
+	tsCA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(crlFile))
+	}))
+	defer tsCA.Close()
+
+	p := &pool{}
+	for _, name := range []string{"a", "b", "c"} {
+		if err := p.addWorker(crlConfig{URL: tsCA.URL, Name: name, ProfileName: "clientssl-" + name}); err != nil {
+			t.Fatal("setup: ", err)
+		}
+	}
+
+	bigIPs := []*bigIP{{name: tsBigIP.URL, client: f5Client, saver: new(configSaver)}}
+	for i, status := range p.runOnce(bigIPs, &discardLogger{}) {
+		if err := status.err(0); err != nil {
+			t.Errorf("pool.runOnce: worker %d: unexpected error %q", i, err.Error())
+		}
+	}
+	if got, want := srv.saves, 1; got != want {
+		t.Errorf("pool.runOnce: got %d saves; want %d", got, want)
+	}
+}
+
+func TestWorker_DoSaveFailure(t *testing.T) {
+	defer func(d time.Duration) { saveBatchDelay = d }(saveBatchDelay)
+	saveBatchDelay = time.Millisecond
+
+	srv := newBigIPServer()
+	srv.Disable = "save_config"
+	tsBigIP := httptest.NewServer(srv)
+	defer tsBigIP.Close()
+
+	f5Client, err := f5.NewBasicClient(tsBigIP.URL, "admin", "admin")
+	if err != nil {
+		t.Fatal("cannot instanciate f5 basic client: ", err)
+	}
+	tsCA := newCRLServer(newTestPEMCRL(1, time.Now()))
+	defer tsCA.Close()
+
+	p := &pool{}
+	if err := p.addWorker(crlConfig{URL: tsCA.URL, Name: "test", ProfileName: "clientssl"}); err != nil {
+		t.Fatal("setup: ", err)
+	}
+	w := p.workers[0]
+	bigIPs := []*bigIP{{name: tsBigIP.URL, client: f5Client, saver: new(configSaver)}}
+
+	status := w.do(bigIPs, &discardLogger{})
+	if _, ok := status.saveErr(0).(*saveError); !ok || status.pushErrs[0] != nil {
+		t.Fatalf("worker.do: got save error %v and push error %v; want a save error only", status.saveErr(0), status.pushErrs[0])
+	}
+	puts := srv.callsToClientSSLPut
+
+	// The CRL is not uploaded again, only saved.
+	srv.Disable = ""
+	if err := w.do(bigIPs, &discardLogger{}).err(0); err != nil {
+		t.Fatalf("worker.do: unexpected error %q", err.Error())
+	}
+	if srv.callsToClientSSLPut != puts {
+		t.Errorf("worker.do: got %d profile updates; want %d", srv.callsToClientSSLPut, puts)
+	}
+	if srv.saves != 1 {
+		t.Errorf("worker.do: got %d saves; want 1", srv.saves)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/x509/pkix"
+	"math/rand"
+	"time"
+)
+
+// Supported scheduling modes.
+const (
+	// scheduleFixed fetches the CRL every refresh delay.
+	scheduleFixed = "fixed"
+
+	// scheduleNextUpdate fetches the CRL shortly after the expected
+	// publication of the next CRL, using the refresh delay as a ceiling.
+	scheduleNextUpdate = "next_update"
+)
+
+// minFetchDelay is the minimum delay between two fetches when the schedule is
+// based on the CRL publication.
+const minFetchDelay = time.Minute
+
+// isValidSchedule reports whether schedule is a supported scheduling mode. An
+// empty schedule stands for the default one, i.e. scheduleFixed.
+func isValidSchedule(schedule string) bool {
+	switch schedule {
+	case "", scheduleFixed, scheduleNextUpdate:
+		return true
+	}
+	return false
+}
+
+// observeCRL records the publication dates of a freshly fetched CRL so that the
+// next fetch can be planned accordingly. The interval between two publications
+// is estimated from the ThisUpdate field of two consecutive CRLs.
+func (w *worker) observeCRL(crl *pkix.CertificateList) {
+	thisUpdate := crl.TBSCertList.ThisUpdate
+	if !w.thisUpdate.IsZero() && thisUpdate.After(w.thisUpdate) {
+		w.publicationInterval = thisUpdate.Sub(w.thisUpdate)
+	}
+	w.thisUpdate = thisUpdate
+	w.nextUpdate = crl.TBSCertList.NextUpdate
+}
+
+// expectedPublication returns the time at which the CA is expected to publish
+// its next CRL. It is estimated from the publication interval when known, and
+// never later than the NextUpdate of the current CRL.
+func (w *worker) expectedPublication() time.Time {
+	if w.publicationInterval > 0 {
+		if t := w.thisUpdate.Add(w.publicationInterval); t.Before(w.nextUpdate) {
+			return t
+		}
+	}
+	return w.nextUpdate
+}
+
+// nextFetchDelay returns the delay to wait before fetching the CRL again.
+//
+// With the scheduleNextUpdate mode, the next fetch is planned scheduleOffset
+// after the expected publication of the next CRL. If that time has already
+// passed, i.e. the CA is late, the CRL is polled every scheduleOffset (or
+// minFetchDelay if greater). In any case, the delay never exceeds the refresh
+// delay.
+//
+// A random jitter of up to w.jitter is then subtracted from the delay in order
+// to spread the load on the distribution points. The result is never shorter
+// than minFetchDelay (or the refresh delay if shorter), so that a jitter larger
+// than the computed delay cannot make the worker fetch the CRL in a loop.
+func (w *worker) nextFetchDelay(now time.Time) time.Duration {
+	delay := w.refreshDelay
+	if w.schedule == scheduleNextUpdate && !w.nextUpdate.IsZero() {
+		d := w.expectedPublication().Add(w.scheduleOffset).Sub(now)
+		if d <= 0 {
+			d = w.scheduleOffset
+		}
+		if d < delay {
+			delay = d
+		}
+	}
+	if w.jitter > 0 {
+		delay -= time.Duration(rand.Int63n(int64(w.jitter)))
+	}
+	floor := minFetchDelay
+	if w.refreshDelay < floor {
+		floor = w.refreshDelay
+	}
+	if delay < floor {
+		delay = floor
+	}
+	return delay
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+)
+
+func newTestCRL(thisUpdate, nextUpdate time.Time) *pkix.CertificateList {
+	crl := new(pkix.CertificateList)
+	crl.TBSCertList.ThisUpdate = thisUpdate
+	crl.TBSCertList.NextUpdate = nextUpdate
+	return crl
+}
+
+func TestIsValidSchedule(t *testing.T) {
+	tests := map[string]bool{
+		"":            true,
+		"fixed":       true,
+		"next_update": true,
+		"hourly":      false,
+	}
+	for schedule, want := range tests {
+		if got := isValidSchedule(schedule); got != want {
+			t.Errorf("isValidSchedule(%q): got %v; want %v", schedule, got, want)
+		}
+	}
+}
+
+func TestWorker_ObserveCRL(t *testing.T) {
+	start := time.Date(2017, 9, 4, 12, 0, 0, 0, time.UTC)
+
+	w := new(worker)
+	w.observeCRL(newTestCRL(start, start.Add(7*24*time.Hour)))
+	if w.publicationInterval != 0 {
+		t.Errorf("worker.observeCRL: got publication interval %v; want 0", w.publicationInterval)
+	}
+	if got, want := w.expectedPublication(), start.Add(7*24*time.Hour); !got.Equal(want) {
+		t.Errorf("worker.expectedPublication: got %v; want %v", got, want)
+	}
+
+	// Same CRL fetched again, the interval cannot be estimated yet.
+	w.observeCRL(newTestCRL(start, start.Add(7*24*time.Hour)))
+	if w.publicationInterval != 0 {
+		t.Errorf("worker.observeCRL: got publication interval %v; want 0", w.publicationInterval)
+	}
+
+	next := start.Add(24 * time.Hour)
+	w.observeCRL(newTestCRL(next, next.Add(7*24*time.Hour)))
+	if got, want := w.publicationInterval, 24*time.Hour; got != want {
+		t.Errorf("worker.observeCRL: got publication interval %v; want %v", got, want)
+	}
+	if got, want := w.expectedPublication(), next.Add(24*time.Hour); !got.Equal(want) {
+		t.Errorf("worker.expectedPublication: got %v; want %v", got, want)
+	}
+}
+
+func TestWorker_NextFetchDelay(t *testing.T) {
+	now := time.Date(2017, 9, 4, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		w    worker
+		want time.Duration
+	}{
+		{
+			// Fixed schedule ignores the CRL dates.
+			w:    worker{refreshDelay: 6 * time.Hour, nextUpdate: now.Add(time.Hour)},
+			want: 6 * time.Hour,
+		},
+		{
+			// No CRL fetched yet.
+			w:    worker{refreshDelay: 6 * time.Hour, schedule: scheduleNextUpdate},
+			want: 6 * time.Hour,
+		},
+		{
+			w: worker{
+				refreshDelay:   6 * time.Hour,
+				schedule:       scheduleNextUpdate,
+				scheduleOffset: 10 * time.Minute,
+				nextUpdate:     now.Add(time.Hour),
+			},
+			want: 70 * time.Minute,
+		},
+		{
+			// Refresh delay is used as a ceiling.
+			w: worker{
+				refreshDelay:   6 * time.Hour,
+				schedule:       scheduleNextUpdate,
+				scheduleOffset: 10 * time.Minute,
+				nextUpdate:     now.Add(24 * time.Hour),
+			},
+			want: 6 * time.Hour,
+		},
+		{
+			// Expected publication has passed: poll every offset.
+			w: worker{
+				refreshDelay:   6 * time.Hour,
+				schedule:       scheduleNextUpdate,
+				scheduleOffset: 10 * time.Minute,
+				nextUpdate:     now.Add(-time.Hour),
+			},
+			want: 10 * time.Minute,
+		},
+		{
+			// Expected publication has passed, without offset.
+			w: worker{
+				refreshDelay: 6 * time.Hour,
+				schedule:     scheduleNextUpdate,
+				nextUpdate:   now.Add(-time.Hour),
+			},
+			want: minFetchDelay,
+		},
+	}
+	for i := range tests {
+		test := &tests[i]
+		if got := test.w.nextFetchDelay(now); got != test.want {
+			t.Errorf("%d. worker.nextFetchDelay: got %v; want %v", i, got, test.want)
+		}
+	}
+}
+
+func TestWorker_NextFetchDelayWithJitter(t *testing.T) {
+	w := worker{refreshDelay: time.Hour, jitter: 10 * time.Minute}
+	for i := 0; i < 100; i++ {
+		got := w.nextFetchDelay(time.Now())
+		if got > time.Hour || got <= 50*time.Minute {
+			t.Fatalf("worker.nextFetchDelay: got %v; want between %v and %v", got, 50*time.Minute, time.Hour)
+		}
+	}
+}
+
+func TestWorker_NextFetchDelayJitterFloor(t *testing.T) {
+	// The CA is late, the CRL is polled every minFetchDelay whatever the
+	// jitter.
+	w := worker{
+		refreshDelay: 6 * time.Hour,
+		schedule:     scheduleNextUpdate,
+		nextUpdate:   time.Now().Add(-time.Hour),
+		jitter:       time.Hour,
+	}
+	for i := 0; i < 100; i++ {
+		if got := w.nextFetchDelay(time.Now()); got != minFetchDelay {
+			t.Fatalf("worker.nextFetchDelay: got %v; want %v", got, minFetchDelay)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net"
+	"net/http"
+)
+
+// newServeMuxes returns, for each listen address of the configuration, the
+// handler of the endpoints served on it: /metrics, and /healthz and /readyz.
+// The endpoints share the same listener when their addresses are equal.
+func newServeMuxes(cfg *config, p *pool) map[string]*http.ServeMux {
+	muxes := make(map[string]*http.ServeMux)
+	mux := func(addr string) *http.ServeMux {
+		if _, ok := muxes[addr]; !ok {
+			muxes[addr] = http.NewServeMux()
+		}
+		return muxes[addr]
+	}
+	if addr := cfg.Metrics.Listen; addr != "" && p.metrics != nil {
+		mux(addr).Handle("/metrics", p.metrics)
+	}
+	if addr := cfg.Health.Listen; addr != "" {
+		h := healthHandler{
+			pool: p,
+			opts: healthOptions{
+				staleAfter:      cfg.Health.StaleAfter.Duration,
+				expiryThreshold: cfg.Health.ExpiryThreshold.Duration,
+			},
+		}
+		mux(addr).HandleFunc("/healthz", h.serveHealthz)
+		mux(addr).HandleFunc("/readyz", h.serveReadyz)
+	}
+	return muxes
+}
+
+// serveHTTP starts serving the handler at the given address. It returns the
+// listener once it is ready to accept connections.
+func serveHTTP(addr string, handler http.Handler, l logger) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		if err := http.Serve(ln, handler); err != nil {
+			l.Error("http listener on ", addr, " stopped: ", err)
+		}
+	}()
+	return ln, nil
+}
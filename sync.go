@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/e-XpertSolutions/f5-rest-client/f5"
+)
+
+// defaultSyncTimeout is the maximum time spent waiting for a device group to be
+// in sync when no sync_timeout is configured.
+const defaultSyncTimeout = 1 * time.Minute
+
+// syncPollInterval is the delay between two checks of the sync status of a
+// device group.
+var syncPollInterval = 2 * time.Second
+
+// Sync status of a device group, as reported by the cm sync-status API.
+const (
+	syncStatusInSync  = "In Sync"
+	syncStatusFailure = "Sync Failure"
+)
+
+// syncError reports that the configuration of a BigIP could not be synced to
+// its device group. It is reported separately from push errors since the CRL
+// has been successfully pushed to the BigIP itself.
+type syncError struct {
+	group string
+	err   error
+}
+
+func (e *syncError) Error() string {
+	return "cannot sync device group \"" + e.group + "\": " + e.err.Error()
+}
+
+// cmStatusStats is the subset of the response of the cm failover-status API
+// needed to get the status of the device.
+type cmStatusStats struct {
+	Entries map[string]struct {
+		NestedStats struct {
+			Entries map[string]struct {
+				Description string `json:"description"`
+			} `json:"entries"`
+		} `json:"nestedStats"`
+	} `json:"entries"`
+}
+
+// getCMStatus returns the status of the device and its summary as reported by
+// the given cm stats API.
+func getCMStatus(f5Client *f5.Client, restPath string) (status, summary string, err error) {
+	var stats cmStatusStats
+	if err := f5Client.ReadQuery(restPath, &stats); err != nil {
+		return "", "", err
+	}
+	for _, entry := range stats.Entries {
+		status = entry.NestedStats.Entries["status"].Description
+		summary = entry.NestedStats.Entries["summary"].Description
+		if status != "" {
+			return status, summary, nil
+		}
+	}
+	return "", "", errors.New("no status found in response")
+}
+
+// syncStatusStats is the subset of the response of the cm sync-status API
+// needed to get the status of each device group, which is given by the
+// "details" entries nested in the stats, e.g.
+// "failover (In Sync): All devices in the device group are in sync".
+type syncStatusStats struct {
+	Description string `json:"description"`
+	NestedStats struct {
+		Entries map[string]syncStatusStats `json:"entries"`
+	} `json:"nestedStats"`
+	Entries map[string]syncStatusStats `json:"entries"`
+}
+
+// details returns the descriptions of the "details" entries found in the
+// stats.
+func (s syncStatusStats) details() []string {
+	var details []string
+	for _, entries := range []map[string]syncStatusStats{s.Entries, s.NestedStats.Entries} {
+		for key, entry := range entries {
+			if key == "details" && entry.Description != "" {
+				details = append(details, entry.Description)
+				continue
+			}
+			details = append(details, entry.details()...)
+		}
+	}
+	return details
+}
+
+// parseSyncDetails parses the sync status of a device group as reported in
+// the details of the cm sync-status API, i.e. "group (status): summary".
+func parseSyncDetails(details string) (group, status, summary string, ok bool) {
+	open := strings.Index(details, " (")
+	end := strings.Index(details, "):")
+	if open <= 0 || end < open {
+		return "", "", "", false
+	}
+	return details[:open], details[open+2 : end], strings.TrimSpace(details[end+2:]), true
+}
+
+// getSyncStatus returns the sync status of the given device group, e.g.
+// "In Sync" or "Changes Pending", and its summary. The status of the other
+// device groups the BigIP belongs to is ignored.
+func getSyncStatus(f5Client *f5.Client, group string) (status, summary string, err error) {
+	var stats syncStatusStats
+	if err := f5Client.ReadQuery("/mgmt/tm/cm/sync-status", &stats); err != nil {
+		return "", "", errors.New("cannot get sync status: " + err.Error())
+	}
+	for _, details := range stats.details() {
+		name, status, summary, ok := parseSyncDetails(details)
+		if ok && qualifyName(name) == qualifyName(group) {
+			return status, summary, nil
+		}
+	}
+	return "", "", errors.New("cannot get sync status: no status found for device group \"" + group + "\"")
+}
+
+// syncDeviceGroup syncs the configuration of the BigIP to its device group,
+// i.e. runs "config-sync to-group", and waits for the device group to be in
+// sync. It returns a *syncError on failure, or once stop is closed.
+func syncDeviceGroup(b *bigIP, stop <-chan struct{}) error {
+	cmd := map[string]string{
+		"command":     "run",
+		"utilCmdArgs": "config-sync to-group " + b.syncDeviceGroup,
+	}
+	if err := b.client.ModQuery("POST", "/mgmt/tm/cm", cmd); err != nil {
+		return &syncError{group: b.syncDeviceGroup, err: errors.New("config-sync failed: " + err.Error())}
+	}
+
+	timeout := b.syncTimeout
+	if timeout <= 0 {
+		timeout = defaultSyncTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		status, summary, err := getSyncStatus(b.client, b.syncDeviceGroup)
+		if err != nil {
+			return &syncError{group: b.syncDeviceGroup, err: err}
+		}
+		switch status {
+		case syncStatusInSync:
+			return nil
+		case syncStatusFailure:
+			return &syncError{group: b.syncDeviceGroup, err: errors.New(status + ": " + summary)}
+		}
+		if time.Now().After(deadline) {
+			return &syncError{
+				group: b.syncDeviceGroup,
+				err:   errors.New("not in sync after " + timeout.String() + ", last status: " + status + ": " + summary),
+			}
+		}
+		select {
+		case <-time.After(syncPollInterval):
+		case <-stop:
+			return &syncError{
+				group: b.syncDeviceGroup,
+				err:   errors.New("interrupted while waiting for sync, last status: " + status + ": " + summary),
+			}
+		}
+	}
+}
+
+// getDeviceGroupType returns the type of the device group, e.g.
+// "sync-failover", which ensures it exists.
+func getDeviceGroupType(f5Client *f5.Client, group string) (string, error) {
+	var deviceGroup struct {
+		Type string `json:"type"`
+	}
+	if err := f5Client.ReadQuery("/mgmt/tm/cm/device-group/"+restName(qualifyName(group)), &deviceGroup); err != nil {
+		return "", err
+	}
+	return deviceGroup.Type, nil
+}
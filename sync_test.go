@@ -0,0 +1,174 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/e-XpertSolutions/f5-rest-client/f5"
+)
+
+func TestSyncDeviceGroup(t *testing.T) {
+	defer func(d time.Duration) { syncPollInterval = d }(syncPollInterval)
+	syncPollInterval = time.Millisecond
+
+	tests := []struct {
+		name     string
+		group    string
+		disable  string
+		statuses []string
+		stop     bool
+		wantErr  string
+	}{
+		{
+			name:     "In Sync",
+			statuses: []string{"Changes Pending", "Syncing", "In Sync"},
+		},
+		{
+			name:     "Sync Failure",
+			statuses: []string{"Syncing", "Sync Failure"},
+			wantErr:  "cannot sync device group \"/Common/failover\": Sync Failure: mock summary",
+		},
+		{
+			name:     "Timeout",
+			statuses: []string{"Changes Pending"},
+			wantErr:  "cannot sync device group \"/Common/failover\": not in sync after 20ms, last status: Changes Pending: mock summary",
+		},
+		{
+			name:     "Stopped",
+			statuses: []string{"Changes Pending"},
+			stop:     true,
+			wantErr:  "cannot sync device group \"/Common/failover\": interrupted while waiting for sync, last status: Changes Pending: mock summary",
+		},
+		{
+			name:    "Unknown Device Group",
+			group:   "/Common/other",
+			wantErr: "cannot sync device group \"/Common/other\": cannot get sync status: no status found for device group \"/Common/other\"",
+		},
+		{
+			name:    "Fail Config Sync",
+			disable: "cm_sync",
+			wantErr: "cannot sync device group \"/Common/failover\": config-sync failed: http response error: 404 Not Found",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := newBigIPServer()
+			srv.Disable = test.disable
+			srv.syncStatuses = test.statuses
+			tsBigIP := httptest.NewServer(srv)
+			defer tsBigIP.Close()
+
+			f5Client, err := f5.NewBasicClient(tsBigIP.URL, "admin", "admin")
+			if err != nil {
+				t.Fatal("cannot instanciate f5 basic client: ", err)
+			}
+
+			group := test.group
+			if group == "" {
+				group = "/Common/failover"
+			}
+			b := &bigIP{name: "bigip", client: f5Client, syncDeviceGroup: group, syncTimeout: 20 * time.Millisecond}
+			stop := make(chan struct{})
+			if test.stop {
+				b.syncTimeout = time.Hour
+				close(stop)
+			}
+			err = syncDeviceGroup(b, stop)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("syncDeviceGroup: unexpected error %q", err.Error())
+				}
+				if want := []string{"config-sync to-group /Common/failover"}; !reflect.DeepEqual(srv.syncCommands, want) {
+					t.Errorf("syncDeviceGroup: got commands %q; want %q", srv.syncCommands, want)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("syncDeviceGroup: expected error %q, got nil", test.wantErr)
+			}
+			if _, ok := err.(*syncError); !ok {
+				t.Errorf("syncDeviceGroup: got error of type %T; want *syncError", err)
+			}
+			if err.Error() != test.wantErr {
+				t.Errorf("syncDeviceGroup: got error %q; want %q", err.Error(), test.wantErr)
+			}
+		})
+	}
+}
+
+func TestWorker_DoSync(t *testing.T) {
+	defer func(d time.Duration) { syncPollInterval = d }(syncPollInterval)
+	syncPollInterval = time.Millisecond
+
+	srv := newBigIPServer()
+	srv.syncStatuses = []string{"Changes Pending", "Sync Failure"}
+	tsBigIP := httptest.NewServer(srv)
+	defer tsBigIP.Close()
+
+	f5Client, err := f5.NewBasicClient(tsBigIP.URL, "admin", "admin")
+	if err != nil {
+		t.Fatal("cannot instanciate f5 basic client: ", err)
+	}
+
+	tsCA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(crlFile))
+	}))
+	defer tsCA.Close()
+
+	w := worker{
+		urls:         []string{tsCA.URL},
+		crlName:      "test",
+		profiles:     profileSelector{refs: []profileRef{{clientSSL, "/Common/clientssl"}}},
+		refreshDelay: 300,
+		metrics:      newMetrics(),
+		stopCh:       make(chan struct{}),
+	}
+	bigIPs := []*bigIP{{name: "bigip", client: f5Client, syncDeviceGroup: "failover", syncTimeout: time.Second}}
+	key := metricsKey{crl: "test", bigIP: "bigip"}
+
+	// The CRL is pushed but the device group cannot be synced: the failure is
+	// reported as a sync error.
+	status := w.do(bigIPs, &discardLogger{})
+	if err := status.pushErrs[0]; err != nil {
+		t.Errorf("worker.do: unexpected push error %q", err.Error())
+	}
+	wantErr := "cannot sync device group \"failover\": Sync Failure: mock summary"
+	if err := status.syncErr(0); err == nil {
+		t.Errorf("worker.do: expected sync error %q, got nil", wantErr)
+	} else if err.Error() != wantErr {
+		t.Errorf("worker.do: got sync error %q; want %q", err.Error(), wantErr)
+	}
+	if _, ok := w.lastPushed(bigIPs[0]); ok {
+		t.Error("worker.do: crl recorded as pushed although the device group is not in sync")
+	}
+	if _, ok := w.metrics.lastPush[key]; ok {
+		t.Error("worker.do: successful push recorded in metrics although the device group is not in sync")
+	}
+
+	// The next run does not upload the CRL again, only syncs.
+	srv.syncStatuses = nil
+	puts := srv.callsToClientSSLPut
+	status = w.do(bigIPs, &discardLogger{})
+	if err := status.err(0); err != nil {
+		t.Errorf("worker.do: unexpected error %q", err.Error())
+	}
+	if srv.callsToClientSSLPut != puts {
+		t.Errorf("worker.do: got %d profile updates; want %d", srv.callsToClientSSLPut, puts)
+	}
+	if got, want := len(srv.syncCommands), 2; got != want {
+		t.Errorf("worker.do: got %d config-sync commands; want %d", got, want)
+	}
+	if _, ok := w.lastPushed(bigIPs[0]); !ok {
+		t.Error("worker.do: crl not recorded as pushed once the device group is in sync")
+	}
+	if _, ok := w.metrics.lastPush[key]; !ok {
+		t.Error("worker.do: successful push not recorded in metrics once the device group is in sync")
+	}
+	if got := srv.crlFile; !strings.HasPrefix(got, "/Common/test_") {
+		t.Errorf("worker.do: got crl file %q for profile %q; want an uploaded file", got, "clientssl")
+	}
+}
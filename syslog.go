@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syslogFacilities maps the names of the syslog facilities to their codes, as
+// defined by RFC 5424.
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogSeverityNames lists the syslog severities, indexed by their codes.
+var syslogSeverityNames = [...]string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// defaultSyslogSeverities maps each log level to a syslog severity unless the
+// configuration says otherwise.
+var defaultSyslogSeverities = [...]int{
+	levelDebug:  7,
+	levelInfo:   6,
+	levelNotice: 5,
+	levelWarn:   4,
+	levelError:  3,
+}
+
+// Defaults of the syslog and journald outputs.
+const (
+	defaultSyslogFacility = "daemon"
+	defaultSyslogTag      = "crl2f5-connector"
+	defaultSyslogNetwork  = "udp"
+	defaultSyslogAddress  = "localhost:514"
+	defaultSyslogSocket   = "/dev/log"
+)
+
+// parseSyslogSeverity returns the code of the syslog severity with the given
+// name.
+func parseSyslogSeverity(name string) (int, bool) {
+	for code, s := range syslogSeverityNames {
+		if s == name {
+			return code, true
+		}
+	}
+	return 0, false
+}
+
+// isSyslogSeverity reports whether name is the name of a syslog severity.
+func isSyslogSeverity(name string) bool {
+	_, ok := parseSyslogSeverity(name)
+	return ok
+}
+
+// isValidSDID reports whether id is a valid SD-ID for private use, as defined
+// by RFC 5424, i.e. a name followed by "@" and a Private Enterprise Number
+// such as "crl2f5@12345", at most 32 characters long.
+func isValidSDID(id string) bool {
+	at := strings.IndexByte(id, '@')
+	if at <= 0 || at == len(id)-1 || len(id) > 32 {
+		return false
+	}
+	for _, c := range id[:at] {
+		if c <= ' ' || c > '~' || c == '=' || c == ']' || c == '"' || c == '@' {
+			return false
+		}
+	}
+	for _, c := range id[at+1:] {
+		if (c < '0' || c > '9') && c != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+// syslogOptions are the settings shared by the syslog and journald outputs.
+type syslogOptions struct {
+	facility   int
+	tag        string
+	severities [len(logLevelNames)]int
+
+	// sdID is the SD-ID holding the fields of the events in the structured
+	// data of the syslog messages. Without it, the fields are appended to
+	// the message.
+	sdID string
+}
+
+// newSyslogOptions returns the facility, tag and severities defined by the
+// configuration, falling back to the defaults for the missing ones.
+func newSyslogOptions(cfg logConfig) (syslogOptions, error) {
+	opts := syslogOptions{tag: cfg.Tag, severities: defaultSyslogSeverities, sdID: cfg.SDID}
+	if opts.tag == "" {
+		opts.tag = defaultSyslogTag
+	}
+	if opts.sdID != "" && !isValidSDID(opts.sdID) {
+		return opts, errors.New("invalid syslog SD-ID \"" + opts.sdID + "\"")
+	}
+	facility := cfg.Facility
+	if facility == "" {
+		facility = defaultSyslogFacility
+	}
+	code, ok := syslogFacilities[facility]
+	if !ok {
+		return opts, errors.New("unsupported syslog facility \"" + facility + "\"")
+	}
+	opts.facility = code
+	for name, severity := range cfg.Severities {
+		lvl, ok := parseLogLevel(name)
+		if !ok {
+			return opts, errors.New("unsupported log level \"" + name + "\"")
+		}
+		code, ok := parseSyslogSeverity(severity)
+		if !ok {
+			return opts, errors.New("unsupported syslog severity \"" + severity + "\"")
+		}
+		opts.severities[lvl] = code
+	}
+	return opts, nil
+}
+
+// syslogAddress returns the network and address of the syslog server defined
+// by the configuration. Unix sockets default to /dev/log.
+func syslogAddress(cfg logConfig) (network, address string) {
+	network, address = cfg.Network, cfg.Address
+	if network == "" {
+		network = defaultSyslogNetwork
+	}
+	if address == "" {
+		address = defaultSyslogAddress
+		if network == "unix" {
+			address = defaultSyslogSocket
+		}
+	}
+	return network, address
+}
+
+// syslogSink sends events to a syslog server in the RFC 5424 format, over UDP,
+// TCP or a Unix socket. The connection is reopened once when a message cannot
+// be sent; if that fails as well, the event is written to the fallback writer
+// along with the error.
+type syslogSink struct {
+	mu       sync.Mutex
+	network  string
+	address  string
+	conn     net.Conn
+	opts     syslogOptions
+	hostname string
+	fallback io.Writer
+}
+
+// newSyslogSink connects to the syslog server defined by the configuration.
+func newSyslogSink(cfg logConfig, fallback io.Writer) (*syslogSink, error) {
+	opts, err := newSyslogOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	network, address := syslogAddress(cfg)
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	s := &syslogSink{
+		network:  network,
+		address:  address,
+		opts:     opts,
+		hostname: hostname,
+		fallback: fallback,
+	}
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// connect opens the connection to the syslog server. Unix sockets are tried
+// in datagram mode first, then in stream mode.
+func (s *syslogSink) connect() error {
+	var (
+		conn net.Conn
+		err  error
+	)
+	if s.network == "unix" {
+		conn, err = net.Dial("unixgram", s.address)
+		if err != nil {
+			conn, err = net.Dial("unix", s.address)
+		}
+	} else {
+		conn, err = net.Dial(s.network, s.address)
+	}
+	if err != nil {
+		return errors.New("cannot connect to syslog at " + s.network + "://" + s.address + ": " + err.Error())
+	}
+	s.conn = conn
+	return nil
+}
+
+func (s *syslogSink) writeEvent(t time.Time, level logLevel, msg string, fields []logField) {
+	data := formatSyslogMessage(t, s.opts.facility*8+s.opts.severities[level], s.hostname, s.opts.tag, s.opts.sdID, os.Getpid(), msg, fields)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.send(data)
+	if err != nil {
+		s.conn.Close()
+		if err = s.connect(); err == nil {
+			err = s.send(data)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(s.fallback, "cannot send event to syslog: %v\n", err)
+		s.fallback.Write(formatTextEvent(t, level, msg, fields))
+	}
+}
+
+// send writes a message on the connection, framed by its length on TCP as
+// defined by RFC 6587 and followed by a newline on a Unix stream socket.
+func (s *syslogSink) send(data []byte) error {
+	if s.conn == nil {
+		return errors.New("not connected")
+	}
+	switch s.conn.LocalAddr().Network() {
+	case "tcp":
+		data = append([]byte(strconv.Itoa(len(data))+" "), data...)
+	case "unix":
+		data = append(data, '\n')
+	}
+	_, err := s.conn.Write(data)
+	return err
+}
+
+// formatSyslogMessage formats an event as an RFC 5424 message such as
+// `<27>1 2017-09-04T12:00:00.000000Z host crl2f5-connector 42 - [crl2f5@12345 worker="test"] message`.
+// The fields of the event are sent as structured data under the given SD-ID,
+// or appended to the message as key=value pairs if it is empty.
+func formatSyslogMessage(t time.Time, priority int, hostname, tag, sdID string, pid int, msg string, fields []logField) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("<" + strconv.Itoa(priority) + ">1 ")
+	buf.WriteString(t.Format("2006-01-02T15:04:05.000000Z07:00"))
+	buf.WriteString(" " + hostname + " " + tag + " " + strconv.Itoa(pid) + " - ")
+	if len(fields) == 0 || sdID == "" {
+		buf.WriteString("- " + msg)
+		writeTextFields(&buf, fields)
+		return buf.Bytes()
+	}
+	buf.WriteString("[" + sdID)
+	for _, f := range fields {
+		buf.WriteString(" " + f.key + "=\"" + sdEscaper.Replace(f.value) + "\"")
+	}
+	buf.WriteString("] " + msg)
+	return buf.Bytes()
+}
+
+// sdEscaper escapes the characters that cannot appear as is in a structured
+// data parameter value.
+var sdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
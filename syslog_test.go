@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatSyslogMessage(t *testing.T) {
+	ts := time.Date(2017, 9, 4, 12, 0, 0, 0, time.UTC)
+	fields := []logField{
+		{key: fieldWorker, value: "test"},
+		{key: fieldCRLFile, value: `a"b\c]`},
+	}
+	tests := []struct {
+		name   string
+		sdID   string
+		msg    string
+		fields []logField
+		want   string
+	}{
+		{
+			name: "No Fields",
+			sdID: "crl2f5@12345",
+			msg:  "test",
+			want: "<27>1 2017-09-04T12:00:00.000000Z host crl2f5 42 - - test",
+		},
+		{
+			name:   "Structured Data",
+			sdID:   "crl2f5@12345",
+			msg:    "test",
+			fields: fields,
+			want:   `<27>1 2017-09-04T12:00:00.000000Z host crl2f5 42 - [crl2f5@12345 worker="test" crl_file="a\"b\\c\]"] test`,
+		},
+		{
+			name:   "No SD-ID",
+			msg:    "test",
+			fields: fields,
+			want:   `<27>1 2017-09-04T12:00:00.000000Z host crl2f5 42 - - test worker=test crl_file="a\"b\\c]"`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := string(formatSyslogMessage(ts, 3*8+3, "host", "crl2f5", test.sdID, 42, test.msg, test.fields))
+			if got != test.want {
+				t.Errorf("formatSyslogMessage: got %q; want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// syslogPrefix returns the beginning of the RFC 5424 messages sent by this
+// process with the given priority, up to the timestamp excluded.
+func syslogPrefix(priority int) string {
+	return "<" + strconv.Itoa(priority) + ">1 "
+}
+
+// syslogSuffix returns the end of the RFC 5424 messages sent by this process
+// with the given tag, following the timestamp and hostname.
+func syslogSuffix(tag, sd, msg string) string {
+	return " " + tag + " " + strconv.Itoa(os.Getpid()) + " - " + sd + " " + msg
+}
+
+func checkSyslogMessage(t *testing.T, got string, priority int, tag, sd, msg string) {
+	if !strings.HasPrefix(got, syslogPrefix(priority)) || !strings.HasSuffix(got, syslogSuffix(tag, sd, msg)) {
+		t.Errorf("got syslog message %q; want priority %d and suffix %q", got, priority, syslogSuffix(tag, sd, msg))
+	}
+}
+
+func TestSyslogLogger_UDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("setup: ", err)
+	}
+	defer conn.Close()
+
+	cfg := logConfig{
+		Output:     logOutputSyslog,
+		Address:    conn.LocalAddr().String(),
+		Facility:   "local0",
+		Tag:        "crl2f5",
+		SDID:       "crl2f5@12345",
+		Severities: map[string]string{"notice": "info"},
+	}
+	l, err := newConfiguredLogger(ioutil.Discard, cfg, false)
+	if err != nil {
+		t.Fatalf("newConfiguredLogger: unexpected error %q", err.Error())
+	}
+	l.Debug("skipped")
+	l.With(fieldWorker, "test").Notice("pushed")
+	l.Error("failed")
+
+	buf := make([]byte, 2048)
+	for _, want := range []struct {
+		priority int
+		sd, msg  string
+	}{
+		{priority: 16*8 + 6, sd: `[crl2f5@12345 worker="test"]`, msg: "pushed"},
+		{priority: 16*8 + 3, sd: "-", msg: "failed"},
+	} {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("cannot read syslog message: %v", err)
+		}
+		checkSyslogMessage(t, string(buf[:n]), want.priority, "crl2f5", want.sd, want.msg)
+	}
+}
+
+func TestSyslogLogger_TCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("setup: ", err)
+	}
+	defer ln.Close()
+
+	cfg := logConfig{Output: logOutputSyslog, Network: "tcp", Address: ln.Addr().String()}
+	l, err := newConfiguredLogger(ioutil.Discard, cfg, false)
+	if err != nil {
+		t.Fatalf("newConfiguredLogger: unexpected error %q", err.Error())
+	}
+	l.Warn("first")
+	l.Info("second")
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal("cannot accept syslog connection: ", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	for _, want := range []struct {
+		priority int
+		msg      string
+	}{
+		{priority: 3*8 + 4, msg: "first"},
+		{priority: 3*8 + 6, msg: "second"},
+	} {
+		// Messages are framed by their length, as defined by RFC 6587.
+		length, err := r.ReadString(' ')
+		if err != nil {
+			t.Fatalf("cannot read syslog message length: %v", err)
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(length, " "))
+		if err != nil {
+			t.Fatalf("got syslog message length %q; want a number", length)
+		}
+		msg := make([]byte, n)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			t.Fatalf("cannot read syslog message: %v", err)
+		}
+		checkSyslogMessage(t, string(msg), want.priority, defaultSyslogTag, "-", want.msg)
+	}
+}
+
+func TestSyslogLogger_Unix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crl2f5")
+	if err != nil {
+		t.Fatal("setup: ", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "log.sock")
+	conn, err := net.ListenPacket("unixgram", path)
+	if err != nil {
+		t.Fatal("setup: ", err)
+	}
+	defer conn.Close()
+
+	cfg := logConfig{Output: logOutputSyslog, Network: "unix", Address: path, Tag: "crl2f5"}
+	l, err := newConfiguredLogger(ioutil.Discard, cfg, false)
+	if err != nil {
+		t.Fatalf("newConfiguredLogger: unexpected error %q", err.Error())
+	}
+	l.With(fieldBigIP, "https://bigip").Notice("pushed")
+
+	buf := make([]byte, 2048)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("cannot read syslog message: %v", err)
+	}
+	checkSyslogMessage(t, string(buf[:n]), 3*8+5, "crl2f5", "-", "pushed bigip=https://bigip")
+}
+
+func TestSyslogLogger_Fallback(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("setup: ", err)
+	}
+	cfg := logConfig{Output: logOutputSyslog, Network: "tcp", Address: ln.Addr().String()}
+	var buf bytes.Buffer
+	l, err := newConfiguredLogger(&buf, cfg, false)
+	if err != nil {
+		t.Fatalf("newConfiguredLogger: unexpected error %q", err.Error())
+	}
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal("cannot accept syslog connection: ", err)
+	}
+	conn.Close()
+	ln.Close()
+
+	// The first writes may succeed until the reset of the connection is
+	// received.
+	for i := 0; i < 50 && buf.Len() == 0; i++ {
+		l.Error("lost")
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := buf.String(); !strings.Contains(got, "cannot send event to syslog: ") || !strings.Contains(got, "[error] lost\n") {
+		t.Errorf("got fallback output %q; want the event and the error", got)
+	}
+}
+
+func TestNewConfiguredLogger_Errors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crl2f5")
+	if err != nil {
+		t.Fatal("setup: ", err)
+	}
+	defer os.RemoveAll(dir)
+	missing := filepath.Join(dir, "missing.sock")
+
+	tests := []struct {
+		name    string
+		cfg     logConfig
+		wantErr string
+	}{
+		{
+			name:    "Unknown Facility",
+			cfg:     logConfig{Output: logOutputSyslog, Facility: "local8"},
+			wantErr: "unsupported syslog facility \"local8\"",
+		},
+		{
+			name:    "Invalid SD-ID",
+			cfg:     logConfig{Output: logOutputSyslog, SDID: "crl2f5@example"},
+			wantErr: "invalid syslog SD-ID \"crl2f5@example\"",
+		},
+		{
+			name:    "Unknown Severity",
+			cfg:     logConfig{Output: logOutputJournald, Severities: map[string]string{"warn": "warn"}},
+			wantErr: "unsupported syslog severity \"warn\"",
+		},
+		{
+			name:    "Syslog Unreachable",
+			cfg:     logConfig{Output: logOutputSyslog, Network: "unix", Address: missing},
+			wantErr: "cannot connect to syslog at unix://" + missing + ": ",
+		},
+		{
+			name:    "Journal Unreachable",
+			cfg:     logConfig{Output: logOutputJournald, Address: missing},
+			wantErr: "cannot connect to the journal at " + missing + ": ",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := newConfiguredLogger(ioutil.Discard, test.cfg, false)
+			if err == nil || !strings.HasPrefix(err.Error(), test.wantErr) {
+				t.Errorf("newConfiguredLogger: got error %v; want %q", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -3,34 +3,106 @@ package main
 import (
 	"bytes"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/pem"
 	"errors"
 	"io/ioutil"
+	"math/big"
+	"net"
 	"net/http"
+	"reflect"
 	"strings"
 	"time"
 )
 
+// errNotModified is returned by fetchCRL when the CRL distribution point
+// reports that the CRL has not been modified since the last fetch.
+var errNotModified = errors.New("crl not modified")
+
+// httpCache holds the HTTP validators returned along with the last CRL fetched
+// from a distribution point, so that the next fetch can be made conditional.
+type httpCache struct {
+	etag         string
+	lastModified string
+}
+
+// crlFetchTimeout bounds the time spent connecting to a distribution point and
+// waiting for its response headers, so that one that accepts connections but
+// never answers does not prevent the mirrors from being tried. The transfer of
+// the body is not bounded since large CRLs can take long to download on slow
+// links.
+const crlFetchTimeout = 30 * time.Second
+
+// crlTransport is the transport of crlClient.
+var crlTransport = newCRLTransport()
+
+// crlClient is the http client used to fetch CRLs. Besides http and https, it
+// supports the file scheme so that a CRL can also be read from a local path or
+// a mounted file share, e.g. "file:///mnt/pki/example.crl".
+var crlClient = &http.Client{Transport: crlTransport}
+
+func newCRLTransport() *http.Transport {
+	t := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   crlFetchTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: crlFetchTimeout,
+		IdleConnTimeout:       90 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	t.RegisterProtocol("file", http.NewFileTransport(http.Dir("/")))
+	return t
+}
+
 // fetchCRL downloads a CRL, verifies it is correctly encoded and that it has
 // not expired yet, and returns it as raw slice of bytes so that it can be
 // forwarded right after.
 //
 // No matter what format is returned by the CRL distribution point, the function
 // will return a PEM encoded CRL.
-func fetchCRL(url string) ([]byte, error) {
-	resp, err := http.Get(url)
+//
+// If cache is not nil, the request is made conditional using the validators it
+// holds, and cache is updated with the ones returned by the server on success.
+// When the server responds with "304 Not Modified", errNotModified is
+// returned.
+func fetchCRL(url string, cache *httpCache) ([]byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return nil, errors.New("cannot fetch crl: " + err.Error())
+		return nil, newFetchError(fetchReasonOther, "cannot fetch crl: "+err.Error())
+	}
+	if cache != nil {
+		if cache.etag != "" {
+			req.Header.Set("If-None-Match", cache.etag)
+		}
+		if cache.lastModified != "" {
+			req.Header.Set("If-Modified-Since", cache.lastModified)
+		}
+	}
+
+	resp, err := crlClient.Do(req)
+	if err != nil {
+		return nil, newFetchError(fetchReasonNetwork, "cannot fetch crl: "+err.Error())
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode >= 400 {
-		return nil, errors.New("cannot fetch crl due to http error: " + resp.Status)
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cache != nil:
+		return nil, errNotModified
+	case resp.StatusCode >= 400:
+		return nil, newFetchError(fetchReasonHTTPStatus, "cannot fetch crl due to http error: "+resp.Status)
+	case resp.StatusCode >= 300:
+		// Redirections are followed by the http client, hence any 3xx status
+		// code reaching this point cannot be handled.
+		return nil, newFetchError(fetchReasonHTTPStatus, "cannot fetch crl due to unexpected http status: "+resp.Status)
 	}
 
 	rawCRL, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, errors.New("cannot read crl: " + err.Error())
+		return nil, newFetchError(fetchReasonNetwork, "cannot read crl: "+err.Error())
 	}
 
 	// We need both, a DER encoded CRL and a PEM one. The former is required to
@@ -40,7 +112,7 @@ func fetchCRL(url string) ([]byte, error) {
 	if isPEM(rawCRL) {
 		derCRL, err = convertPEMToDER(rawCRL)
 		if err != nil {
-			return nil, errors.New("cannot convert crl from pem to der: " + err.Error())
+			return nil, newFetchError(fetchReasonParse, "cannot convert crl from pem to der: "+err.Error())
 		}
 		pemCRL = rawCRL
 	} else {
@@ -51,15 +123,157 @@ func fetchCRL(url string) ([]byte, error) {
 	// TODO(gilliek): move this part into an external function
 	crl, err := x509.ParseDERCRL(derCRL)
 	if err != nil {
-		return nil, errors.New("cannot parse crl: " + err.Error())
+		return nil, newFetchError(fetchReasonParse, "cannot parse crl: "+err.Error())
 	}
 	if crl.HasExpired(time.Now()) {
-		return nil, errors.New("crl has expired")
+		return nil, newFetchError(fetchReasonExpired, "crl has expired")
+	}
+
+	if cache != nil {
+		cache.etag = resp.Header.Get("ETag")
+		cache.lastModified = resp.Header.Get("Last-Modified")
 	}
 
 	return pemCRL, nil
 }
 
+// parseCRL parses a PEM encoded CRL, as returned by fetchCRL.
+func parseCRL(pemCRL []byte) (*pkix.CertificateList, error) {
+	derCRL, err := convertPEMToDER(pemCRL)
+	if err != nil {
+		return nil, errors.New("cannot convert crl from pem to der: " + err.Error())
+	}
+	crl, err := x509.ParseDERCRL(derCRL)
+	if err != nil {
+		return nil, errors.New("cannot parse crl: " + err.Error())
+	}
+	return crl, nil
+}
+
+// loadCertificates reads all the PEM encoded certificates contained in the
+// file located at path. The file may either hold a single certificate or a
+// bundle of concatenated certificates.
+func loadCertificates(path string) ([]*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.New("cannot read certificate file: " + err.Error())
+	}
+	certs, err := parseCertificates(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no pem encoded certificate found in " + path)
+	}
+	return certs, nil
+}
+
+// parseCertificates parses all the PEM encoded certificates contained in data.
+// Blocks other than certificates are ignored.
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.New("cannot parse certificate: " + err.Error())
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// oidAuthorityKeyIdentifier is the ASN.1 object identifier of the authority
+// key identifier extension (RFC 5280, section 5.2.1).
+var oidAuthorityKeyIdentifier = asn1.ObjectIdentifier{2, 5, 29, 35}
+
+// authorityKeyID returns the key identifier contained in the authority key
+// identifier extension of the CRL, if any.
+func authorityKeyID(crl *pkix.CertificateList) ([]byte, error) {
+	for _, ext := range crl.TBSCertList.Extensions {
+		if !ext.Id.Equal(oidAuthorityKeyIdentifier) {
+			continue
+		}
+		var aki struct {
+			ID []byte `asn1:"optional,tag:0"`
+		}
+		if _, err := asn1.Unmarshal(ext.Value, &aki); err != nil {
+			return nil, errors.New("malformed authority key identifier: " + err.Error())
+		}
+		return aki.ID, nil
+	}
+	return nil, nil
+}
+
+// oidCRLNumber is the ASN.1 object identifier of the CRL number extension
+// (RFC 5280, section 5.2.3).
+var oidCRLNumber = asn1.ObjectIdentifier{2, 5, 29, 20}
+
+// crlNumber returns the CRL number of the CRL, or nil if the CRL does not
+// define the CRL number extension.
+func crlNumber(crl *pkix.CertificateList) (*big.Int, error) {
+	for _, ext := range crl.TBSCertList.Extensions {
+		if !ext.Id.Equal(oidCRLNumber) {
+			continue
+		}
+		n := new(big.Int)
+		if _, err := asn1.Unmarshal(ext.Value, &n); err != nil {
+			return nil, errors.New("malformed crl number: " + err.Error())
+		}
+		return n, nil
+	}
+	return nil, nil
+}
+
+// sameName reports whether the DER encoded distinguished name raw, as found in
+// a certificate, is the same as name. The comparison is made on the decoded
+// attributes so that the string types used by the encoders do not matter.
+func sameName(raw []byte, name pkix.RDNSequence) bool {
+	var rdn pkix.RDNSequence
+	if _, err := asn1.Unmarshal(raw, &rdn); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(rdn, name)
+}
+
+// verifyCRL verifies that the CRL has been issued by one of the given issuer
+// certificates. For a certificate to be considered as the issuer, its subject
+// must match the issuer of the CRL, its subject key identifier must match the
+// authority key identifier of the CRL (when both are present) and its public
+// key must verify the signature of the CRL.
+func verifyCRL(crl *pkix.CertificateList, issuers []*x509.Certificate) error {
+	if len(issuers) == 0 {
+		return errors.New("cannot verify crl: no issuer certificate provided")
+	}
+	akid, err := authorityKeyID(crl)
+	if err != nil {
+		return errors.New("cannot verify crl: " + err.Error())
+	}
+	err = errors.New("crl issuer does not match any issuer certificate")
+	for _, cert := range issuers {
+		if !sameName(cert.RawSubject, crl.TBSCertList.Issuer) {
+			continue
+		}
+		if len(akid) > 0 && len(cert.SubjectKeyId) > 0 && !bytes.Equal(akid, cert.SubjectKeyId) {
+			err = errors.New("crl authority key identifier does not match issuer certificate")
+			continue
+		}
+		if sigErr := cert.CheckCRLSignature(crl); sigErr != nil {
+			err = errors.New("invalid crl signature: " + sigErr.Error())
+			continue
+		}
+		return nil
+	}
+	return err
+}
+
 // isPEM reports whether data contains a PEM encoded CRL.
 func isPEM(data []byte) bool {
 	return bytes.HasPrefix(data, []byte("-----BEGIN X509 CRL-----"))
@@ -79,3 +293,28 @@ func convertPEMToDER(pemCRL []byte) ([]byte, error) {
 func isNotFoundError(err error) bool {
 	return strings.Contains(err.Error(), "(code: 404)")
 }
+
+// defaultPartition is the administrative partition of the BigIP objects whose
+// name is not fully qualified.
+const defaultPartition = "Common"
+
+// qualifyName returns the full path of a BigIP object, e.g. "/Common/clientssl"
+// for "clientssl". Names that are not fully qualified are relative to the
+// default partition.
+func qualifyName(name string) string {
+	if strings.HasPrefix(name, "/") {
+		return name
+	}
+	return "/" + defaultPartition + "/" + name
+}
+
+// restName returns the name of a BigIP object as expected in the path of an
+// iControl REST request, e.g. "~Common~ca.crt" for "/Common/ca.crt".
+func restName(name string) string {
+	return strings.Replace(name, "/", "~", -1)
+}
+
+// isNone reports whether a reference to a BigIP object is unset.
+func isNone(name string) bool {
+	return name == "" || name == "none"
+}
@@ -2,12 +2,21 @@ package main
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/base64"
 	"encoding/pem"
 	"errors"
+	"io/ioutil"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
+	"time"
 )
 
 func decodeBase64(b64 string) []byte {
@@ -93,6 +102,71 @@ vX7UjFFR
 -----END X509 CRL-----
 `)
 
+// Issuer of pemCRL (misc/x509/test.crt).
+var issuerCert = []byte(`-----BEGIN CERTIFICATE-----
+MIIDwzCCAqugAwIBAgIJAORjqRYVWiVkMA0GCSqGSIb3DQEBCwUAMHgxCzAJBgNV
+BAYTAkNIMQ8wDQYDVQQIDAZHZW5ldmExGDAWBgNVBAcMD1BsYW4tbGVzLU91YXRl
+czEdMBsGA1UECgwUZS1YcGVydCBTb2x1dGlvbnMgU0ExCzAJBgNVBAsMAklUMRIw
+EAYDVQQDDAlsb2NhbGhvc3QwHhcNMTcwOTA0MTEzNzIxWhcNMjMwMjI1MTEzNzIx
+WjB4MQswCQYDVQQGEwJDSDEPMA0GA1UECAwGR2VuZXZhMRgwFgYDVQQHDA9QbGFu
+LWxlcy1PdWF0ZXMxHTAbBgNVBAoMFGUtWHBlcnQgU29sdXRpb25zIFNBMQswCQYD
+VQQLDAJJVDESMBAGA1UEAwwJbG9jYWxob3N0MIIBIjANBgkqhkiG9w0BAQEFAAOC
+AQ8AMIIBCgKCAQEAvJYz2O80bWmrg1lNDXUi14BMyRcKdqcUsXB5tQgxbIiL8t8b
+mq7Rm2FqEvFQOtPcTnDlZcVJFRyNFRUGv/HUF049BZsZ6A9FgqkCkS9f2ywwPRPR
+pMGRHYtNss/lha14mwLv5DzolGunt3iVvNleIbm8M/Buih5QOTZ7CS2oiSz2aTym
+NftAFSJwvfRdm69JUW9k07xSmo/Xx+G21b79UWCO6ssogJDJ7ppzZglzi8Y9W2mV
+RkP5kmJjrIEUCVI5NOXztHfIWZXQ7F2eLkQHrDifExQUwrpEsAEEbqwjfWkNBbQB
+C1qHas0c5h9npHk5XPQAfZzuhOTkoICBJxd2YQIDAQABo1AwTjAdBgNVHQ4EFgQU
+u9lMF6J5PRNHmLh8te1dLdvRfd4wHwYDVR0jBBgwFoAUu9lMF6J5PRNHmLh8te1d
+LdvRfd4wDAYDVR0TBAUwAwEB/zANBgkqhkiG9w0BAQsFAAOCAQEAGoKvbSQxGFIW
+nc0RhSOKbMT4tRkigMsH9O4twcopQIzACjslGMHIIpwuBrB7QdP7y+jorvCZrIVA
+VVuK2qwydHhTkZgTTwjaihSITp/BW9yJ4fZxYiWEeC+rtl9xISpe+sT749sTC1ZX
+r3SmtW704v8vkxAnI8ujOcAMOukZVbO5xky+4vfHldAA7YjaWEt+nOzFmL82acAD
+MCR0QL7cdJtsQLCepzziNrelC/DMI+uiZPEpcJJX2RpgSo/pbasND8ztfP5Sfn7S
+ewNzR3fVjmGZIrT8vTnqQG1M4b6iYk2/CCmOCvdccduFtS/mMLCrP1OyoDrZeaFg
+ig+KYMwjTg==
+-----END CERTIFICATE-----
+`)
+
+func unsafeParseCertificate(pemCert []byte) *x509.Certificate {
+	block, _ := pem.Decode(pemCert)
+	if block == nil || block.Type != "CERTIFICATE" {
+		panic("invalid test PEM certificate data")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		panic("cannot parse test certificate: " + err.Error())
+	}
+	return cert
+}
+
+// newSelfSignedCert generates a self-signed certificate for the given common
+// name.
+func newSelfSignedCert(cn string) *x509.Certificate {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		panic("cannot generate test key: " + err.Error())
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCRLSign | x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		panic("cannot create test certificate: " + err.Error())
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic("cannot parse test certificate: " + err.Error())
+	}
+	return cert
+}
+
 func TestFetchCRL(t *testing.T) {
 	t.Run("Valid DER CRL", testFetchCRLWithValidCRL)
 	t.Run("Valid PEM CRL", testFetchCRLWithValidPEMCRL)
@@ -101,6 +175,8 @@ func TestFetchCRL(t *testing.T) {
 	t.Run("Invalid URL", testFetchCRLWithInvalidURL)
 	t.Run("HTTP Error", testFetchCRLWithHTTPError)
 	t.Run("Expired CRL", testFetchCRLWithExpiredCRL)
+	t.Run("Unexpected HTTP Status", testFetchCRLWithUnexpectedHTTPStatus)
+	t.Run("Conditional Request", testFetchCRLConditional)
 }
 
 func testFetchCRLWithValidCRL(t *testing.T) {
@@ -108,7 +184,7 @@ func testFetchCRLWithValidCRL(t *testing.T) {
 		w.Write(derCRL)
 	}))
 	defer ts.Close()
-	crl, err := fetchCRL(ts.URL)
+	crl, err := fetchCRL(ts.URL, nil)
 	if err != nil {
 		t.Fatalf("fetchCRL: unexpected error %q", err.Error())
 	}
@@ -122,7 +198,7 @@ func testFetchCRLWithValidPEMCRL(t *testing.T) {
 		w.Write(pemCRL)
 	}))
 	defer ts.Close()
-	crl, err := fetchCRL(ts.URL)
+	crl, err := fetchCRL(ts.URL, nil)
 	if err != nil {
 		t.Fatalf("fetchCRL: unexpected error %q", err.Error())
 	}
@@ -136,7 +212,7 @@ func testFetchCRLWithInvalidCRL(t *testing.T) {
 		w.Write(malformedCRL)
 	}))
 	defer ts.Close()
-	_, err := fetchCRL(ts.URL)
+	_, err := fetchCRL(ts.URL, nil)
 	switch err {
 	case nil:
 		t.Error("fetchCRL: expected error; got nil")
@@ -153,7 +229,7 @@ func testFetchCRLWithInvalidPEMCRL(t *testing.T) {
 		w.Write(malformedPEMCRL)
 	}))
 	defer ts.Close()
-	_, err := fetchCRL(ts.URL)
+	_, err := fetchCRL(ts.URL, nil)
 	switch err {
 	case nil:
 		t.Error("fetchCRL: expected error; got nil")
@@ -166,7 +242,7 @@ func testFetchCRLWithInvalidPEMCRL(t *testing.T) {
 }
 
 func testFetchCRLWithInvalidURL(t *testing.T) {
-	_, err := fetchCRL("some-invalid-url")
+	_, err := fetchCRL("some-invalid-url", nil)
 	switch err {
 	case nil:
 		t.Error("fetchCRL: expected error; got nil")
@@ -184,7 +260,7 @@ func testFetchCRLWithHTTPError(t *testing.T) {
 		http.Error(w, "not found", http.StatusNotFound)
 	}))
 	defer ts.Close()
-	_, err := fetchCRL(ts.URL)
+	_, err := fetchCRL(ts.URL, nil)
 	switch err {
 	case nil:
 		t.Error("fetchCRL: expected error; got nil")
@@ -201,7 +277,7 @@ func testFetchCRLWithExpiredCRL(t *testing.T) {
 		w.Write(expiredCRL)
 	}))
 	defer ts.Close()
-	_, err := fetchCRL(ts.URL)
+	_, err := fetchCRL(ts.URL, nil)
 	switch err {
 	case nil:
 		t.Error("fetchCRL: expected error; got nil")
@@ -213,6 +289,188 @@ func testFetchCRLWithExpiredCRL(t *testing.T) {
 	}
 }
 
+func TestParseCRL(t *testing.T) {
+	crl, err := parseCRL(pemCRL)
+	if err != nil {
+		t.Fatalf("parseCRL: unexpected error %q", err.Error())
+	}
+	if got, want := crl.TBSCertList.ThisUpdate.Unix(), int64(1504526650); got != want {
+		t.Errorf("parseCRL: got this update %d; want %d", got, want)
+	}
+	if _, err := parseCRL(malformedPEMCRL); err == nil {
+		t.Error("parseCRL: expected error, got nil")
+	}
+}
+
+func TestLoadCertificates(t *testing.T) {
+	f, err := ioutil.TempFile(os.TempDir(), "crl2f5-connector-test")
+	if err != nil {
+		t.Fatal("setup: ", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	// Bundle of two certificates with some noise in between.
+	bundle := append(append([]byte{}, issuerCert...), []byte("some comment\n")...)
+	bundle = append(bundle, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: newSelfSignedCert("other").Raw,
+	})...)
+	if _, err := f.Write(bundle); err != nil {
+		t.Fatal("setup: ", err)
+	}
+
+	certs, err := loadCertificates(f.Name())
+	if err != nil {
+		t.Fatalf("loadCertificates: unexpected error %q", err.Error())
+	}
+	if got := len(certs); got != 2 {
+		t.Fatalf("loadCertificates: got %d certificates; want %d", got, 2)
+	}
+	if got, want := certs[0].Subject.CommonName, "localhost"; got != want {
+		t.Errorf("loadCertificates: got common name %q; want %q", got, want)
+	}
+
+	if _, err := loadCertificates("some-path-that-does-not-exist"); err == nil {
+		t.Error("loadCertificates: expected error, got nil")
+	}
+}
+
+func TestCRLNumber(t *testing.T) {
+	crl, err := parseCRL(pemCRL)
+	if err != nil {
+		t.Fatal("setup: ", err)
+	}
+	if n, err := crlNumber(crl); err != nil {
+		t.Errorf("crlNumber: unexpected error %q", err.Error())
+	} else if n != nil {
+		t.Errorf("crlNumber: got %v; want nil", n)
+	}
+
+	value, err := asn1.Marshal(big.NewInt(42))
+	if err != nil {
+		t.Fatal("setup: ", err)
+	}
+	crl.TBSCertList.Extensions = append(crl.TBSCertList.Extensions, pkix.Extension{
+		Id:    oidCRLNumber,
+		Value: value,
+	})
+	if n, err := crlNumber(crl); err != nil {
+		t.Errorf("crlNumber: unexpected error %q", err.Error())
+	} else if n == nil || n.Int64() != 42 {
+		t.Errorf("crlNumber: got %v; want 42", n)
+	}
+}
+
+func TestVerifyCRL(t *testing.T) {
+	crl, err := parseCRL(pemCRL)
+	if err != nil {
+		t.Fatal("setup: ", err)
+	}
+	issuer := unsafeParseCertificate(issuerCert)
+
+	// Same subject and key identifier as the issuer, but different key.
+	otherKey := newSelfSignedCert("localhost")
+	wrongKey := *issuer
+	wrongKey.PublicKey = otherKey.PublicKey
+
+	// Same subject as the issuer, but different key identifier.
+	wrongKeyID := *issuer
+	wrongKeyID.SubjectKeyId = []byte{0x01, 0x02, 0x03}
+
+	tests := []struct {
+		issuers []*x509.Certificate
+		wantErr string
+	}{
+		{
+			issuers: []*x509.Certificate{issuer},
+		},
+		{
+			issuers: []*x509.Certificate{newSelfSignedCert("other"), issuer},
+		},
+		{
+			issuers: nil,
+			wantErr: "cannot verify crl: no issuer certificate provided",
+		},
+		{
+			issuers: []*x509.Certificate{newSelfSignedCert("other")},
+			wantErr: "crl issuer does not match any issuer certificate",
+		},
+		{
+			issuers: []*x509.Certificate{&wrongKeyID},
+			wantErr: "crl authority key identifier does not match issuer certificate",
+		},
+		{
+			issuers: []*x509.Certificate{&wrongKey},
+			wantErr: "invalid crl signature: crypto/rsa: verification error",
+		},
+	}
+	for i, test := range tests {
+		err := verifyCRL(crl, test.issuers)
+		switch {
+		case err == nil && test.wantErr != "":
+			t.Errorf("%d. verifyCRL: expected error %q, got nil", i, test.wantErr)
+		case err != nil && err.Error() != test.wantErr:
+			t.Errorf("%d. verifyCRL: got error %q; want %q", i, err.Error(), test.wantErr)
+		}
+	}
+}
+
+func testFetchCRLWithUnexpectedHTTPStatus(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMultipleChoices)
+	}))
+	defer ts.Close()
+	_, err := fetchCRL(ts.URL, new(httpCache))
+	switch err {
+	case nil:
+		t.Error("fetchCRL: expected error; got nil")
+	default:
+		wantErr := "cannot fetch crl due to unexpected http status: 300 Multiple Choices"
+		if got := err.Error(); got != wantErr {
+			t.Errorf("fetchCRL: got error %q; want %q", got, wantErr)
+		}
+	}
+}
+
+func testFetchCRLConditional(t *testing.T) {
+	const (
+		etag         = `"5a1c-55c8e0c1e0c40"`
+		lastModified = "Mon, 04 Sep 2017 12:04:10 GMT"
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag && r.Header.Get("If-Modified-Since") == lastModified {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", lastModified)
+		w.Write(pemCRL)
+	}))
+	defer ts.Close()
+
+	cache := new(httpCache)
+	crl, err := fetchCRL(ts.URL, cache)
+	if err != nil {
+		t.Fatalf("fetchCRL: unexpected error %q", err.Error())
+	}
+	if !bytes.Equal(crl, pemCRL) {
+		t.Errorf("fetchCRL: got \"%s\"; want \"%s\"", crl, pemCRL)
+	}
+	if cache.etag != etag || cache.lastModified != lastModified {
+		t.Errorf("fetchCRL: got cache %+v; want etag %q and last modified %q", *cache, etag, lastModified)
+	}
+
+	if _, err := fetchCRL(ts.URL, cache); err != errNotModified {
+		t.Errorf("fetchCRL: got error %v; want %v", err, errNotModified)
+	}
+
+	// Without cache, the request must not be conditional.
+	if _, err := fetchCRL(ts.URL, nil); err != nil {
+		t.Errorf("fetchCRL: unexpected error %q", err.Error())
+	}
+}
+
 func TestIsPEM(t *testing.T) {
 	type testCase struct {
 		data []byte